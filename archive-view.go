@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/sprig/core"
+	sprigWidget "git.sr.ht/~whereswaldon/sprig/widget"
+	sprigTheme "git.sr.ht/~whereswaldon/sprig/widget/theme"
+)
+
+// ArchiveView exports communities to archive files and imports archives
+// into the local store.
+type ArchiveView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Exports    []ExportControl
+	ImportForm sprigWidget.TextForm
+
+	// lock guards status, which is updated by exports and imports running
+	// in the background.
+	lock   sync.Mutex
+	status string
+}
+
+// ExportControl holds the UI state for exporting a single community.
+type ExportControl struct {
+	*forest.Community
+	Export widget.Clickable
+}
+
+var _ View = &ArchiveView{}
+
+func NewArchiveView(app core.App) View {
+	c := &ArchiveView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	c.ImportForm.TextField.SingleLine = true
+	c.ImportForm.TextField.Submit = true
+	return c
+}
+
+func (c *ArchiveView) HandleIntent(intent Intent) {}
+
+func (c *ArchiveView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Archives", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *ArchiveView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *ArchiveView) BecomeVisible() {
+	c.Arbor().Communities().WithCommunities(func(communities []*forest.Community) {
+		c.Exports = make([]ExportControl, len(communities))
+		for i, community := range communities {
+			c.Exports[i].Community = community
+		}
+	})
+}
+
+func (c *ArchiveView) Update(gtx layout.Context) {
+	for i := range c.Exports {
+		export := &c.Exports[i]
+		if export.Export.Clicked() {
+			community := export.Community
+			c.setStatus("Exporting " + string(community.Name.Blob) + "...")
+			go func() {
+				path, count, err := exportArchive(c.App, community.ID(), string(community.Name.Blob))
+				if err != nil {
+					c.setStatus(fmt.Sprintf("Export failed: %v", err))
+					return
+				}
+				c.setStatus(fmt.Sprintf("Exported %d nodes to %s", count, path))
+			}()
+		}
+	}
+	if c.ImportForm.Submitted() {
+		path := strings.TrimSpace(c.ImportForm.TextField.Text())
+		c.setStatus("Importing " + path + "...")
+		go func() {
+			result, err := importArchive(c.App, path)
+			if err != nil {
+				c.setStatus(fmt.Sprintf("Import failed after %v: %v", result, err))
+				return
+			}
+			c.setStatus("Imported " + path + ": " + result.String())
+		}()
+	}
+}
+
+// setStatus replaces the status line and redraws the view.
+func (c *ArchiveView) setStatus(status string) {
+	c.lock.Lock()
+	c.status = status
+	c.lock.Unlock()
+	c.manager.RequestInvalidate()
+}
+
+func (c *ArchiveView) Layout(gtx layout.Context) layout.Dimensions {
+	sTheme := c.Theme().Current()
+	theme := sTheme.Theme
+	c.lock.Lock()
+	status := c.status
+	c.lock.Unlock()
+
+	items := []layout.Widget{
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.H6(theme, "Export").Layout)
+		},
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "Archives are written to "+c.Settings().ArchivesDir()).Layout)
+		},
+	}
+	for i := range c.Exports {
+		export := &c.Exports[i]
+		items = append(items, func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return itemInset.Layout(gtx, material.Body1(theme, string(export.Name.Blob)).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, &export.Export, "Export").Layout)
+				}),
+			)
+		})
+	}
+	items = append(items,
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.H6(theme, "Import").Layout)
+		},
+		func(gtx C) D {
+			return itemInset.Layout(gtx, func(gtx C) D {
+				return sprigTheme.TextForm(sTheme, &c.ImportForm, "Import", "Path to archive").Layout(gtx)
+			})
+		},
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "Every message is checked against its author's signature before it is imported.").Layout)
+		},
+	)
+	if status != "" {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body1(theme, status).Layout)
+		})
+	}
+	return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+		return items[index](gtx)
+	})
+}
+
+func (c *ArchiveView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
+
+// exportArchive writes the subtree rooted at root to a new file in the
+// archives directory, naming it after the provided name. It returns the path
+// of the file and the number of nodes written.
+func exportArchive(app core.App, root *fields.QualifiedHash, name string) (string, int, error) {
+	dir := app.Settings().ArchivesDir()
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", 0, fmt.Errorf("failed creating archives directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", safeFileName(name), time.Now().Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed creating archive: %w", err)
+	}
+	count, err := core.ExportArchive(app.Arbor().Store(), root, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+	return path, count, nil
+}
+
+// safeFileName replaces the characters of name that cannot appear in a file
+// name.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// importArchive adds the nodes of the archive at path to the store.
+func importArchive(app core.App, path string) (core.ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return core.ImportResult{}, fmt.Errorf("failed opening archive: %w", err)
+	}
+	defer file.Close()
+	return core.ImportArchive(app.Arbor().Store(), file)
+}
@@ -0,0 +1,77 @@
+package main
+
+import "sync"
+
+// BackgroundOperation runs the slow operations of a view, such as disk or
+// network access, outside of the UI goroutine. Only one operation runs at a
+// time, and the message describing the outcome of the last one is kept for
+// display. The zero value is ready for use.
+type BackgroundOperation struct {
+	lock    sync.Mutex
+	working bool
+	result  string
+	// finished holds the functions that operations queued to run on the UI
+	// goroutine.
+	finished []func()
+}
+
+// Run starts operation in the background unless another operation is still
+// working, and reports whether it was started. Once it finishes, its message
+// or error becomes the result and the manager is asked to redraw.
+func (b *BackgroundOperation) Run(manager ViewManager, operation func() (string, error)) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.working {
+		return false
+	}
+	b.working = true
+	b.result = ""
+	go func() {
+		result, err := operation()
+		if err != nil {
+			result = err.Error()
+		}
+		b.lock.Lock()
+		b.working = false
+		b.result = result
+		b.lock.Unlock()
+		manager.RequestInvalidate()
+	}()
+	return true
+}
+
+// Then queues f to run on the UI goroutine during the next call to Update.
+// Operations use it to change the state of their view.
+func (b *BackgroundOperation) Then(f func()) {
+	b.lock.Lock()
+	b.finished = append(b.finished, f)
+	b.lock.Unlock()
+}
+
+// Update runs the functions queued with Then. Views call it from their own
+// Update method.
+func (b *BackgroundOperation) Update() {
+	b.lock.Lock()
+	finished := b.finished
+	b.finished = nil
+	b.lock.Unlock()
+	for _, f := range finished {
+		f()
+	}
+}
+
+// State returns whether an operation is working and the result of the last
+// one.
+func (b *BackgroundOperation) State() (working bool, result string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.working, b.result
+}
+
+// SetResult replaces the displayed result, such as with the outcome of a
+// quick operation performed on the UI goroutine.
+func (b *BackgroundOperation) SetResult(result string) {
+	b.lock.Lock()
+	b.result = result
+	b.lock.Unlock()
+}
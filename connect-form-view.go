@@ -43,9 +43,10 @@ func (c *ConnectFormView) AppBarData() (bool, string, []materials.AppBarAction,
 
 func (c *ConnectFormView) Update(gtx layout.Context) {
 	if c.Form.Submitted() {
-		c.Settings().SetAddress(c.Form.TextField.Text())
+		addr := c.Form.TextField.Text()
+		c.Settings().AddAddress(addr)
 		go c.Settings().Persist()
-		c.Sprout().ConnectTo(c.Settings().Address())
+		c.Sprout().ConnectTo(addr)
 		c.manager.RequestViewSwitch(IdentityFormID)
 	}
 }
@@ -44,7 +44,7 @@ func (c *ConsentView) Update(gtx layout.Context) {
 	if c.AgreeButton.Clicked() {
 		c.Settings().SetAcknowledgedNoticeVersion(NoticeVersion)
 		go c.Settings().Persist()
-		if c.Settings().Address() == "" {
+		if len(c.Settings().Addresses()) == 0 {
 			c.manager.RequestViewSwitch(ConnectFormID)
 		} else {
 			c.manager.RequestViewSwitch(SettingsID)
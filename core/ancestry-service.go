@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// AncestryService fetches the nodes referenced by stored nodes when they are
+// missing from the store. Replies cannot be displayed until their parent,
+// author, and community are known, so once the missing nodes arrive the
+// replies that referenced them are replayed to the lists showing the store.
+type AncestryService interface {
+	// Pending returns the number of missing nodes that have not been
+	// found yet.
+	Pending() int
+}
+
+const (
+	// ancestryScanLimit bounds the number of stored replies checked for
+	// missing references at launch.
+	ancestryScanLimit = 1024
+	// ancestryBatchSize is the number of missing nodes requested from a
+	// relay at once.
+	ancestryBatchSize = 32
+	// ancestryRetryInterval is how often nodes that no relay returned are
+	// requested again.
+	ancestryRetryInterval = 30 * time.Second
+	// ancestryMaxAttempts is the number of times a missing node is
+	// requested before giving up on it.
+	ancestryMaxAttempts = 5
+	// ancestryTimeout bounds each request to a relay.
+	ancestryTimeout = 30 * time.Second
+)
+
+// replayer is implemented by stores that can deliver stored nodes to the
+// lists showing them again.
+type replayer interface {
+	Replay(nodes ...forest.Node)
+}
+
+type ancestryService struct {
+	ArborService
+	SproutService
+
+	sync.Mutex
+	// waiting maps the ID of each missing node to the nodes that reference
+	// it.
+	waiting map[string][]forest.Node
+	// missing holds the ID of each missing node that should still be
+	// requested, along with the number of attempts made so far.
+	missing map[string]*ancestryRequest
+	wake    chan struct{}
+}
+
+// ancestryRequest tracks the attempts to fetch a single missing node.
+type ancestryRequest struct {
+	id       *fields.QualifiedHash
+	attempts int
+}
+
+var _ AncestryService = &ancestryService{}
+
+func newAncestryService(tasks *taskGroup, arbor ArborService, sprout SproutService) (AncestryService, error) {
+	a := &ancestryService{
+		ArborService:  arbor,
+		SproutService: sprout,
+		waiting:       make(map[string][]forest.Node),
+		missing:       make(map[string]*ancestryRequest),
+		wake:          make(chan struct{}, 1),
+	}
+	tasks.Subscribe(arbor.Store(), func(node forest.Node) {
+		tasks.Go(func(context.Context) {
+			a.handleNode(node)
+		})
+	})
+	tasks.Go(a.scanStored)
+	tasks.Go(a.run)
+	return a, nil
+}
+
+// scanStored checks the most recent stored replies for missing references.
+func (a *ancestryService) scanStored(ctx context.Context) {
+	nodes, err := a.ArborService.Store().Recent(fields.NodeTypeReply, ancestryScanLimit)
+	if err != nil {
+		log.Printf("ancestry: failed loading stored replies: %v", err)
+	}
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return
+		}
+		a.check(node)
+	}
+}
+
+// handleNode delivers the nodes waiting on node once they are complete, and
+// records any references of node that are missing.
+func (a *ancestryService) handleNode(node forest.Node) {
+	id := node.ID().String()
+	a.Lock()
+	dependents := a.waiting[id]
+	delete(a.waiting, id)
+	delete(a.missing, id)
+	a.Unlock()
+	for _, dependent := range dependents {
+		// Dependents that are still missing other nodes are delivered
+		// once the last of them arrives.
+		if a.check(dependent) {
+			if r, ok := a.ArborService.Store().(replayer); ok {
+				r.Replay(dependent)
+			}
+		}
+	}
+	a.check(node)
+}
+
+// check records each node referenced by node that is missing from the store
+// and reports whether none are missing.
+func (a *ancestryService) check(node forest.Node) bool {
+	references := []*fields.QualifiedHash{node.ParentID()}
+	switch n := node.(type) {
+	case *forest.Identity:
+		return true
+	case *forest.Reply:
+		references = append(references, &n.Author, &n.CommunityID)
+	default:
+		references = append(references, node.AuthorID())
+	}
+	complete := true
+	s := a.ArborService.Store()
+	for _, ref := range references {
+		if ref.Equals(fields.NullHash()) {
+			continue
+		}
+		if _, has, err := s.Get(ref); err != nil {
+			log.Printf("ancestry: failed looking up %s: %v", ref, err)
+			continue
+		} else if has {
+			continue
+		}
+		complete = false
+		a.queue(ref, node)
+	}
+	return complete
+}
+
+// queue records that dependent is waiting for the node with the given ID and
+// requests that node if it is not already being requested.
+func (a *ancestryService) queue(id *fields.QualifiedHash, dependent forest.Node) {
+	key := id.String()
+	a.Lock()
+	defer a.Unlock()
+	for _, waiting := range a.waiting[key] {
+		if waiting.ID().Equals(dependent.ID()) {
+			return
+		}
+	}
+	a.waiting[key] = append(a.waiting[key], dependent)
+	if _, requested := a.missing[key]; requested {
+		return
+	}
+	a.missing[key] = &ancestryRequest{id: id}
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *ancestryService) Pending() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.waiting)
+}
+
+// run requests missing nodes from the connected relays until ctx is
+// cancelled.
+func (a *ancestryService) run(ctx context.Context) {
+	ticker := time.NewTicker(ancestryRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wake:
+		case <-ticker.C:
+		}
+		a.fetch(ctx)
+	}
+}
+
+// fetch requests every missing node that has attempts remaining from each
+// connected relay until it is found. Each relay validates and stores the
+// nodes that it returns, which in turn delivers them to handleNode. Nodes
+// that were not found after their last attempt are given up on, along with
+// the nodes waiting for them. Attempts are only counted while relays are
+// connected.
+func (a *ancestryService) fetch(ctx context.Context) {
+	connections := a.SproutService.Connections()
+	var ids []*fields.QualifiedHash
+	a.Lock()
+	for key, request := range a.missing {
+		if request.attempts >= ancestryMaxAttempts {
+			log.Printf("ancestry: giving up on %s after %d attempts", request.id, request.attempts)
+			delete(a.missing, key)
+			delete(a.waiting, key)
+			continue
+		}
+		if len(connections) == 0 {
+			continue
+		}
+		request.attempts++
+		ids = append(ids, request.id)
+	}
+	a.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	for _, addr := range connections {
+		worker := a.SproutService.WorkerFor(addr)
+		if worker == nil {
+			continue
+		}
+		for start := 0; start < len(ids); start += ancestryBatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			end := start + ancestryBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			var batch []*fields.QualifiedHash
+			a.Lock()
+			for _, id := range ids[start:end] {
+				if _, stillMissing := a.missing[id.String()]; stillMissing {
+					batch = append(batch, id)
+				}
+			}
+			a.Unlock()
+			if len(batch) == 0 {
+				continue
+			}
+			timeout := time.NewTimer(ancestryTimeout)
+			response, err := worker.SendQuery(batch, timeout.C)
+			timeout.Stop()
+			if err != nil {
+				log.Printf("ancestry: failed querying %s: %v", addr, err)
+				continue
+			}
+			for _, node := range response.Nodes {
+				a.ArborService.Propagation().Record(node.ID(), addr, Received)
+				if err := worker.IngestNode(node); err != nil {
+					log.Printf("ancestry: failed ingesting %s from %s: %v", node.ID(), addr, err)
+				}
+			}
+		}
+	}
+}
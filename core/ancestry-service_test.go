@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprout-go"
+)
+
+// ancestryArbor and ancestrySprout provide the store and the relay
+// connections used by an ancestryService. The relays have no workers, so
+// nothing is ever found.
+type ancestryArbor struct {
+	ArborService
+	store *swappableStore
+}
+
+func (a ancestryArbor) Store() store.ExtendedStore { return a.store }
+
+type ancestrySprout struct {
+	SproutService
+	connections []string
+}
+
+func (s ancestrySprout) Connections() []string           { return s.connections }
+func (s ancestrySprout) WorkerFor(string) *sprout.Worker { return nil }
+
+func newTestAncestryService(s *swappableStore, connections ...string) *ancestryService {
+	return &ancestryService{
+		ArborService:  ancestryArbor{store: s},
+		SproutService: ancestrySprout{connections: connections},
+		waiting:       make(map[string][]forest.Node),
+		missing:       make(map[string]*ancestryRequest),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func TestAncestryReplaysCompletedNodes(t *testing.T) {
+	tree := newTestTree(t)
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, tree.identity, tree.community, tree.reply)
+	a := newTestAncestryService(s)
+	if a.check(tree.reply) {
+		t.Fatalf("reply without its parent was reported complete")
+	}
+	if pending := a.Pending(); pending != 1 {
+		t.Fatalf("%d nodes pending, expected 1", pending)
+	}
+
+	var added, replayed []forest.Node
+	s.SubscribeToNewMessages(func(node forest.Node) {
+		added = append(added, node)
+	})
+	s.SubscribeToReplays(func(node forest.Node) {
+		replayed = append(replayed, node)
+	})
+	addTestNodes(t, s, tree.conversation)
+	a.handleNode(tree.conversation)
+	if pending := a.Pending(); pending != 0 {
+		t.Errorf("%d nodes pending after the parent arrived, expected 0", pending)
+	}
+	if len(replayed) != 1 || !replayed[0].Equals(tree.reply) {
+		t.Errorf("replayed %d nodes, expected only the reply", len(replayed))
+	}
+	if len(added) != 1 || !added[0].Equals(tree.conversation) {
+		t.Errorf("new message subscribers received %d nodes, expected only the parent", len(added))
+	}
+}
+
+func TestAncestryGivesUp(t *testing.T) {
+	tree := newTestTree(t)
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, tree.identity, tree.community, tree.reply)
+
+	// Attempts are not used up while no relay is connected.
+	a := newTestAncestryService(s)
+	a.check(tree.reply)
+	for i := 0; i <= ancestryMaxAttempts; i++ {
+		a.fetch(context.Background())
+	}
+	if pending := a.Pending(); pending != 1 {
+		t.Errorf("%d nodes pending without relays, expected 1", pending)
+	}
+
+	a = newTestAncestryService(s, "pipe://relay")
+	a.check(tree.reply)
+	for i := 0; i < ancestryMaxAttempts; i++ {
+		a.fetch(context.Background())
+	}
+	if pending := a.Pending(); pending != 1 {
+		t.Errorf("%d nodes pending before the last attempt failed, expected 1", pending)
+	}
+	a.fetch(context.Background())
+	if pending := a.Pending(); pending != 0 {
+		t.Errorf("%d nodes pending after every attempt failed, expected 0", pending)
+	}
+}
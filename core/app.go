@@ -1,11 +1,13 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
-	gioapp "gioui.org/app"
 	"git.sr.ht/~whereswaldon/forest-go"
 )
 
@@ -19,7 +21,12 @@ type App interface {
 	Status() StatusService
 	Haptic() HapticService
 	Banner() BannerService
-	Window() *gioapp.Window
+	Outbox() OutboxService
+	Search() SearchService
+	AncestryRepair() AncestryService
+	// Invalidator returns the means of requesting that the user interface
+	// be redrawn.
+	Invalidator() Invalidator
 	Shutdown()
 }
 
@@ -33,61 +40,121 @@ type app struct {
 	StatusService
 	HapticService
 	BannerService
-	window *gioapp.Window
+	OutboxService
+	// search is not embedded, as its Search method would conflict with
+	// the Search accessor.
+	search      SearchService
+	ancestry    AncestryService
+	invalidator Invalidator
+	tasks       *taskGroup
+	cancel      context.CancelFunc
+	shutdown    sync.Once
+
+	// configuration provided by Options for use during construction
+	ctx      context.Context
+	stateDir string
 }
 
 var _ App = &app{}
 
+// shutdownTimeout bounds how long Shutdown waits for background tasks.
+const shutdownTimeout = 10 * time.Second
+
 // NewApp constructs an App or fails with an error. This process will fail
-// if any of the application services fail to initialize correctly.
-func NewApp(w *gioapp.Window, stateDir string) (application App, err error) {
+// if any of the application services fail to initialize correctly. Services
+// that are not provided with an Option are constructed using the state
+// directory provided by WithStateDir. Without an Invalidator provided by
+// WithInvalidator, the App runs headless. The background work of the
+// services stops when the context provided by WithContext is cancelled or
+// when Shutdown is called.
+func NewApp(opts ...Option) (application App, err error) {
+	a := &app{
+		ctx: context.Background(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
 	defer func() {
 		if err != nil {
+			cancel()
 			err = fmt.Errorf("failed constructing app: %w", err)
 		}
 	}()
-	a := &app{
-		window: w,
+	a.tasks = newTaskGroup(ctx)
+	a.cancel = cancel
+	if a.invalidator == nil {
+		a.invalidator = NoopInvalidator{}
 	}
 
-	// ensure our state directory exists
-	if err := os.MkdirAll(stateDir, 0770); err != nil {
-		return nil, err
-	}
-
-	// Instantiate all of the services.
+	// Instantiate all of the services that were not provided.
 	// Settings must be initialized first, as other services rely on derived
 	// values from it
-	if a.SettingsService, err = newSettingsService(stateDir); err != nil {
-		return nil, err
+	if a.SettingsService == nil {
+		if a.stateDir == "" {
+			return nil, fmt.Errorf("no state directory provided")
+		}
+		// ensure our state directory exists
+		if err := os.MkdirAll(a.stateDir, 0770); err != nil {
+			return nil, err
+		}
+		if a.SettingsService, err = newSettingsService(a.stateDir); err != nil {
+			return nil, err
+		}
 	}
-	a.BannerService = NewBannerService(a)
-	if a.ArborService, err = newArborService(a.SettingsService); err != nil {
-		return nil, err
+	a.BannerService = NewBannerService(a.invalidator)
+	if a.ArborService == nil {
+		if a.ArborService, err = newArborService(a.tasks, a.SettingsService, a.BannerService); err != nil {
+			return nil, err
+		}
 	}
-	if a.NotificationService, err = newNotificationService(a.SettingsService, a.ArborService); err != nil {
-		return nil, err
+	if a.NotificationService == nil {
+		if a.NotificationService, err = newNotificationService(a.tasks, a.SettingsService, a.ArborService); err != nil {
+			return nil, err
+		}
 	}
-	if a.SproutService, err = newSproutService(a.ArborService, a.BannerService, a.SettingsService); err != nil {
-		return nil, err
+	if a.SproutService == nil {
+		if a.SproutService, err = newSproutService(a.tasks, a.ArborService, a.BannerService, a.SettingsService); err != nil {
+			return nil, err
+		}
+	}
+	if a.ancestry == nil {
+		if a.ancestry, err = newAncestryService(a.tasks, a.ArborService, a.SproutService); err != nil {
+			return nil, err
+		}
+	}
+	if a.OutboxService == nil {
+		if a.OutboxService, err = newOutboxService(a.tasks, a.SettingsService, a.ArborService, a.SproutService, a.invalidator); err != nil {
+			return nil, err
+		}
+	}
+	if a.search == nil {
+		if a.search, err = newSearchService(a.tasks, a.ArborService, a.invalidator); err != nil {
+			return nil, err
+		}
 	}
 	if a.ThemeService, err = newThemeService(); err != nil {
 		return nil, err
 	}
-	if a.StatusService, err = newStatusService(); err != nil {
+	if a.StatusService, err = newStatusService(a.tasks); err != nil {
 		return nil, err
 	}
-	a.HapticService = newHapticService(w)
+	if a.HapticService == nil {
+		a.HapticService = NoopHapticService{}
+	}
 
 	// Connect services together
-	if addr := a.Settings().Address(); addr != "" {
+	a.Sprout().SubscribeToStatus(func(ConnectionStatus) {
+		a.Invalidator().Invalidate()
+	})
+	for _, addr := range a.Settings().Addresses() {
 		a.Sprout().ConnectTo(addr)
 	}
 	a.Notifications().Register(a.Arbor().Store())
 	a.Status().Register(a.Arbor().Store())
 
-	a.Arbor().Store().SubscribeToNewMessages(func(n forest.Node) {
-		a.Window().Invalidate()
+	a.tasks.Subscribe(a.Arbor().Store(), func(n forest.Node) {
+		a.Invalidator().Invalidate()
 	})
 
 	return a, nil
@@ -133,14 +200,44 @@ func (a *app) Banner() BannerService {
 	return a.BannerService
 }
 
-// Shutdown performs cleanup, and blocks for the duration.
+// Shutdown performs cleanup, and blocks for the duration. It stops the
+// background work of every service, waiting up to shutdownTimeout for it to
+// finish, then saves the settings and closes the store. Calls after the
+// first have no effect.
 func (a *app) Shutdown() {
-	log.Printf("cleaning up")
-	defer log.Printf("shutting down")
-	a.Sprout().MarkSelfOffline()
+	a.shutdown.Do(func() {
+		log.Printf("cleaning up")
+		defer log.Printf("shutting down")
+		a.Sprout().MarkSelfOffline()
+		a.cancel()
+		if err := a.tasks.Wait(shutdownTimeout); err != nil {
+			log.Printf("%v", err)
+		}
+		if err := a.Settings().Persist(); err != nil {
+			log.Printf("failed saving settings: %v", err)
+		}
+		if err := a.Arbor().Close(); err != nil {
+			log.Printf("%v", err)
+		}
+	})
+}
+
+// Outbox returns the app's outbox service implementation.
+func (a *app) Outbox() OutboxService {
+	return a.OutboxService
+}
+
+func (a *app) Search() SearchService {
+	return a.search
+}
+
+// AncestryRepair returns the app's ancestry service implementation.
+func (a *app) AncestryRepair() AncestryService {
+	return a.ancestry
 }
 
-// Window returns the window handle.
-func (a app) Window() *gioapp.Window {
-	return a.window
+// Invalidator returns the window handle, or a NoopInvalidator if the App is
+// headless.
+func (a *app) Invalidator() Invalidator {
+	return a.invalidator
 }
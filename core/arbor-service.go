@@ -1,17 +1,18 @@
 package core
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	status "git.sr.ht/~athorp96/forest-ex/active-status"
 	"git.sr.ht/~athorp96/forest-ex/expiration"
 	"git.sr.ht/~whereswaldon/forest-go"
-	"git.sr.ht/~whereswaldon/forest-go/grove"
-	"git.sr.ht/~whereswaldon/forest-go/orchard"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
 	"git.sr.ht/~whereswaldon/forest-go/store"
 	"git.sr.ht/~whereswaldon/sprig/ds"
 )
@@ -21,61 +22,226 @@ type ArborService interface {
 	Store() store.ExtendedStore
 	Communities() *ds.CommunityList
 	StartHeartbeat()
+	// Propagation reports which relays are known to have each node.
+	Propagation() *PropagationTracker
+	// StoreError returns the error that prevented the store from opening.
+	// While it is non-nil, nodes are kept in memory and lost on exit unless
+	// the store is recovered. It is nil once the store opens normally.
+	StoreError() error
+	// StoreReadOnly reports whether the store was recovered read-only.
+	StoreReadOnly() bool
+	// RecoverStore attempts to open the store after StoreError reported a
+	// failure, using the given strategy.
+	RecoverStore(StoreRecovery) error
+	// CheckIntegrity examines every stored node, moving corrupt Grove node
+	// files into quarantine if requested. The report is also saved to the
+	// quarantine directory.
+	CheckIntegrity(ctx context.Context, quarantine bool) (*IntegrityReport, error)
+	// StoreLocked reports whether the store is encrypted and waiting for
+	// its passphrase. While it is locked, nodes are kept in memory and are
+	// saved once the store is unlocked.
+	StoreLocked() bool
+	// UnlockStore opens the encrypted store with the passphrase.
+	UnlockStore(passphrase string) error
+	// EnableEncryption protects the store with the passphrase, migrating
+	// the existing nodes into an encrypted store.
+	EnableEncryption(passphrase string) error
+	// DisableEncryption migrates the nodes of the encrypted store back
+	// into a plaintext store.
+	DisableEncryption() error
+	// Prune removes the messages that the communities' retention policies
+	// no longer keep.
+	Prune(ctx context.Context) PruneResult
+	// Statistics describes the contents of the store. It reads every
+	// stored node, so it should be called in the background.
+	Statistics(ctx context.Context) (*StoreStatistics, error)
+	// Activity returns the removals made from the store since launch.
+	Activity() StoreActivity
+	// Close releases the underlying store. The store must not be used
+	// afterward.
+	Close() error
 }
 
 type arborService struct {
 	SettingsService
-	grove store.ExtendedStore
+	BannerService
+	tasks *taskGroup
+	grove *swappableStore
 	cl    *ds.CommunityList
-	done  chan struct{}
+	// propagation tracks which relays are known to have each node.
+	propagation *PropagationTracker
+
+	// backend is the store backend holding the node data.
+	backend StoreBackend
+
+	storeLock sync.Mutex
+	// storeErr is the reason the store failed to open, if it did.
+	storeErr error
+	// storeBanner alerts the user while the store is unavailable.
+	storeBanner *MessageBanner
+	readOnly    bool
+	// key decrypts the encrypted store, once it has been unlocked.
+	key *storeKey
+	// locked is set while the store key is needed but has not been
+	// provided.
+	locked bool
+	// cancelMigration stops the running migration, if any.
+	cancelMigration context.CancelFunc
+
+	heartbeatLock sync.Mutex
+	// stopHeartbeat stops the running heartbeat, if any, and announces
+	// that its identity is inactive.
+	stopHeartbeat func()
+
+	activityLock sync.Mutex
+	// activity records the removals made by the purger.
+	activity StoreActivity
 }
 
 var _ ArborService = &arborService{}
 
 // newArborService creates a new instance of the Arbor Service using
 // the provided Settings within the app to acquire configuration.
-func newArborService(settings SettingsService) (ArborService, error) {
+func newArborService(tasks *taskGroup, settings SettingsService, banner BannerService) (ArborService, error) {
+	path := settings.DataPath()
+	backend := settings.StoreBackend()
+	// Record the backend explicitly so that later changes to the preferred
+	// backend are recognized as requests to migrate.
+	settings.SetStoreBackend(backend)
+	// An encrypted store cannot be opened until the user provides its
+	// passphrase, and a migration into one cannot start until then.
+	locked := backend == EncryptedBackend || preferredBackend(settings) == EncryptedBackend
+	if locked && !hasStoreKey(path) && backend != EncryptedBackend {
+		log.Printf("store encryption was requested, but no store key exists")
+		settings.SetEncryptStore(false)
+		locked = false
+	}
 	s, err := func() (forest.Store, error) {
-		path := settings.DataPath()
 		if err := os.MkdirAll(path, 0770); err != nil {
 			return nil, fmt.Errorf("preparing data directory for store: %v", err)
 		}
-		if settings.UseOrchardStore() {
-			o, err := orchard.Open(filepath.Join(path, "orchard.db"))
-			if err != nil {
-				return nil, fmt.Errorf("opening Orchard store: %v", err)
+		if retired := settings.RetiredStoreBackend(); retired != "" && retired != backend {
+			if err := removeStore(path, retired); err != nil {
+				log.Printf("failed removing retired %s store: %v", retired, err)
+			} else {
+				settings.ClearRetiredStoreBackend()
 			}
-			return o, nil
 		}
-		g, err := grove.New(path)
-		if err != nil {
-			return nil, fmt.Errorf("opening Grove store: %v", err)
+		if backend == EncryptedBackend {
+			if !hasStoreKey(path) {
+				return nil, fmt.Errorf("encrypted store has no key")
+			}
+			return store.NewMemoryStore(), nil
 		}
-		g.SetCorruptNodeHandler(func(id string) {
-			log.Printf("Grove: corrupt node %s", id)
-		})
-		return g, nil
+		return openStore(path, backend, nil)
 	}()
 	if err != nil {
+		log.Printf("store unavailable, keeping nodes in memory: %v", err)
 		s = store.NewMemoryStore()
+		locked = false
 	}
 	log.Printf("Store: %T\n", s)
 	a := &arborService{
 		SettingsService: settings,
-		grove:           store.NewArchive(s),
-		done:            make(chan struct{}),
+		BannerService:   banner,
+		tasks:           tasks,
+		grove:           newSwappableStore(s),
+		propagation:     NewPropagationTracker(),
+		backend:         backend,
+		storeErr:        err,
+		locked:          locked,
+	}
+	if err != nil {
+		a.storeBanner = &MessageBanner{
+			Priority: Error,
+			Text:     "Sprig could not open its message store, so new messages will not be saved. Open the store recovery page in the settings to fix this.",
+		}
+		banner.Add(a.storeBanner)
+	} else if locked {
+		a.storeBanner = &MessageBanner{
+			Priority: Warn,
+			Text:     "The message store is locked. Unlock it to see stored messages and save new ones.",
+		}
+		banner.Add(a.storeBanner)
 	}
 	cl, err := ds.NewCommunityList(a.grove)
 	if err != nil {
 		return nil, err
 	}
 	a.cl = cl
+	a.startPurger()
+	a.tasks.Go(a.runRetention)
+	settings.OnKeyLocked(a.keyLocked)
+	if to := preferredBackend(settings); a.storeErr == nil && !locked && to != backend {
+		a.startMigration(s, to)
+	}
+	return a, nil
+}
+
+// startMigration copies the nodes in source into the given backend in the
+// background, stopping any migration already running. Migrations into the
+// encrypted backend wait until the store key has been unlocked.
+func (a *arborService) startMigration(source forest.Store, to StoreBackend) {
+	a.storeLock.Lock()
+	defer a.storeLock.Unlock()
+	if to == EncryptedBackend && a.key == nil {
+		return
+	}
+	if a.cancelMigration != nil {
+		a.cancelMigration()
+	}
+	ctx, cancel := context.WithCancel(a.tasks.Context())
+	a.cancelMigration = cancel
+	a.tasks.Go(func(context.Context) {
+		defer cancel()
+		a.migrateStore(ctx, source, to)
+	})
+}
+
+// purgeInterval is how often expired nodes are removed from the store.
+const purgeInterval = time.Hour
+
+// retentionInterval is how often retention policies are applied.
+const retentionInterval = time.Hour
+
+// startPurger launches a purger that removes expired nodes from the store
+// until the tasks are stopped.
+func (a *arborService) startPurger() {
 	expiration.ExpiredPurger{
 		Logger:        log.New(log.Writer(), "purge ", log.Flags()),
-		ExtendedStore: a.grove,
-		PurgeInterval: time.Hour,
-	}.Start(a.done)
-	return a, nil
+		ExtendedStore: &purgeRecorder{ExtendedStore: a.grove, arbor: a},
+		PurgeInterval: purgeInterval,
+	}.Start(a.tasks.Context().Done())
+}
+
+// purgeRecorder records the subtrees removed through it as purged in the
+// store activity.
+type purgeRecorder struct {
+	store.ExtendedStore
+	arbor *arborService
+}
+
+func (p *purgeRecorder) RemoveSubtree(id *fields.QualifiedHash) error {
+	if err := p.ExtendedStore.RemoveSubtree(id); err != nil {
+		return err
+	}
+	p.arbor.recordPurge(1)
+	return nil
+}
+
+// runRetention periodically removes the nodes that retention policies no
+// longer keep from the store until ctx is cancelled.
+func (a *arborService) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		a.Prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func (a *arborService) Store() store.ExtendedStore {
@@ -86,16 +252,96 @@ func (a *arborService) Communities() *ds.CommunityList {
 	return a.cl
 }
 
+func (a *arborService) Propagation() *PropagationTracker {
+	return a.propagation
+}
+
+// heartbeatInterval is how often the local user is announced as active.
+const heartbeatInterval = time.Minute * 5
+
+// StartHeartbeat announces that the active identity is active in every known
+// community until shutdown. Calling it again, such as after switching
+// identities, announces that the previous identity is inactive and restarts
+// the heartbeat with the active one.
 func (a *arborService) StartHeartbeat() {
+	a.heartbeatLock.Lock()
+	defer a.heartbeatLock.Unlock()
+	if a.stopHeartbeat != nil {
+		a.stopHeartbeat()
+		a.stopHeartbeat = nil
+	}
 	a.Communities().WithCommunities(func(c []*forest.Community) {
 		if a.SettingsService.ActiveArborIdentityID() != nil {
 			builder, err := a.SettingsService.Builder()
 			if err == nil {
 				log.Printf("Begining active-status heartbeat")
-				go status.StartActivityHeartBeat(a.Store(), c, builder, time.Minute*5)
+				communities := append([]*forest.Community(nil), c...)
+				ctx, cancel := context.WithCancel(a.tasks.Context())
+				a.stopHeartbeat = func() {
+					cancel()
+					addActivityNodes(a.Store(), communities, builder, status.Inactive, heartbeatInterval)
+				}
+				a.tasks.Go(func(context.Context) {
+					runActivityHeartbeat(ctx, a.Store(), communities, builder, heartbeatInterval)
+				})
 			} else {
 				log.Printf("Could not acquire builder: %v", err)
 			}
 		}
 	})
 }
+
+// keyLocked stops the heartbeat once the private key has been locked, as
+// its builder holds the decrypted key. Unlocking the key starts it again.
+func (a *arborService) keyLocked() {
+	a.heartbeatLock.Lock()
+	defer a.heartbeatLock.Unlock()
+	if a.stopHeartbeat == nil || !a.SettingsService.KeyLocked() {
+		// The key was unlocked again in the meantime.
+		return
+	}
+	a.stopHeartbeat()
+	a.stopHeartbeat = nil
+}
+
+// runActivityHeartbeat announces that the local user is active in each of the
+// communities every interval until ctx is cancelled.
+func runActivityHeartbeat(ctx context.Context, s store.ExtendedStore, communities []*forest.Community, builder *forest.Builder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		addActivityNodes(s, communities, builder, status.Active, interval)
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping active-status heartbeat")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes the underlying Grove or Orchard store if it holds any
+// resources.
+func (a *arborService) Close() error {
+	if closer, ok := a.grove.UnderlyingStore().(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("failed closing store: %w", err)
+		}
+	}
+	return nil
+}
+
+// addActivityNodes adds a node announcing the given status of the builder's
+// identity to each of the communities.
+func addActivityNodes(s store.ExtendedStore, communities []*forest.Community, builder *forest.Builder, activity status.ActiveStatus, ttl time.Duration) {
+	for _, c := range communities {
+		node, err := status.NewActivityNode(c, builder, activity, ttl)
+		if err != nil {
+			log.Printf("Error creating active-status node: %v", err)
+			continue
+		}
+		if err := s.Add(node); err != nil {
+			log.Printf("Error adding active status node to store: %v", err)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package core
 
-import "sort"
+import (
+	"sort"
+	"sync"
+)
 
 // Banner is a type that provides details for a persistent on-screen
 // notification banner
@@ -20,23 +23,25 @@ type BannerService interface {
 }
 
 type bannerService struct {
-	App
+	Invalidator
 	newBanners chan Banner
 	banners    []Banner
 }
 
 var _ BannerService = &bannerService{}
 
-func NewBannerService(app App) BannerService {
+// NewBannerService creates a BannerService that requests a redraw from the
+// provided Invalidator whenever a banner is added.
+func NewBannerService(invalidator Invalidator) BannerService {
 	return &bannerService{
-		newBanners: make(chan Banner, 1),
-		App:        app,
+		newBanners:  make(chan Banner, 1),
+		Invalidator: invalidator,
 	}
 }
 
 func (b *bannerService) Add(banner Banner) {
 	b.newBanners <- banner
-	b.App.Window().Invalidate()
+	b.Invalidator.Invalidate()
 }
 
 func (b *bannerService) Top() Banner {
@@ -90,3 +95,31 @@ func (l *LoadingBanner) Cancel() {
 func (l *LoadingBanner) IsCancelled() bool {
 	return l.cancelled
 }
+
+// MessageBanner requests a banner displaying the provided text. It will not
+// disappear until cancelled. Banners with Error priority are displayed
+// prominently.
+type MessageBanner struct {
+	Priority
+	Text string
+	// lock guards cancelled, as banners are often cancelled from a
+	// different goroutine than the one displaying them.
+	lock      sync.Mutex
+	cancelled bool
+}
+
+func (m *MessageBanner) BannerPriority() Priority {
+	return m.Priority
+}
+
+func (m *MessageBanner) Cancel() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cancelled = true
+}
+
+func (m *MessageBanner) IsCancelled() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.cancelled
+}
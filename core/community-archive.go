@@ -0,0 +1,238 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+// archiveVersion is the version of the archive format written by
+// ExportArchive.
+const archiveVersion = 1
+
+// CommunityArchive is the portable representation of a community or of a
+// subtree within one. Alongside the subtree itself, it holds every node
+// needed to validate it: the ancestors of its root and the identities of all
+// of the authors.
+type CommunityArchive struct {
+	Version  int
+	Exported time.Time
+	// Root is the ID of the community or reply that was exported.
+	Root *fields.QualifiedHash
+	// Nodes holds the binary serialization of each node, ordered so that
+	// every node follows the nodes it references.
+	Nodes [][]byte
+}
+
+// ExportArchive writes the subtree rooted at the community or reply with the
+// given ID to w as a CommunityArchive. It returns the number of nodes
+// written.
+func ExportArchive(s store.ExtendedStore, root *fields.QualifiedHash, w io.Writer) (int, error) {
+	if _, has, err := s.Get(root); err != nil {
+		return 0, fmt.Errorf("failed looking up %s: %w", root, err)
+	} else if !has {
+		return 0, fmt.Errorf("no node with id %s", root)
+	}
+	ancestors, err := s.AncestryOf(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed looking up ancestors of %s: %w", root, err)
+	}
+	descendants, err := s.DescendantsOf(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed looking up descendants of %s: %w", root, err)
+	}
+	ids := append(append(ancestors, root), descendants...)
+
+	nodes := make(map[string]forest.Node, len(ids))
+	include := func(id *fields.QualifiedHash) error {
+		if id.Equals(fields.NullHash()) {
+			return nil
+		}
+		if _, included := nodes[id.String()]; included {
+			return nil
+		}
+		node, has, err := s.Get(id)
+		if err != nil {
+			return fmt.Errorf("failed looking up %s: %w", id, err)
+		} else if !has {
+			return fmt.Errorf("missing node %s", id)
+		}
+		nodes[id.String()] = node
+		return nil
+	}
+	for _, id := range ids {
+		if err := include(id); err != nil {
+			return 0, err
+		}
+	}
+	// Include the community of every reply in case its ancestry is
+	// incomplete, then the authors of every node.
+	for _, node := range sortForImport(nodes) {
+		if reply, ok := node.(*forest.Reply); ok {
+			if err := include(&reply.CommunityID); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, node := range sortForImport(nodes) {
+		if err := include(node.AuthorID()); err != nil {
+			return 0, err
+		}
+	}
+
+	archive := CommunityArchive{
+		Version:  archiveVersion,
+		Exported: time.Now(),
+		Root:     root,
+	}
+	for _, node := range sortForImport(nodes) {
+		data, err := node.MarshalBinary()
+		if err != nil {
+			return 0, fmt.Errorf("failed serializing node %s: %w", node.ID(), err)
+		}
+		archive.Nodes = append(archive.Nodes, data)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(&archive); err != nil {
+		return 0, fmt.Errorf("failed writing archive: %w", err)
+	}
+	return len(archive.Nodes), nil
+}
+
+// sortForImport orders the nodes so that identities come first, followed by
+// communities and then replies from the shallowest to the deepest.
+func sortForImport(nodes map[string]forest.Node) []forest.Node {
+	rank := func(node forest.Node) int {
+		switch node.(type) {
+		case *forest.Identity:
+			return 0
+		case *forest.Community:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sorted := make([]forest.Node, 0, len(nodes))
+	for _, node := range nodes {
+		sorted = append(sorted, node)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		if a.TreeDepth() != b.TreeDepth() {
+			return a.TreeDepth() < b.TreeDepth()
+		}
+		return a.ID().String() < b.ID().String()
+	})
+	return sorted
+}
+
+// ImportResult summarizes the outcome of ImportArchive.
+type ImportResult struct {
+	// Added is the number of nodes added to the store.
+	Added int
+	// Existing is the number of nodes that were already in the store.
+	Existing int
+	// Rejected is the number of nodes that failed validation.
+	Rejected int
+	// FirstRejection describes why the first rejected node failed.
+	FirstRejection error
+}
+
+func (r ImportResult) String() string {
+	text := fmt.Sprintf("%d added, %d already present, %d rejected", r.Added, r.Existing, r.Rejected)
+	if r.FirstRejection != nil {
+		text += fmt.Sprintf(" (%v)", r.FirstRejection)
+	}
+	return text
+}
+
+// ImportArchive reads a CommunityArchive from r and adds its nodes to s.
+// Each node is added only if it is well-formed, its signature is valid for
+// its author, and every node it references is known.
+func ImportArchive(s store.ExtendedStore, r io.Reader) (ImportResult, error) {
+	var (
+		result  ImportResult
+		archive CommunityArchive
+	)
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return result, fmt.Errorf("failed parsing archive: %w", err)
+	}
+	if archive.Version < 1 || archive.Version > archiveVersion {
+		return result, fmt.Errorf("unsupported archive version %d", archive.Version)
+	}
+	nodes := make(map[string]forest.Node, len(archive.Nodes))
+	for _, data := range archive.Nodes {
+		node, err := forest.UnmarshalBinaryNode(data)
+		if err != nil {
+			result.reject(fmt.Errorf("unreadable node: %w", err))
+			continue
+		}
+		nodes[node.ID().String()] = node
+	}
+	for _, node := range sortForImport(nodes) {
+		if _, has, err := s.Get(node.ID()); err != nil {
+			return result, fmt.Errorf("failed looking up %s: %w", node.ID(), err)
+		} else if has {
+			result.Existing++
+			continue
+		}
+		if err := validateNode(node, s); err != nil {
+			result.reject(fmt.Errorf("node %s: %w", node.ID(), err))
+			continue
+		}
+		if err := s.Add(node); err != nil {
+			return result, fmt.Errorf("failed adding %s: %w", node.ID(), err)
+		}
+		result.Added++
+	}
+	return result, nil
+}
+
+func (r *ImportResult) reject(err error) {
+	r.Rejected++
+	if r.FirstRejection == nil {
+		r.FirstRejection = err
+	}
+}
+
+// validateNode checks that the node is well-formed, that all of the nodes it
+// references are in the store, and that it was signed by its author.
+func validateNode(node forest.Node, s forest.Store) error {
+	if err := forest.ValidateNode(node, s); err != nil {
+		return err
+	}
+	signed, ok := node.(forest.SignatureValidator)
+	if !ok {
+		return fmt.Errorf("node type %T cannot be signed", node)
+	}
+	var author *forest.Identity
+	if identity, ok := node.(*forest.Identity); ok {
+		author = identity
+	} else {
+		authorNode, has, err := s.GetIdentity(node.AuthorID())
+		if err != nil {
+			return fmt.Errorf("failed looking up author: %w", err)
+		} else if !has {
+			return fmt.Errorf("unknown author %s", node.AuthorID())
+		}
+		if author, ok = authorNode.(*forest.Identity); !ok {
+			return fmt.Errorf("author %s is not an identity", node.AuthorID())
+		}
+	}
+	if valid, err := forest.ValidateSignature(signed, author); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	} else if !valid {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+// newArchiveSource returns a store holding the test tree along with a reply
+// to its conversation by a second author.
+func newArchiveSource(t *testing.T) (*swappableStore, *testTree, *forest.Identity, []forest.Node) {
+	t.Helper()
+	tree := newTestTree(t)
+	other, builder := newTestAuthor(t, "other")
+	answer, err := builder.NewReply(tree.conversation, "answer", []byte{})
+	if err != nil {
+		t.Fatalf("creating reply: %v", err)
+	}
+	nodes := append(tree.nodes(), other, answer)
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, nodes...)
+	return s, tree, other, nodes
+}
+
+// exportTestArchive exports the subtree rooted at root from s.
+func exportTestArchive(t *testing.T, s store.ExtendedStore, root *fields.QualifiedHash) *bytes.Buffer {
+	t.Helper()
+	var archive bytes.Buffer
+	if _, err := ExportArchive(s, root, &archive); err != nil {
+		t.Fatalf("exporting: %v", err)
+	}
+	return &archive
+}
+
+// tamperedReply returns a copy of reply changed by tamper without signing
+// it again.
+func tamperedReply(t *testing.T, reply *forest.Reply, tamper func(*forest.Reply)) *forest.Reply {
+	t.Helper()
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		t.Fatalf("serializing reply: %v", err)
+	}
+	tampered, err := forest.UnmarshalReply(data)
+	if err != nil {
+		t.Fatalf("copying reply: %v", err)
+	}
+	tamper(tampered)
+	if data, err = tampered.MarshalBinary(); err != nil {
+		t.Fatalf("serializing tampered reply: %v", err)
+	}
+	// Unmarshaling computes the ID of the tampered reply.
+	if tampered, err = forest.UnmarshalReply(data); err != nil {
+		t.Fatalf("reading tampered reply: %v", err)
+	}
+	if tampered.ID().Equals(reply.ID()) {
+		t.Fatalf("tampering did not change the reply")
+	}
+	return tampered
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source, tree, _, nodes := newArchiveSource(t)
+	archive := exportTestArchive(t, source, tree.community.ID())
+	data := archive.Bytes()
+
+	dest := newSwappableStore(store.NewMemoryStore())
+	result, err := ImportArchive(dest, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+	if result.Added != len(nodes) || result.Existing != 0 || result.Rejected != 0 {
+		t.Errorf("import %v, expected %d added", result, len(nodes))
+	}
+	checkPresent(t, dest, nodes, nil)
+
+	result, err = ImportArchive(dest, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("importing again: %v", err)
+	}
+	if result.Added != 0 || result.Existing != len(nodes) || result.Rejected != 0 {
+		t.Errorf("second import %v, expected %d already present", result, len(nodes))
+	}
+}
+
+func TestArchiveRejectsInvalidNodes(t *testing.T) {
+	source, tree, other, nodes := newArchiveSource(t)
+	var archive CommunityArchive
+	if err := json.NewDecoder(exportTestArchive(t, source, tree.community.ID())).Decode(&archive); err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+
+	_, stranger := newTestAuthor(t, "stranger")
+	unknownAuthor, err := stranger.NewReply(tree.conversation, "unknown author", []byte{})
+	if err != nil {
+		t.Fatalf("creating reply: %v", err)
+	}
+	invalid := []forest.Node{
+		tamperedReply(t, tree.reply, func(reply *forest.Reply) {
+			content, err := fields.NewQualifiedContent(fields.ContentTypeUTF8String, []byte("tampered"))
+			if err != nil {
+				t.Fatalf("creating content: %v", err)
+			}
+			reply.Content = *content
+		}),
+		tamperedReply(t, tree.reply, func(reply *forest.Reply) {
+			reply.Author = *other.ID()
+		}),
+		unknownAuthor,
+	}
+	for _, node := range invalid {
+		data, err := node.MarshalBinary()
+		if err != nil {
+			t.Fatalf("serializing %s: %v", node.ID(), err)
+		}
+		archive.Nodes = append(archive.Nodes, data)
+	}
+	var data bytes.Buffer
+	if err := json.NewEncoder(&data).Encode(&archive); err != nil {
+		t.Fatalf("writing archive: %v", err)
+	}
+
+	dest := newSwappableStore(store.NewMemoryStore())
+	result, err := ImportArchive(dest, &data)
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+	if result.Added != len(nodes) || result.Rejected != len(invalid) {
+		t.Errorf("import %v, expected %d added and %d rejected", result, len(nodes), len(invalid))
+	}
+	if result.FirstRejection == nil {
+		t.Errorf("no reason given for the rejections")
+	}
+	checkPresent(t, dest, nodes, invalid)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ConnectionState describes the lifecycle stage of the connection to a
+// single relay.
+type ConnectionState uint8
+
+const (
+	// Disconnected relays have no worker running.
+	Disconnected ConnectionState = iota
+	// Connecting relays are currently being dialed.
+	Connecting
+	// Syncing relays are connected and fetching subscribed history.
+	Syncing
+	// Connected relays are connected and up to date.
+	Connected
+	// BackingOff relays lost their connection (or never established one)
+	// and are waiting before the next attempt.
+	BackingOff
+	// Failed relays exhausted their connection attempts. They will not be
+	// retried until reconnected explicitly.
+	Failed
+)
+
+func (c ConnectionState) String() string {
+	switch c {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Syncing:
+		return "syncing"
+	case Connected:
+		return "connected"
+	case BackingOff:
+		return "backing off"
+	case Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionStatus reports the state of the connection to a single relay.
+type ConnectionStatus struct {
+	Address string
+	State   ConnectionState
+	// Err holds the error that caused the most recent failure, if any.
+	Err error
+	// Attempts is the number of consecutive failed connection attempts.
+	Attempts int
+	// RetryAt is the time of the next connection attempt while BackingOff.
+	RetryAt time.Time
+}
+
+// ConnectionSubscription identifies a handler registered to receive
+// ConnectionStatus updates.
+type ConnectionSubscription uint
+
+// backoff computes exponentially-increasing delays with jitter between
+// connection attempts.
+type backoff struct {
+	// Min is the delay after the first failure.
+	Min time.Duration
+	// Max caps the delay between attempts.
+	Max time.Duration
+	// MaxAttempts is the number of consecutive failures after which the
+	// backoff is exhausted. Values less than 1 indicate no limit.
+	MaxAttempts int
+	attempts    int
+}
+
+// Fail records a failed attempt.
+func (b *backoff) Fail() {
+	b.attempts++
+}
+
+// Reset forgets all previously recorded failures.
+func (b *backoff) Reset() {
+	b.attempts = 0
+}
+
+// Attempts returns the number of consecutive failures.
+func (b *backoff) Attempts() int {
+	return b.attempts
+}
+
+// Exhausted returns whether no further attempts should be made.
+func (b *backoff) Exhausted() bool {
+	return b.MaxAttempts > 0 && b.attempts >= b.MaxAttempts
+}
+
+// Delay returns how long to wait before the next attempt. The delay doubles
+// with each failure and is randomized to between half and all of that value
+// so that many clients do not retry in lockstep.
+func (b *backoff) Delay() time.Duration {
+	if b.attempts < 1 {
+		return 0
+	}
+	delay := b.Min
+	for i := 1; i < b.attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
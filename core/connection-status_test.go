@@ -0,0 +1,44 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := backoff{Min: time.Second, Max: 8 * time.Second, MaxAttempts: 6}
+	if delay := b.Delay(); delay != 0 {
+		t.Fatalf("expected no delay before any failure, got %v", delay)
+	}
+	for i, ceiling := range []time.Duration{1, 2, 4, 8, 8} {
+		ceiling *= time.Second
+		b.Fail()
+		if b.Attempts() != i+1 {
+			t.Fatalf("expected %d attempts, got %d", i+1, b.Attempts())
+		}
+		if delay := b.Delay(); delay < ceiling/2 || delay > ceiling {
+			t.Errorf("attempt %d: delay %v outside of [%v, %v]", i+1, delay, ceiling/2, ceiling)
+		}
+		if b.Exhausted() {
+			t.Fatalf("backoff exhausted after %d of %d attempts", i+1, b.MaxAttempts)
+		}
+	}
+	b.Fail()
+	if !b.Exhausted() {
+		t.Fatalf("backoff not exhausted after %d attempts", b.Attempts())
+	}
+	b.Reset()
+	if b.Exhausted() || b.Attempts() != 0 || b.Delay() != 0 {
+		t.Fatalf("reset did not clear the backoff: %+v", b)
+	}
+}
+
+func TestBackoffUnlimited(t *testing.T) {
+	b := backoff{Min: time.Millisecond, Max: time.Millisecond}
+	for i := 0; i < 100; i++ {
+		b.Fail()
+	}
+	if b.Exhausted() {
+		t.Fatalf("backoff without MaxAttempts should never be exhausted")
+	}
+}
@@ -1,37 +1,17 @@
 package core
 
-import (
-	"log"
-
-	gioapp "gioui.org/app"
-	"gioui.org/x/haptic"
-)
-
 // HapticService provides access to haptic feedback devices features.
 type HapticService interface {
 	UpdateAndroidViewRef(uintptr)
 	Buzz()
 }
 
-type hapticService struct {
-	*haptic.Buzzer
-}
+// NoopHapticService is a HapticService for applications without a window.
+// It does nothing.
+type NoopHapticService struct{}
 
-func newHapticService(w *gioapp.Window) HapticService {
-	return &hapticService{
-		Buzzer: haptic.NewBuzzer(w),
-	}
-}
+var _ HapticService = NoopHapticService{}
 
-func (h *hapticService) UpdateAndroidViewRef(view uintptr) {
-	h.Buzzer.SetView(view)
-}
+func (NoopHapticService) UpdateAndroidViewRef(uintptr) {}
 
-func (h *hapticService) Buzz() {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered from buzz panic: %v", err)
-		}
-	}()
-	h.Buzzer.Buzz()
-}
+func (NoopHapticService) Buzz() {}
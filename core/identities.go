@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// LocalIdentity is an identity stored in the identities directory.
+type LocalIdentity struct {
+	*forest.Identity
+	// Label is the local name chosen for the identity, if any. Unlike the
+	// identity's published name, it is never shared.
+	Label string
+	// Active is set for the identity used to author messages.
+	Active bool
+	// KeyProtected is set if the private key of the identity is encrypted
+	// with a passphrase.
+	KeyProtected bool
+}
+
+// DisplayName returns the label of the identity, or its published name if it
+// has no label.
+func (l LocalIdentity) DisplayName() string {
+	if l.Label != "" {
+		return l.Label
+	}
+	return string(l.Name.Blob)
+}
+
+// listIdentityIDs returns the IDs of the identities in the identities
+// directory. Files that are not named after an ID are ignored.
+func (s *settingsService) listIdentityIDs() ([]*fields.QualifiedHash, error) {
+	infos, err := ioutil.ReadDir(s.IdentitiesDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed listing identities directory: %w", err)
+	}
+	var ids []*fields.QualifiedHash
+	for _, info := range infos {
+		id := &fields.QualifiedHash{}
+		if err := id.UnmarshalText([]byte(info.Name())); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadIdentity reads the identity with the given ID from the identities
+// directory.
+func (s *settingsService) loadIdentity(id *fields.QualifiedHash) (*forest.Identity, error) {
+	idData, err := ioutil.ReadFile(filepath.Join(s.IdentitiesDir(), id.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading identity data: %w", err)
+	}
+	identity, err := forest.UnmarshalIdentity(idData)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding identity data: %w", err)
+	}
+	return identity, nil
+}
+
+// clearActiveIdentity forgets the active identity and the state cached for
+// authoring messages with it. The caller must hold identityLock.
+func (s *settingsService) clearActiveIdentity() {
+	s.lockKey()
+	s.ActiveIdentity = nil
+	s.activeIdCache = nil
+	s.activePrivKey = nil
+}
+
+func (s *settingsService) Identities() ([]LocalIdentity, error) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	ids, err := s.listIdentityIDs()
+	if err != nil {
+		return nil, err
+	}
+	identities := make([]LocalIdentity, 0, len(ids))
+	for _, id := range ids {
+		identity, err := s.loadIdentity(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading identity %s: %w", id, err)
+		}
+		entity, err := readKeyFile(filepath.Join(s.KeysDir(), id.String()))
+		identities = append(identities, LocalIdentity{
+			Identity:     identity,
+			Label:        s.IdentityLabels[id.String()],
+			Active:       s.ActiveIdentity != nil && id.Equals(s.ActiveIdentity),
+			KeyProtected: err == nil && entityEncrypted(entity),
+		})
+	}
+	return identities, nil
+}
+
+func (s *settingsService) SetActiveIdentity(id *fields.QualifiedHash) error {
+	identity, err := s.loadIdentity(id)
+	if err != nil {
+		return fmt.Errorf("failed switching identity: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.KeysDir(), id.String())); err != nil {
+		return fmt.Errorf("failed switching identity: missing private key: %w", err)
+	}
+	s.identityLock.Lock()
+	s.clearActiveIdentity()
+	s.ActiveIdentity = id
+	s.activeIdCache = identity
+	s.identityLock.Unlock()
+	return s.Persist()
+}
+
+func (s *settingsService) RenameIdentity(id *fields.QualifiedHash, label string) error {
+	s.identityLock.Lock()
+	if label == "" {
+		delete(s.IdentityLabels, id.String())
+	} else {
+		if s.IdentityLabels == nil {
+			s.IdentityLabels = make(map[string]string)
+		}
+		s.IdentityLabels[id.String()] = label
+	}
+	s.identityLock.Unlock()
+	return s.Persist()
+}
+
+func (s *settingsService) DeleteIdentity(id *fields.QualifiedHash) error {
+	s.identityLock.Lock()
+	err := func() error {
+		// Remove the identity first, so that a failure cannot leave an
+		// identity without its private key.
+		if err := os.Remove(filepath.Join(s.IdentitiesDir(), id.String())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed removing identity: %w", err)
+		}
+		if err := os.Remove(filepath.Join(s.KeysDir(), id.String())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed removing private key: %w", err)
+		}
+		delete(s.IdentityLabels, id.String())
+		delete(s.PreviousIdentities, id.String())
+		return nil
+	}()
+	s.identityLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.DiscoverIdentities(); err != nil {
+		return err
+	}
+	return s.Persist()
+}
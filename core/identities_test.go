@@ -0,0 +1,130 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// activeIdentityID returns the ID of the active identity, failing the test
+// if there is none.
+func activeIdentityID(t *testing.T, s *settingsService) *fields.QualifiedHash {
+	t.Helper()
+	id := s.ActiveArborIdentityID()
+	if id == nil {
+		t.Fatalf("no active identity")
+	}
+	return id
+}
+
+// localIdentity returns the local identity with the given ID.
+func localIdentity(t *testing.T, s *settingsService, id *fields.QualifiedHash) LocalIdentity {
+	t.Helper()
+	identities, err := s.Identities()
+	if err != nil {
+		t.Fatalf("listing identities: %v", err)
+	}
+	for _, identity := range identities {
+		if identity.ID().Equals(id) {
+			return identity
+		}
+	}
+	t.Fatalf("identity %s is not listed", id)
+	return LocalIdentity{}
+}
+
+func TestSwitchIdentities(t *testing.T) {
+	settings := newTestSettings(t, "")
+	first := activeIdentityID(t, settings)
+	if err := settings.CreateIdentity("second"); err != nil {
+		t.Fatalf("creating identity: %v", err)
+	}
+	second := activeIdentityID(t, settings)
+	if identities, err := settings.Identities(); err != nil || len(identities) != 2 {
+		t.Fatalf("listed %d identities, expected 2: %v", len(identities), err)
+	}
+	if !localIdentity(t, settings, second).Active || localIdentity(t, settings, first).Active {
+		t.Errorf("the new identity is not the only active one")
+	}
+
+	if err := settings.SetActiveIdentity(first); err != nil {
+		t.Fatalf("switching identity: %v", err)
+	}
+	identity, err := settings.Identity()
+	if err != nil {
+		t.Fatalf("loading identity: %v", err)
+	}
+	if !identity.ID().Equals(first) || !localIdentity(t, settings, first).Active {
+		t.Errorf("switching did not activate %s", first)
+	}
+	if err := settings.SetActiveIdentity(&fields.QualifiedHash{}); err == nil {
+		t.Errorf("switched to an identity that does not exist")
+	}
+	if !activeIdentityID(t, settings).Equals(first) {
+		t.Errorf("failed switch changed the active identity")
+	}
+}
+
+func TestRenameIdentity(t *testing.T) {
+	settings := newTestSettings(t, "")
+	id := activeIdentityID(t, settings)
+	if err := settings.RenameIdentity(id, "work"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+	if name := localIdentity(t, settings, id).DisplayName(); name != "work" {
+		t.Errorf("identity is displayed as %q, expected the label", name)
+	}
+	if err := settings.RenameIdentity(id, ""); err != nil {
+		t.Fatalf("clearing label: %v", err)
+	}
+	if name := localIdentity(t, settings, id).DisplayName(); name != "test" {
+		t.Errorf("identity is displayed as %q, expected its published name", name)
+	}
+}
+
+func TestDeleteActiveIdentity(t *testing.T) {
+	settings := newTestSettings(t, "")
+	first := activeIdentityID(t, settings)
+	if err := settings.CreateIdentity("second"); err != nil {
+		t.Fatalf("creating identity: %v", err)
+	}
+	second := activeIdentityID(t, settings)
+	if err := settings.RenameIdentity(second, "label"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+	if err := settings.DeleteIdentity(second); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+	if !activeIdentityID(t, settings).Equals(first) {
+		t.Errorf("remaining identity did not become active")
+	}
+	if _, ok := settings.IdentityLabels[second.String()]; ok {
+		t.Errorf("label of the deleted identity was kept")
+	}
+	for _, dir := range []string{settings.IdentitiesDir(), settings.KeysDir()} {
+		if _, err := os.Stat(filepath.Join(dir, second.String())); !os.IsNotExist(err) {
+			t.Errorf("file of the deleted identity was kept in %s: %v", dir, err)
+		}
+	}
+}
+
+func TestDeleteOnlyIdentity(t *testing.T) {
+	settings := newTestSettings(t, "")
+	if err := settings.DeleteIdentity(activeIdentityID(t, settings)); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+	if id := settings.ActiveArborIdentityID(); id != nil {
+		t.Errorf("deleted identity %s is still active", id)
+	}
+	if identities, err := settings.Identities(); err != nil || len(identities) != 0 {
+		t.Errorf("listed %d identities, expected none: %v", len(identities), err)
+	}
+	if _, err := settings.Identity(); err == nil {
+		t.Errorf("loaded an identity after deleting the only one")
+	}
+	if _, err := settings.Builder(); err == nil {
+		t.Errorf("created a builder after deleting the only identity")
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+func (s *settingsService) SetProfile(profile ds.Profile) (*forest.Identity, error) {
+	metadata, err := profile.Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+	s.identityLock.Lock()
+	identity, err := func() (*forest.Identity, error) {
+		previous, err := s.identity()
+		if err != nil {
+			return nil, err
+		}
+		signer, err := s.signer()
+		if err != nil {
+			return nil, err
+		}
+		// Identity nodes cannot change, so the profile is published in a
+		// new identity with the same name and key.
+		identity, err := forest.NewIdentity(signer, string(previous.Name.Blob), metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed generating arbor identity from signer: %w", err)
+		}
+		keyPath, err := s.keyFile()
+		if err != nil {
+			return nil, err
+		}
+		// Copy the key file rather than the decrypted key, so that a
+		// passphrase keeps protecting it.
+		keyData, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key file: %w", err)
+		}
+		if err := s.saveIdentity(identity, keyData); err != nil {
+			return nil, err
+		}
+		previousID, id := previous.ID().String(), identity.ID().String()
+		if label, ok := s.IdentityLabels[previousID]; ok {
+			s.IdentityLabels[id] = label
+			delete(s.IdentityLabels, previousID)
+		}
+		// The previous identity has been replaced, but the messages that
+		// it authored stay valid as its node is still in the store, and
+		// remain the user's own.
+		if s.PreviousIdentities == nil {
+			s.PreviousIdentities = make(map[string][]*fields.QualifiedHash)
+		}
+		s.PreviousIdentities[id] = append([]*fields.QualifiedHash{previous.ID()}, s.PreviousIdentities[previousID]...)
+		delete(s.PreviousIdentities, previousID)
+		if err := os.Remove(filepath.Join(s.IdentitiesDir(), previousID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed removing previous identity: %v", err)
+		}
+		if err := os.Remove(keyPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed removing previous private key: %v", err)
+		}
+		// The decrypted key and its session timeout carry over.
+		s.ActiveIdentity = identity.ID()
+		s.activeIdCache = identity
+		return identity, nil
+	}()
+	s.identityLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Persist(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+func TestProfileChangeKeepsPreviousIdentity(t *testing.T) {
+	settings := newTestSettings(t, "")
+	previous, err := settings.Identity()
+	if err != nil {
+		t.Fatalf("loading identity: %v", err)
+	}
+	builder, err := settings.Builder()
+	if err != nil {
+		t.Fatalf("loading builder: %v", err)
+	}
+	old := time.Now().AddDate(0, 0, -10)
+	community, err := builder.NewCommunity("community", []byte{})
+	if err != nil {
+		t.Fatalf("creating community: %v", err)
+	}
+	reply := newTestReply(t, builder, community, "before the profile change", old)
+
+	current, err := settings.SetProfile(ds.Profile{Bio: "bio"})
+	if err != nil {
+		t.Fatalf("setting profile: %v", err)
+	}
+	if current.ID().Equals(previous.ID()) {
+		t.Fatalf("profile change kept the identity ID")
+	}
+	active := settings.ActiveIdentityIDs()
+	if len(active) != 2 || !active[0].Equals(current.ID()) || !active[1].Equals(previous.ID()) {
+		t.Errorf("active identity IDs are %v, expected %v and %v", active, current.ID(), previous.ID())
+	}
+
+	own := make(map[string]struct{})
+	for _, id := range settings.LocalIdentityIDs() {
+		own[id.String()] = struct{}{}
+	}
+	if _, ok := own[previous.ID().String()]; !ok {
+		t.Errorf("local identity IDs do not include the previous ID %s", previous.ID())
+	}
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, previous, community, reply)
+	result, err := pruneCommunity(context.Background(), s, community, RetentionPolicy{Rule: KeepDays, Amount: 5}, own, time.Now())
+	if err != nil {
+		t.Fatalf("pruning: %v", err)
+	}
+	if result.Removed != 0 {
+		t.Errorf("removed %d messages written under the previous ID", result.Removed)
+	}
+	checkPresent(t, s, []forest.Node{community, reply}, nil)
+}
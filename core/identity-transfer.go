@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// identityExportVersion is the version of the file format written by
+// ExportIdentity.
+const identityExportVersion = 1
+
+// IdentityExport is the portable representation of a local identity, which
+// moves it to another device.
+type IdentityExport struct {
+	Version  int
+	Exported time.Time
+	// Identity is the binary serialization of the identity node.
+	Identity []byte
+	// Key is the ASCII-armored private key of the identity. It is encrypted
+	// if the identity was exported with a passphrase.
+	Key string
+}
+
+// readIdentityKey reads the private key of the identity with the given ID and
+// decrypts it with the passphrase if it is protected.
+func (s *settingsService) readIdentityKey(id *fields.QualifiedHash, passphrase string) (*openpgp.Entity, error) {
+	entity, err := readKeyFile(filepath.Join(s.KeysDir(), id.String()))
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptEntity(entity, passphrase); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+func (s *settingsService) ExportIdentity(id *fields.QualifiedHash, current, passphrase string, w io.Writer) error {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	identity, err := s.loadIdentity(id)
+	if err != nil {
+		return err
+	}
+	entity, err := s.readIdentityKey(id, current)
+	if err != nil {
+		return err
+	}
+	if passphrase != "" {
+		if err := encryptEntity(entity, passphrase); err != nil {
+			return err
+		}
+	}
+	var key bytes.Buffer
+	armored, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return fmt.Errorf("failed armoring private key: %w", err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(armored, nil); err != nil {
+		return fmt.Errorf("failed serializing private key: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return fmt.Errorf("failed armoring private key: %w", err)
+	}
+	binIdent, err := identity.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed serializing identity: %w", err)
+	}
+	export := IdentityExport{
+		Version:  identityExportVersion,
+		Exported: time.Now(),
+		Identity: binIdent,
+		Key:      key.String(),
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(&export); err != nil {
+		return fmt.Errorf("failed writing identity export: %w", err)
+	}
+	return nil
+}
+
+// verifyIdentityKey checks that the identity was signed by its own key and
+// that the entity holds the private half of that key, by signing a throwaway
+// node with it.
+func verifyIdentityKey(identity *forest.Identity, entity *openpgp.Entity) error {
+	if err := identity.ValidateInternal(); err != nil {
+		return fmt.Errorf("invalid identity: %w", err)
+	}
+	if valid, err := forest.ValidateSignature(identity, identity); err != nil || !valid {
+		return fmt.Errorf("invalid identity signature: %v", err)
+	}
+	signer, err := forest.NewNativeSigner(entity)
+	if err != nil {
+		return fmt.Errorf("couldn't wrap privkey in forest signer: %w", err)
+	}
+	probe, err := forest.As(identity, signer).NewCommunity("probe", []byte{})
+	if err != nil {
+		return fmt.Errorf("failed signing with private key: %w", err)
+	}
+	if valid, err := forest.ValidateSignature(probe, identity); err != nil || !valid {
+		return fmt.Errorf("private key does not belong to identity %s", identity.ID())
+	}
+	return nil
+}
+
+func (s *settingsService) ImportIdentity(r io.Reader, passphrase string) (*forest.Identity, error) {
+	var export IdentityExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("failed parsing identity export: %w", err)
+	}
+	if export.Version < 1 || export.Version > identityExportVersion {
+		return nil, fmt.Errorf("unsupported identity export version %d", export.Version)
+	}
+	identity, err := forest.UnmarshalIdentity(export.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding identity data: %w", err)
+	}
+	block, err := armor.Decode(bytes.NewBufferString(export.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding armored private key: %w", err)
+	} else if block.Type != openpgp.PrivateKeyType {
+		return nil, fmt.Errorf("expected a private key, found %q", block.Type)
+	}
+	keyData, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding armored private key: %w", err)
+	}
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(keyData)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode key data: %w", err)
+	}
+	if err := decryptEntity(entity, passphrase); err != nil {
+		return nil, err
+	}
+	if err := verifyIdentityKey(identity, entity); err != nil {
+		return nil, err
+	}
+
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	// The key is stored as it was exported, so a key exported with a
+	// passphrase stays protected by it.
+	if err := s.saveIdentity(identity, keyData); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// saveIdentity writes the identity and its serialized private key to the
+// identities and keys directories. It fails if the identity already exists.
+// The caller must hold identityLock.
+func (s *settingsService) saveIdentity(identity *forest.Identity, keyData []byte) error {
+	binIdent, err := identity.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed serializing identity: %w", err)
+	}
+	id := identity.ID().String()
+	keyFilePath := filepath.Join(s.KeysDir(), id)
+	if _, err := os.Stat(keyFilePath); err == nil {
+		return fmt.Errorf("identity %s already exists", id)
+	}
+	if err := os.MkdirAll(s.KeysDir(), 0770); err != nil {
+		return fmt.Errorf("failed creating key storage directory: %w", err)
+	}
+	if err := os.MkdirAll(s.IdentitiesDir(), 0770); err != nil {
+		return fmt.Errorf("failed creating identity storage directory: %w", err)
+	}
+	if err := writeFileAtomic(keyFilePath, keyData, 0660); err != nil {
+		return fmt.Errorf("failed saving private key: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.IdentitiesDir(), id), binIdent, 0660); err != nil {
+		os.Remove(keyFilePath)
+		return fmt.Errorf("failed writing identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+)
+
+func TestVerifyIdentityKey(t *testing.T) {
+	identity, entity := newTestIdentity(t, "test")
+	other, otherEntity := newTestIdentity(t, "other")
+	if err := verifyIdentityKey(identity, entity); err != nil {
+		t.Errorf("verifying the identity's own key: %v", err)
+	}
+	if err := verifyIdentityKey(identity, otherEntity); err == nil {
+		t.Errorf("the key of another identity was accepted")
+	}
+
+	// An identity whose contents no longer match its signature, such as
+	// one with another identity's key substituted, is rejected.
+	data, err := identity.MarshalBinary()
+	if err != nil {
+		t.Fatalf("serializing identity: %v", err)
+	}
+	forged, err := forest.UnmarshalIdentity(data)
+	if err != nil {
+		t.Fatalf("decoding identity: %v", err)
+	}
+	forged.PublicKey = other.PublicKey
+	if err := verifyIdentityKey(forged, otherEntity); err == nil {
+		t.Errorf("an identity with a substituted key was accepted")
+	}
+}
+
+func TestIdentityExportRoundTrip(t *testing.T) {
+	source := newTestSettings(t, "")
+	identity, err := source.Identity()
+	if err != nil {
+		t.Fatalf("loading identity: %v", err)
+	}
+	var export bytes.Buffer
+	if err := source.ExportIdentity(identity.ID(), "", "export passphrase", &export); err != nil {
+		t.Fatalf("exporting identity: %v", err)
+	}
+
+	service, err := newSettingsService(newTestDataDir(t))
+	if err != nil {
+		t.Fatalf("creating settings: %v", err)
+	}
+	dest := service.(*settingsService)
+	if _, err := dest.ImportIdentity(bytes.NewReader(export.Bytes()), "wrong"); err == nil {
+		t.Errorf("importing with the wrong passphrase succeeded")
+	}
+	imported, err := dest.ImportIdentity(bytes.NewReader(export.Bytes()), "export passphrase")
+	if err != nil {
+		t.Fatalf("importing identity: %v", err)
+	}
+	if !imported.Equals(identity) {
+		t.Errorf("imported identity %s differs from the exported %s", imported.ID(), identity.ID())
+	}
+	if _, err := dest.ImportIdentity(bytes.NewReader(export.Bytes()), "export passphrase"); err == nil {
+		t.Errorf("importing the same identity twice succeeded")
+	}
+}
@@ -0,0 +1,561 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/grove"
+)
+
+// IntegrityProblem describes what is wrong with a stored node.
+type IntegrityProblem int
+
+const (
+	// ProblemUnreadable means the node could not be read or parsed.
+	ProblemUnreadable IntegrityProblem = iota
+	// ProblemHashMismatch means the node is stored under an ID that does
+	// not match the hash of its content.
+	ProblemHashMismatch
+	// ProblemMalformed means the node parsed but is not well-formed.
+	ProblemMalformed
+	// ProblemBadSignature means the node's signature does not match its
+	// author.
+	ProblemBadSignature
+	// ProblemMissingParent means the node's parent is not stored.
+	ProblemMissingParent
+	// ProblemMissingAuthor means the node's author is not stored.
+	ProblemMissingAuthor
+	// ProblemMissingCommunity means the reply's community is not stored.
+	ProblemMissingCommunity
+)
+
+func (p IntegrityProblem) String() string {
+	switch p {
+	case ProblemUnreadable:
+		return "unreadable"
+	case ProblemHashMismatch:
+		return "hash mismatch"
+	case ProblemMalformed:
+		return "malformed"
+	case ProblemBadSignature:
+		return "bad signature"
+	case ProblemMissingParent:
+		return "missing parent"
+	case ProblemMissingAuthor:
+		return "missing author"
+	case ProblemMissingCommunity:
+		return "missing community"
+	default:
+		return fmt.Sprintf("problem %d", int(p))
+	}
+}
+
+// Corrupt reports whether the problem is with the node itself, rather than
+// with the nodes that it references. Only corrupt nodes are quarantined.
+func (p IntegrityProblem) Corrupt() bool {
+	return p <= ProblemBadSignature
+}
+
+// IntegrityIssue is a single problem found by the integrity checker.
+type IntegrityIssue struct {
+	// Node is the ID of the affected node, or the name of its file if the
+	// node could not be read.
+	Node    string
+	Problem IntegrityProblem
+	// Detail explains the problem, such as the ID of a missing node.
+	Detail string
+	// Quarantine is the path that the node's file was moved to, if it was
+	// quarantined.
+	Quarantine string
+}
+
+func (i IntegrityIssue) String() string {
+	text := fmt.Sprintf("%s: %s", i.Node, i.Problem)
+	if i.Detail != "" {
+		text += " (" + i.Detail + ")"
+	}
+	if i.Quarantine != "" {
+		text += ", moved to " + i.Quarantine
+	}
+	return text
+}
+
+// IntegrityReport is the outcome of checking a store.
+type IntegrityReport struct {
+	Backend  StoreBackend
+	Started  time.Time
+	Duration time.Duration
+	// Checked is the number of stored nodes examined.
+	Checked int
+	Issues  []IntegrityIssue
+	// Note explains any limitation of the check.
+	Note string
+}
+
+// Quarantined returns the number of nodes that were moved to quarantine.
+func (r *IntegrityReport) Quarantined() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Quarantine != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary describes the report in a single line.
+func (r *IntegrityReport) Summary() string {
+	if len(r.Issues) == 0 {
+		return fmt.Sprintf("Checked %d nodes, no problems found.", r.Checked)
+	}
+	counts := make(map[IntegrityProblem]int)
+	for _, issue := range r.Issues {
+		counts[issue.Problem]++
+	}
+	problems := make([]string, 0, len(counts))
+	for problem := ProblemUnreadable; problem <= ProblemMissingCommunity; problem++ {
+		if counts[problem] > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", counts[problem], problem))
+		}
+	}
+	text := fmt.Sprintf("Checked %d nodes: %s.", r.Checked, strings.Join(problems, ", "))
+	if quarantined := r.Quarantined(); quarantined > 0 {
+		text += fmt.Sprintf(" %d quarantined.", quarantined)
+	}
+	return text
+}
+
+func (r *IntegrityReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Integrity check of %s store started %s, took %v\n", r.Backend, r.Started.Format(time.RFC3339), r.Duration.Round(time.Millisecond))
+	fmt.Fprintln(&b, r.Summary())
+	if r.Note != "" {
+		fmt.Fprintln(&b, r.Note)
+	}
+	for _, issue := range r.Issues {
+		fmt.Fprintln(&b, issue)
+	}
+	return b.String()
+}
+
+// integrityReportFile is the name of the file within the quarantine directory
+// that the most recent report is written to.
+const integrityReportFile = "integrity-report.txt"
+
+// checkIntegrity examines every node of s, which holds the given backend.
+// Grove stores, including encrypted ones, are read file by file so that
+// unreadable files are found, and corrupt files are moved into the
+// quarantine directory if quarantine is set. Orchard stores are only
+// reported on.
+//
+// If the store is written to during the check, pause must stop the writes
+// until the function it returns is called. Writes are only paused while
+// each corrupt file is read again and moved, so that files written since
+// they were first read are not quarantined.
+func checkIntegrity(ctx context.Context, backend StoreBackend, s forest.Store, quarantineDir string, quarantine bool, pause func() (resume func())) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		Backend: backend,
+		Started: time.Now(),
+	}
+	var (
+		g     *grove.Grove
+		nodes map[string]forest.Node
+		// files maps the ID of each node to the file it was read from.
+		files map[string]string
+		err   error
+	)
+	if readOnly, ok := s.(*readOnlyStore); ok {
+		s = readOnly.Store
+	}
+	switch backend {
+	case GroveBackend, EncryptedBackend:
+		var ok bool
+		if g, ok = s.(*grove.Grove); !ok {
+			return nil, fmt.Errorf("store %T is not a grove", s)
+		}
+		nodes, files, err = readGroveFiles(ctx, g, report)
+	case OrchardBackend:
+		files = make(map[string]string)
+		nodes, err = readStoreNodes(s, report)
+		if quarantine {
+			report.Note = "Orchard stores are checked but not quarantined; use store recovery to replace a damaged Orchard store."
+		}
+	default:
+		err = fmt.Errorf("unknown store backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Checked = len(report.Issues) + len(nodes)
+
+	checkSignatures(nodes, report)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	checkReferences(nodes, report)
+
+	if quarantine {
+		for i := range report.Issues {
+			issue := &report.Issues[i]
+			file, ok := files[issue.Node]
+			if !ok || !issue.Problem.Corrupt() {
+				continue
+			}
+			resume := func() {}
+			if pause != nil {
+				resume = pause()
+			}
+			// The content of a readable file is fixed by its name, so only
+			// unreadable files can have been replaced since they were read.
+			if issue.Problem != ProblemBadSignature {
+				if _, _, err := readNodeFile(g, issue.Node); err == nil {
+					resume()
+					issue.Detail += "; rewritten during the check, so not quarantined"
+					continue
+				}
+			}
+			target, err := quarantineFile(file, quarantineDir)
+			resume()
+			if err != nil {
+				issue.Detail += fmt.Sprintf("; failed quarantining: %v", err)
+				continue
+			}
+			issue.Quarantine = target
+		}
+	}
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// readGroveFiles reads every node file in the grove. Files that cannot be
+// read, that do not match their names, or that are malformed are recorded in
+// the report, and the rest are returned by ID along with the paths of the
+// files they were read from.
+func readGroveFiles(ctx context.Context, g *grove.Grove, report *IntegrityReport) (map[string]forest.Node, map[string]string, error) {
+	var root string
+	switch fs := g.FS.(type) {
+	case grove.RelativeFS:
+		root = fs.Root
+	case *encryptedFS:
+		root = fs.root
+	default:
+		return nil, nil, fmt.Errorf("grove filesystem %T is not on disk", g.FS)
+	}
+	dir, err := g.FS.Open("")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed opening grove: %w", err)
+	}
+	infos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed listing grove files: %w", err)
+	}
+	nodes := make(map[string]forest.Node, len(infos))
+	files := make(map[string]string, len(infos))
+	for _, info := range infos {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		name := info.Name()
+		if info.IsDir() || !isNodeFileName(name) {
+			continue
+		}
+		files[name] = filepath.Join(root, name)
+		node, problem, err := readNodeFile(g, name)
+		if err != nil {
+			report.add(name, problem, err.Error())
+			continue
+		}
+		nodes[name] = node
+	}
+	return nodes, files, nil
+}
+
+// readNodeFile reads the node stored in the named grove file. If the file is
+// damaged, the problem is returned along with an error describing it.
+func readNodeFile(g *grove.Grove, name string) (forest.Node, IntegrityProblem, error) {
+	var id fields.QualifiedHash
+	if err := id.UnmarshalText([]byte(name)); err != nil {
+		return nil, ProblemUnreadable, err
+	}
+	data, err := readGroveFile(g, name)
+	if err != nil {
+		return nil, ProblemUnreadable, err
+	}
+	node, err := forest.UnmarshalBinaryNode(data)
+	if err != nil {
+		return nil, ProblemUnreadable, err
+	}
+	if !node.ID().Equals(&id) {
+		return nil, ProblemHashMismatch, fmt.Errorf("content hashes to %s", node.ID())
+	}
+	if err := node.ValidateInternal(); err != nil {
+		return nil, ProblemMalformed, err
+	}
+	return node, 0, nil
+}
+
+// readGroveFile returns the contents of the named file in the grove.
+func readGroveFile(g *grove.Grove, name string) ([]byte, error) {
+	file, err := g.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// isNodeFileName reports whether the file name has the form of a node ID.
+func isNodeFileName(name string) bool {
+	for _, hashName := range fields.HashNames {
+		if strings.HasPrefix(name, hashName) {
+			return true
+		}
+	}
+	return false
+}
+
+// integritySink collects the nodes copied out of a store.
+type integritySink struct {
+	forest.Store
+	nodes []forest.Node
+}
+
+func (i *integritySink) Add(node forest.Node) error {
+	i.nodes = append(i.nodes, node)
+	return nil
+}
+
+// readStoreNodes reads every node out of s. Malformed nodes are recorded in
+// the report, and the rest are returned by ID.
+func readStoreNodes(s forest.Store, report *IntegrityReport) (map[string]forest.Node, error) {
+	copiable, ok := s.(forest.Copiable)
+	if !ok {
+		return nil, fmt.Errorf("store %T cannot be enumerated", s)
+	}
+	sink := &integritySink{}
+	if err := copiable.CopyInto(sink); err != nil {
+		return nil, fmt.Errorf("failed reading stored nodes: %w", err)
+	}
+	nodes := make(map[string]forest.Node, len(sink.nodes))
+	for _, node := range sink.nodes {
+		id := node.ID().String()
+		if err := node.ValidateInternal(); err != nil {
+			report.add(id, ProblemMalformed, err.Error())
+			continue
+		}
+		nodes[id] = node
+	}
+	return nodes, nil
+}
+
+// checkSignatures verifies the signature of every node against its author.
+// Nodes with bad signatures are recorded in the report and removed from
+// nodes, as are nodes whose author is not an identity. Identities are
+// checked first so that nodes signed by a forged identity are not trusted.
+func checkSignatures(nodes map[string]forest.Node, report *IntegrityReport) {
+	sorted := sortForImport(nodes)
+	for _, node := range sorted {
+		id := node.ID().String()
+		signed, ok := node.(forest.SignatureValidator)
+		if !ok {
+			report.add(id, ProblemMalformed, fmt.Sprintf("node type %T cannot be signed", node))
+			delete(nodes, id)
+			continue
+		}
+		author, ok := node.(*forest.Identity)
+		if !ok {
+			authorNode, has := nodes[node.AuthorID().String()]
+			if !has {
+				// Reported by checkReferences.
+				continue
+			}
+			if author, ok = authorNode.(*forest.Identity); !ok {
+				report.add(id, ProblemMalformed, "author "+node.AuthorID().String()+" is not an identity")
+				delete(nodes, id)
+				continue
+			}
+		}
+		if valid, err := forest.ValidateSignature(signed, author); err != nil || !valid {
+			detail := "signature does not match author"
+			if err != nil {
+				detail = err.Error()
+			}
+			report.add(id, ProblemBadSignature, detail)
+			delete(nodes, id)
+		}
+	}
+}
+
+// checkReferences records every node whose parent, author, or community is
+// not among nodes.
+func checkReferences(nodes map[string]forest.Node, report *IntegrityReport) {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	missing := func(ref *fields.QualifiedHash) bool {
+		if ref.Equals(fields.NullHash()) {
+			return false
+		}
+		_, has := nodes[ref.String()]
+		return !has
+	}
+	for _, id := range ids {
+		node := nodes[id]
+		if missing(node.ParentID()) {
+			report.add(id, ProblemMissingParent, node.ParentID().String())
+		}
+		if _, isIdentity := node.(*forest.Identity); !isIdentity && missing(node.AuthorID()) {
+			report.add(id, ProblemMissingAuthor, node.AuthorID().String())
+		}
+		if reply, ok := node.(*forest.Reply); ok && !reply.CommunityID.Equals(reply.ParentID()) && missing(&reply.CommunityID) {
+			report.add(id, ProblemMissingCommunity, reply.CommunityID.String())
+		}
+	}
+}
+
+func (r *IntegrityReport) add(node string, problem IntegrityProblem, detail string) {
+	r.Issues = append(r.Issues, IntegrityIssue{
+		Node:    node,
+		Problem: problem,
+		Detail:  detail,
+	})
+}
+
+// quarantineFile moves the file into dir, returning its new path. A file
+// already quarantined under the same name is kept.
+func quarantineFile(file, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", fmt.Errorf("failed creating quarantine directory: %w", err)
+	}
+	target := filepath.Join(dir, filepath.Base(file))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s.%d", filepath.Base(file), i))
+	}
+	if err := os.Rename(file, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// writeIntegrityReport saves the report in the quarantine directory,
+// replacing the previous report, and returns the path of the file.
+func writeIntegrityReport(report *IntegrityReport, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", fmt.Errorf("failed creating quarantine directory: %w", err)
+	}
+	path := filepath.Join(dir, integrityReportFile)
+	if err := ioutil.WriteFile(path, []byte(report.String()), 0660); err != nil {
+		return "", fmt.Errorf("failed writing integrity report: %w", err)
+	}
+	return path, nil
+}
+
+// CheckStoreIntegrity checks the store within stateDir without starting the
+// rest of the application, so it must not be used while Sprig is running on
+// the same directory. If quarantine is set, corrupt node files are moved to
+// the quarantine directory. The report is also saved to the quarantine
+// directory.
+func CheckStoreIntegrity(stateDir string, quarantine bool) (*IntegrityReport, error) {
+	settings, err := newSettingsService(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading settings: %w", err)
+	}
+	backend := settings.StoreBackend()
+	if backend == EncryptedBackend {
+		return nil, fmt.Errorf("the store is encrypted; check it from the settings within Sprig")
+	}
+	s, err := openStore(settings.DataPath(), backend, nil)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := s.(io.Closer); ok {
+		defer closer.Close()
+	}
+	report, err := checkIntegrity(context.Background(), backend, s, settings.QuarantineDir(), quarantine, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writeIntegrityReport(report, settings.QuarantineDir()); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func (a *arborService) CheckIntegrity(ctx context.Context, quarantine bool) (*IntegrityReport, error) {
+	if err := a.StoreError(); err != nil {
+		return nil, fmt.Errorf("store is unavailable: %w", err)
+	}
+	if a.StoreLocked() {
+		return nil, ErrStoreLocked
+	}
+	path := a.SettingsService.DataPath()
+	quarantineDir := a.SettingsService.QuarantineDir()
+	if a.StoreReadOnly() {
+		quarantine = false
+	}
+	a.storeLock.Lock()
+	backend, key := a.backend, a.key
+	a.storeLock.Unlock()
+	checked := a.grove.UnderlyingStore()
+	report, err := checkIntegrity(ctx, backend, checked, quarantineDir, quarantine, a.grove.PauseWrites)
+	if err != nil {
+		return nil, err
+	}
+	if backend != OrchardBackend && report.Quarantined() > 0 {
+		// The grove caches the nodes it has read, so replace it with a
+		// fresh one that only knows about the files that remain. Writes
+		// wait so that none are made to the store being replaced.
+		resume := a.grove.PauseWrites()
+		s, err := openStore(path, backend, key)
+		if err != nil {
+			resume()
+			return report, fmt.Errorf("failed reopening store after quarantine: %w", err)
+		}
+		a.storeLock.Lock()
+		if a.grove.UnderlyingStore() != checked {
+			// The store was replaced during the check, and the new one
+			// does not cache the quarantined nodes.
+			a.storeLock.Unlock()
+			if closer, ok := s.(io.Closer); ok {
+				closer.Close()
+			}
+		} else {
+			previous := a.grove.Replace(s)
+			a.storeLock.Unlock()
+			if closer, ok := previous.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+		resume()
+		// Drop the quarantined nodes from the lists showing the store.
+		var removed []*fields.QualifiedHash
+		for _, issue := range report.Issues {
+			id := new(fields.QualifiedHash)
+			if issue.Quarantine != "" && id.UnmarshalText([]byte(issue.Node)) == nil {
+				removed = append(removed, id)
+			}
+		}
+		if len(removed) > 0 {
+			a.grove.notifyRemoved(removed)
+		}
+	}
+	if _, err := writeIntegrityReport(report, quarantineDir); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckIntegrityRereadsBeforeQuarantine(t *testing.T) {
+	dir := newTestDataDir(t)
+	quarantineDir := filepath.Join(dir, "quarantine")
+	tree := newTestTree(t)
+	s, err := openStore(dir, GroveBackend, nil)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	addTestNodes(t, s, tree.nodes()...)
+
+	// The reply is damaged for good, while the conversation is caught in
+	// the middle of being written and is complete once writes pause.
+	damage := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("damaged"), 0660); err != nil {
+			t.Fatalf("damaging %s: %v", name, err)
+		}
+	}
+	damage(tree.reply.ID().String())
+	damage(tree.conversation.ID().String())
+	conversation, err := tree.conversation.MarshalBinary()
+	if err != nil {
+		t.Fatalf("serializing conversation: %v", err)
+	}
+	pauses, resumes := 0, 0
+	pause := func() func() {
+		pauses++
+		if err := ioutil.WriteFile(filepath.Join(dir, tree.conversation.ID().String()), conversation, 0660); err != nil {
+			t.Fatalf("rewriting conversation: %v", err)
+		}
+		return func() { resumes++ }
+	}
+
+	report, err := checkIntegrity(context.Background(), GroveBackend, s, quarantineDir, true, pause)
+	if err != nil {
+		t.Fatalf("checking: %v", err)
+	}
+	if pauses != 2 || resumes != pauses {
+		t.Errorf("writes paused %d times and resumed %d times, expected once for each damaged file", pauses, resumes)
+	}
+	if quarantined := report.Quarantined(); quarantined != 1 {
+		t.Errorf("quarantined %d files, expected 1: %v", quarantined, report)
+	}
+	for _, c := range []struct {
+		name        string
+		quarantined bool
+	}{
+		{tree.reply.ID().String(), true},
+		{tree.conversation.ID().String(), false},
+	} {
+		_, err := os.Stat(filepath.Join(quarantineDir, c.name))
+		if quarantined := err == nil; quarantined != c.quarantined {
+			t.Errorf("%s quarantined %v, expected %v", c.name, quarantined, c.quarantined)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package core
+
+// Invalidator requests that the user interface be redrawn. *app.Window from
+// gioui.org/app implements it.
+type Invalidator interface {
+	Invalidate()
+}
+
+// NoopInvalidator is an Invalidator for applications without a user
+// interface. It does nothing.
+type NoopInvalidator struct{}
+
+var _ Invalidator = NoopInvalidator{}
+
+// Invalidate does nothing.
+func (NoopInvalidator) Invalidate() {}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ErrKeyLocked is returned when the private key of the active identity is
+// protected by a passphrase that has not been provided.
+var ErrKeyLocked = errors.New("private key is locked")
+
+// readKeyFile reads the private key stored at path, which may be encrypted.
+func readKeyFile(path string) (*openpgp.Entity, error) {
+	keyfile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file: %w", err)
+	}
+	defer keyfile.Close()
+	entity, err := openpgp.ReadEntity(packet.NewReader(keyfile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode key data: %w", err)
+	}
+	return entity, nil
+}
+
+// entityKeys returns the private keys of the entity and its subkeys.
+func entityKeys(entity *openpgp.Entity) []*packet.PrivateKey {
+	keys := []*packet.PrivateKey{entity.PrivateKey}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil {
+			keys = append(keys, subkey.PrivateKey)
+		}
+	}
+	return keys
+}
+
+// entityEncrypted reports whether the private key of the entity is encrypted.
+func entityEncrypted(entity *openpgp.Entity) bool {
+	return entity.PrivateKey != nil && entity.PrivateKey.Encrypted
+}
+
+// decryptEntity decrypts every private key of the entity in place. Keys that
+// are not encrypted are left alone.
+func decryptEntity(entity *openpgp.Entity, passphrase string) error {
+	for _, key := range entityKeys(entity) {
+		if !key.Encrypted {
+			continue
+		}
+		if err := key.Decrypt([]byte(passphrase)); err != nil {
+			return ErrWrongPassphrase
+		}
+	}
+	return nil
+}
+
+// encryptEntity encrypts every private key of the entity in place, after
+// which it can no longer sign until it is decrypted.
+func encryptEntity(entity *openpgp.Entity, passphrase string) error {
+	for _, key := range entityKeys(entity) {
+		if err := key.Encrypt([]byte(passphrase)); err != nil {
+			return fmt.Errorf("failed encrypting private key: %w", err)
+		}
+	}
+	return nil
+}
+
+// keyFile returns the path of the active identity's private key. The caller
+// must hold identityLock.
+func (s *settingsService) keyFile() (string, error) {
+	if s.ActiveIdentity == nil {
+		return "", fmt.Errorf("no identity configured, therefore no private key")
+	}
+	return filepath.Join(s.KeysDir(), s.ActiveIdentity.String()), nil
+}
+
+func (s *settingsService) KeyProtected() bool {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	if s.activePrivKey != nil {
+		return s.keyProtected
+	}
+	path, err := s.keyFile()
+	if err != nil {
+		return false
+	}
+	entity, err := readKeyFile(path)
+	return err == nil && entityEncrypted(entity)
+}
+
+func (s *settingsService) KeyLocked() bool {
+	if !s.KeyProtected() {
+		return false
+	}
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return s.activePrivKey == nil
+}
+
+func (s *settingsService) UnlockKey(passphrase string) error {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	path, err := s.keyFile()
+	if err != nil {
+		return err
+	}
+	entity, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	protected := entityEncrypted(entity)
+	if err := decryptEntity(entity, passphrase); err != nil {
+		return err
+	}
+	s.activePrivKey = entity
+	s.keyProtected = protected
+	s.touchKey()
+	return nil
+}
+
+func (s *settingsService) LockKey() {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.lockKey()
+}
+
+// lockKey forgets the decrypted private key of the active identity if it is
+// protected by a passphrase. The caller must hold identityLock.
+func (s *settingsService) lockKey() {
+	if s.keyTimer != nil {
+		s.keyTimer.Stop()
+		s.keyTimer = nil
+	}
+	if s.keyProtected {
+		s.activePrivKey = nil
+		s.keyProtected = false
+		for _, handler := range s.keyLockHandlers {
+			go handler()
+		}
+	}
+}
+
+func (s *settingsService) OnKeyLocked(handler func()) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.keyLockHandlers = append(s.keyLockHandlers, handler)
+}
+
+// touchKey restarts the session timeout of a protected private key, which is
+// locked again once it has gone unused for the timeout. The caller must hold
+// identityLock.
+func (s *settingsService) touchKey() {
+	timeout := time.Duration(s.Settings.KeyTimeoutMinutes) * time.Minute
+	if !s.keyProtected || timeout <= 0 {
+		if s.keyTimer != nil {
+			s.keyTimer.Stop()
+			s.keyTimer = nil
+		}
+		return
+	}
+	if s.keyTimer != nil {
+		s.keyTimer.Reset(timeout)
+		return
+	}
+	s.keyTimer = time.AfterFunc(timeout, s.LockKey)
+}
+
+func (s *settingsService) SetKeyPassphrase(current, passphrase string) error {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	path, err := s.keyFile()
+	if err != nil {
+		return err
+	}
+	entity, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if err := decryptEntity(entity, current); err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := entity.SerializePrivateWithoutSigning(&data, nil); err != nil {
+		return fmt.Errorf("failed serializing private key: %w", err)
+	}
+	if passphrase != "" {
+		// Encrypt a copy, as the decrypted entity is kept for signing.
+		protected, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(data.Bytes())))
+		if err != nil {
+			return fmt.Errorf("failed copying private key: %w", err)
+		}
+		if err := encryptEntity(protected, passphrase); err != nil {
+			return err
+		}
+		data.Reset()
+		if err := protected.SerializePrivateWithoutSigning(&data, nil); err != nil {
+			return fmt.Errorf("failed serializing private key: %w", err)
+		}
+	}
+	if err := writeFileAtomic(path, data.Bytes(), 0660); err != nil {
+		return fmt.Errorf("failed saving private key: %w", err)
+	}
+	s.activePrivKey = entity
+	s.keyProtected = passphrase != ""
+	s.touchKey()
+	return nil
+}
+
+func (s *settingsService) KeyTimeout() time.Duration {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return time.Duration(s.Settings.KeyTimeoutMinutes) * time.Minute
+}
+
+func (s *settingsService) SetKeyTimeout(timeout time.Duration) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.Settings.KeyTimeoutMinutes = int(timeout / time.Minute)
+	s.touchKey()
+}
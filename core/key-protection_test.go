@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestSettings creates a settings service in a temporary directory with
+// an active identity whose key is protected by the passphrase and unlocked.
+func newTestSettings(t *testing.T, passphrase string) *settingsService {
+	t.Helper()
+	service, err := newSettingsService(newTestDataDir(t))
+	if err != nil {
+		t.Fatalf("creating settings: %v", err)
+	}
+	s := service.(*settingsService)
+	if err := s.CreateIdentity("test"); err != nil {
+		t.Fatalf("creating identity: %v", err)
+	}
+	if passphrase != "" {
+		if err := s.SetKeyPassphrase("", passphrase); err != nil {
+			t.Fatalf("protecting key: %v", err)
+		}
+	}
+	return s
+}
+
+func TestHeartbeatStopsWhenKeyLocks(t *testing.T) {
+	settings := newTestSettings(t, "passphrase")
+	a := &arborService{SettingsService: settings}
+	stopped := make(chan struct{})
+	a.stopHeartbeat = func() { close(stopped) }
+	settings.OnKeyLocked(a.keyLocked)
+
+	settings.LockKey()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("heartbeat still running after the key was locked")
+	}
+	a.heartbeatLock.Lock()
+	running := a.stopHeartbeat != nil
+	a.heartbeatLock.Unlock()
+	if running {
+		t.Errorf("heartbeat recorded as running after the key was locked")
+	}
+	if _, err := settings.Builder(); !errors.Is(err, ErrKeyLocked) {
+		t.Errorf("Builder returned %v after the key was locked, expected ErrKeyLocked", err)
+	}
+}
+
+func TestHeartbeatKeepsRunningWhileKeyUnlocked(t *testing.T) {
+	settings := newTestSettings(t, "passphrase")
+	a := &arborService{SettingsService: settings}
+	a.stopHeartbeat = func() {
+		t.Errorf("heartbeat stopped while the key is unlocked")
+	}
+	// A lock that was followed by an unlock does not stop the heartbeat
+	// started after the unlock.
+	a.keyLocked()
+	if a.stopHeartbeat == nil {
+		t.Errorf("heartbeat recorded as stopped while the key is unlocked")
+	}
+}
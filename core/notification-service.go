@@ -1,12 +1,14 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
 	"git.sr.ht/~whereswaldon/forest-go/store"
 	niotify "gioui.org/x/notify"
 )
@@ -22,6 +24,7 @@ type NotificationService interface {
 // methods to send notifications and choose (based on settings)
 // whether to notify for a given arbor message.
 type notificationManager struct {
+	tasks *taskGroup
 	SettingsService
 	ArborService
 	niotify.Manager
@@ -32,12 +35,13 @@ var _ NotificationService = &notificationManager{}
 
 // newNotificationService constructs a new NotificationService for the
 // provided App.
-func newNotificationService(settings SettingsService, arbor ArborService) (NotificationService, error) {
+func newNotificationService(tasks *taskGroup, settings SettingsService, arbor ArborService) (NotificationService, error) {
 	m, err := niotify.NewManager()
 	if err != nil {
 		return nil, fmt.Errorf("failed initializing notification support: %w", err)
 	}
 	return &notificationManager{
+		tasks:           tasks,
 		SettingsService: settings,
 		ArborService:    arbor,
 		Manager:         m,
@@ -48,7 +52,7 @@ func newNotificationService(settings SettingsService, arbor ArborService) (Notif
 // Register configures the store so that new nodes will generate notifications
 // if notifications are appropriate (based on current user settings).
 func (n *notificationManager) Register(s store.ExtendedStore) {
-	s.SubscribeToNewMessages(n.handleNode)
+	n.tasks.Subscribe(s, n.handleNode)
 }
 
 // shouldNotify returns whether or not a node should generate a notification
@@ -91,7 +95,18 @@ func (n *notificationManager) shouldNotify(reply *forest.Reply) bool {
 		// do not send old notifications
 		return false
 	}
-	if reply.Author.Equals(localUserID) {
+	// The local user's identity includes the identities that it replaced
+	// when the profile changed.
+	localUserIDs := n.SettingsService.ActiveIdentityIDs()
+	authoredLocally := func(author *fields.QualifiedHash) bool {
+		for _, id := range localUserIDs {
+			if author.Equals(id) {
+				return true
+			}
+		}
+		return false
+	}
+	if authoredLocally(&reply.Author) {
 		// Do not send notifications for replies created by the local
 		// user's identity.
 		return false
@@ -105,7 +120,7 @@ func (n *notificationManager) shouldNotify(reply *forest.Reply) bool {
 		// Don't notify if we don't know about this conversation.
 		return false
 	}
-	if parent.(*forest.Reply).Author.Equals(localUserID) {
+	if authoredLocally(&parent.(*forest.Reply).Author) {
 		// Direct response to local user.
 		return true
 	}
@@ -130,7 +145,8 @@ func (n *notificationManager) Notify(title, content string) error {
 // function on a store.ExtendedStore, as it will not block.
 func (n *notificationManager) handleNode(node forest.Node) {
 	if asReply, ok := node.(*forest.Reply); ok {
-		go func(reply *forest.Reply) {
+		reply := asReply
+		n.tasks.Go(func(context.Context) {
 			if !n.shouldNotify(reply) {
 				return
 			}
@@ -151,6 +167,6 @@ func (n *notificationManager) handleNode(node forest.Node) {
 			if err != nil {
 				log.Printf("failed sending notification: %v", err)
 			}
-		}(asReply)
+		})
 	}
 }
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+)
+
+// Option configures an App constructed by NewApp.
+type Option func(*app)
+
+// WithContext provides a context that stops the background work of the
+// App's services when cancelled. The default is context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(a *app) {
+		a.ctx = ctx
+	}
+}
+
+// WithInvalidator provides the Invalidator used to request redraws, such as
+// the window that the App renders into.
+func WithInvalidator(invalidator Invalidator) Option {
+	return func(a *app) {
+		a.invalidator = invalidator
+	}
+}
+
+// WithStateDir provides the directory in which the default SettingsService
+// stores settings, identities, and nodes. It is required unless a
+// SettingsService is provided with WithSettings.
+func WithStateDir(dir string) Option {
+	return func(a *app) {
+		a.stateDir = dir
+	}
+}
+
+// WithSettings replaces the default SettingsService. The other default
+// services derive their configuration from it.
+func WithSettings(settings SettingsService) Option {
+	return func(a *app) {
+		a.SettingsService = settings
+	}
+}
+
+// WithArbor replaces the default ArborService.
+func WithArbor(arbor ArborService) Option {
+	return func(a *app) {
+		a.ArborService = arbor
+	}
+}
+
+// WithSprout replaces the default SproutService.
+func WithSprout(sprout SproutService) Option {
+	return func(a *app) {
+		a.SproutService = sprout
+	}
+}
+
+// WithNotifications replaces the default NotificationService.
+func WithNotifications(notifications NotificationService) Option {
+	return func(a *app) {
+		a.NotificationService = notifications
+	}
+}
+
+// WithOutbox replaces the default OutboxService.
+func WithOutbox(outbox OutboxService) Option {
+	return func(a *app) {
+		a.OutboxService = outbox
+	}
+}
+
+// WithAncestry replaces the default AncestryService.
+func WithAncestry(ancestry AncestryService) Option {
+	return func(a *app) {
+		a.ancestry = ancestry
+	}
+}
+
+// WithSearch replaces the default SearchService.
+func WithSearch(search SearchService) Option {
+	return func(a *app) {
+		a.search = search
+	}
+}
+
+// WithHaptic replaces the default HapticService, which does nothing.
+func WithHaptic(haptic HapticService) Option {
+	return func(a *app) {
+		a.HapticService = haptic
+	}
+}
@@ -0,0 +1,390 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// DeliveryState describes whether a locally-authored node has reached a
+// relay.
+type DeliveryState uint8
+
+const (
+	// DeliveryPending nodes have not yet been acknowledged by any relay.
+	DeliveryPending DeliveryState = iota
+	// DeliverySent nodes were acknowledged by at least one relay.
+	DeliverySent
+	// DeliveryFailed nodes were refused or went unacknowledged by every relay
+	// too many times. They are retried when a relay reconnects.
+	DeliveryFailed
+)
+
+func (d DeliveryState) String() string {
+	switch d {
+	case DeliveryPending:
+		return "pending"
+	case DeliverySent:
+		return "sent"
+	case DeliveryFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// OutboxService delivers nodes authored by the local user to relays and
+// tracks whether they were delivered. Undelivered nodes are persisted so
+// that delivery resumes after a restart, along with a record of the most
+// recently delivered ones.
+type OutboxService interface {
+	// Send adds the nodes to the local store, which announces them to every
+	// connected relay, and tracks their delivery.
+	Send(nodes ...forest.Node) error
+	// State returns the delivery state of the node with the given ID. It
+	// returns false if the node was not sent through the outbox.
+	State(id *fields.QualifiedHash) (DeliveryState, bool)
+	// Retry immediately attempts to deliver all undelivered nodes.
+	Retry()
+}
+
+const (
+	// maxDeliveryAttempts is the number of unacknowledged attempts after
+	// which a node is marked as Failed.
+	maxDeliveryAttempts = 5
+	// outboxRetryInterval is how often pending nodes are retried.
+	outboxRetryInterval = time.Minute
+	// outboxSentHistory is the number of delivered nodes whose state is
+	// persisted.
+	outboxSentHistory = 1024
+)
+
+// outboxEntry is the persisted record of a node sent through the outbox.
+type outboxEntry struct {
+	ID string
+	// Node holds the binary serialization of the node. It is omitted once
+	// the node has been delivered.
+	Node     []byte `json:",omitempty"`
+	State    DeliveryState
+	Attempts int
+	// Err describes the most recent delivery failure.
+	Err string `json:",omitempty"`
+
+	node forest.Node
+	// attempted is when the node was last announced.
+	attempted time.Time
+}
+
+type outboxService struct {
+	tasks *taskGroup
+	ArborService
+	SproutService
+	Invalidator
+	path string
+
+	sync.Mutex
+	entries map[string]*outboxEntry
+	// order holds the IDs of the entries in the order they were sent.
+	order []string
+	// retry is signalled to request a delivery attempt.
+	retry chan struct{}
+}
+
+var _ OutboxService = &outboxService{}
+
+func newOutboxService(tasks *taskGroup, settings SettingsService, arbor ArborService, sprout SproutService, invalidator Invalidator) (OutboxService, error) {
+	o := &outboxService{
+		tasks:         tasks,
+		ArborService:  arbor,
+		SproutService: sprout,
+		Invalidator:   invalidator,
+		path:          filepath.Join(settings.DataPath(), "outbox.json"),
+		entries:       make(map[string]*outboxEntry),
+		retry:         make(chan struct{}, 1),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	arbor.Propagation().Subscribe(func(id *fields.QualifiedHash, propagation Propagation) {
+		if propagation.Kind == Acknowledged {
+			o.acknowledge(id)
+		}
+	})
+	sprout.SubscribeToStatus(func(status ConnectionStatus) {
+		if status.State == Connected {
+			o.Retry()
+		}
+	})
+	tasks.Go(o.run)
+	return o, nil
+}
+
+// load restores the outbox from disk, re-adding the undelivered nodes to
+// the local store in case it did not persist them. An outbox file that
+// cannot be parsed is moved aside to the same path with a ".corrupt" suffix
+// so that it can be recovered, and the outbox starts empty.
+func (o *outboxService) load() error {
+	data, err := ioutil.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed reading outbox: %w", err)
+	}
+	var entries []*outboxEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("failed parsing outbox, starting with an empty one: %v", err)
+		if err := os.Rename(o.path, o.path+".corrupt"); err != nil {
+			log.Printf("failed moving aside unreadable outbox: %v", err)
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.State != DeliverySent {
+			node, err := forest.UnmarshalBinaryNode(entry.Node)
+			if err != nil {
+				log.Printf("discarding unreadable outbox entry: %v", err)
+				continue
+			}
+			entry.node = node
+			entry.ID = node.ID().String()
+			if err := o.ArborService.Store().Add(node); err != nil {
+				log.Printf("failed restoring outbox node %s to store: %v", node.ID(), err)
+			}
+		}
+		if _, duplicate := o.entries[entry.ID]; duplicate || entry.ID == "" {
+			continue
+		}
+		o.entries[entry.ID] = entry
+		o.order = append(o.order, entry.ID)
+	}
+	return nil
+}
+
+// persist writes all undelivered nodes and the state of the most recently
+// delivered ones to disk. It must be called with the lock held.
+func (o *outboxService) persist() error {
+	entries := make([]*outboxEntry, 0, len(o.order))
+	sent := 0
+	for i := len(o.order) - 1; i >= 0; i-- {
+		entry := o.entries[o.order[i]]
+		if entry.State == DeliverySent {
+			if sent >= outboxSentHistory {
+				continue
+			}
+			sent++
+			entry = &outboxEntry{ID: entry.ID, State: DeliverySent}
+		}
+		entries = append(entries, entry)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal outbox as json: %w", err)
+	}
+	if err := writeFileAtomic(o.path, data, 0660); err != nil {
+		return fmt.Errorf("couldn't save outbox: %w", err)
+	}
+	return nil
+}
+
+func (o *outboxService) Send(nodes ...forest.Node) error {
+	var (
+		entries = make([]*outboxEntry, 0, len(nodes))
+		// stored holds the nodes that were already stored, and so will not
+		// be announced by adding them.
+		stored []forest.Node
+	)
+	for _, node := range nodes {
+		data, err := node.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("failed serializing node %s: %w", node.ID(), err)
+		}
+		_, has, err := o.ArborService.Store().Get(node.ID())
+		if err != nil {
+			return fmt.Errorf("failed looking up node %s: %w", node.ID(), err)
+		}
+		if has {
+			stored = append(stored, node)
+		} else if err := o.ArborService.Store().Add(node); err != nil {
+			return fmt.Errorf("failed adding node %s to store: %w", node.ID(), err)
+		}
+		entries = append(entries, &outboxEntry{
+			ID:        node.ID().String(),
+			Node:      data,
+			State:     DeliveryPending,
+			node:      node,
+			attempted: time.Now(),
+		})
+	}
+	o.Lock()
+	var unacknowledged []forest.Node
+	for _, entry := range entries {
+		if _, queued := o.entries[entry.ID]; queued {
+			continue
+		}
+		// The relays may have acknowledged the node before it was queued.
+		for _, propagation := range o.ArborService.Propagation().For(entry.node.ID()) {
+			if propagation.Kind == Acknowledged {
+				entry.State = DeliverySent
+			}
+		}
+		o.entries[entry.ID] = entry
+		o.order = append(o.order, entry.ID)
+	}
+	for _, node := range stored {
+		if o.entries[node.ID().String()].State != DeliverySent {
+			unacknowledged = append(unacknowledged, node)
+		}
+	}
+	err := o.persist()
+	o.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(unacknowledged) > 0 {
+		o.tasks.Go(func(context.Context) {
+			o.deliver(unacknowledged)
+		})
+	}
+	return nil
+}
+
+// acknowledge marks the node with the given ID as Sent if it is queued.
+func (o *outboxService) acknowledge(id *fields.QualifiedHash) {
+	o.Lock()
+	entry, ok := o.entries[id.String()]
+	if !ok || entry.State == DeliverySent {
+		o.Unlock()
+		return
+	}
+	entry.State = DeliverySent
+	entry.Err = ""
+	if err := o.persist(); err != nil {
+		log.Printf("outbox: %v", err)
+	}
+	o.Unlock()
+	o.Invalidator.Invalidate()
+}
+
+func (o *outboxService) State(id *fields.QualifiedHash) (DeliveryState, bool) {
+	o.Lock()
+	defer o.Unlock()
+	entry, ok := o.entries[id.String()]
+	if !ok {
+		return DeliveryPending, false
+	}
+	return entry.State, true
+}
+
+func (o *outboxService) Retry() {
+	select {
+	case o.retry <- struct{}{}:
+	default:
+	}
+}
+
+// run attempts delivery whenever a retry is requested and periodically
+// while nodes remain undelivered, until ctx is cancelled.
+func (o *outboxService) run(ctx context.Context) {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.retry:
+			o.deliver(o.undelivered(true))
+		case <-ticker.C:
+			o.deliver(o.undelivered(false))
+		}
+	}
+}
+
+// undelivered returns the nodes awaiting delivery in the order they were
+// sent. If all is true, DeliveryFailed nodes and nodes that were announced
+// recently are included.
+func (o *outboxService) undelivered(all bool) []forest.Node {
+	o.Lock()
+	defer o.Unlock()
+	var nodes []forest.Node
+	for _, id := range o.order {
+		entry := o.entries[id]
+		switch {
+		case entry.State == DeliveryPending && (all || time.Since(entry.attempted) >= outboxRetryInterval):
+		case entry.State == DeliveryFailed && all:
+		default:
+			continue
+		}
+		nodes = append(nodes, entry.node)
+	}
+	return nodes
+}
+
+// deliver announces the nodes to every connected relay. Nodes are Sent once
+// any relay acknowledges them.
+func (o *outboxService) deliver(nodes []forest.Node) {
+	if len(nodes) == 0 {
+		return
+	}
+	connections := o.SproutService.Connections()
+	if len(connections) == 0 {
+		// Attempts only count against nodes while a relay is available.
+		return
+	}
+	var lastErr error
+	acknowledged := false
+	for _, addr := range connections {
+		worker := o.SproutService.WorkerFor(addr)
+		if worker == nil {
+			continue
+		}
+		timeout := time.NewTimer(worker.DefaultTimeout)
+		err := worker.SendAnnounce(nodes, timeout.C)
+		timeout.Stop()
+		if err != nil {
+			lastErr = fmt.Errorf("announcing to %s: %w", addr, err)
+			log.Printf("outbox: %v", lastErr)
+			continue
+		}
+		acknowledged = true
+		for _, node := range nodes {
+			o.ArborService.Propagation().Record(node.ID(), addr, Acknowledged)
+		}
+	}
+	o.Lock()
+	for _, node := range nodes {
+		entry := o.entries[node.ID().String()]
+		entry.attempted = time.Now()
+		switch {
+		case entry.State == DeliverySent:
+		case acknowledged:
+			entry.State = DeliverySent
+			entry.Err = ""
+		case lastErr != nil:
+			entry.Attempts++
+			entry.Err = lastErr.Error()
+			if entry.Attempts >= maxDeliveryAttempts {
+				entry.State = DeliveryFailed
+			} else {
+				entry.State = DeliveryPending
+			}
+		}
+	}
+	if err := o.persist(); err != nil {
+		log.Printf("outbox: %v", err)
+	}
+	o.Unlock()
+	o.Invalidator.Invalidate()
+}
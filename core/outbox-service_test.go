@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+// outboxArbor, outboxSprout and outboxSettings provide an outboxService with
+// a store, a data directory and no relay connections, so that nothing is
+// ever delivered.
+type outboxArbor struct {
+	ArborService
+	store       store.ExtendedStore
+	propagation *PropagationTracker
+}
+
+func (a outboxArbor) Store() store.ExtendedStore       { return a.store }
+func (a outboxArbor) Propagation() *PropagationTracker { return a.propagation }
+
+type outboxSprout struct{ SproutService }
+
+func (outboxSprout) SubscribeToStatus(func(ConnectionStatus)) ConnectionSubscription { return 0 }
+func (outboxSprout) Connections() []string                                           { return nil }
+
+type outboxSettings struct {
+	SettingsService
+	dir string
+}
+
+func (s outboxSettings) DataPath() string { return s.dir }
+
+// newTestOutbox creates an outboxService keeping its state in dir.
+func newTestOutbox(t *testing.T, dir string) (*outboxService, outboxArbor) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	tasks := newTaskGroup(ctx)
+	t.Cleanup(func() {
+		cancel()
+		tasks.Wait(time.Second)
+	})
+	arbor := outboxArbor{store: newSwappableStore(store.NewMemoryStore()), propagation: NewPropagationTracker()}
+	service, err := newOutboxService(tasks, outboxSettings{dir: dir}, arbor, outboxSprout{}, NoopInvalidator{})
+	if err != nil {
+		t.Fatalf("creating outbox: %v", err)
+	}
+	return service.(*outboxService), arbor
+}
+
+func TestOutboxPersists(t *testing.T) {
+	dir := newTestDataDir(t)
+	tree := newTestTree(t)
+	outbox, arbor := newTestOutbox(t, dir)
+	if err := outbox.Send(tree.nodes()...); err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+	arbor.Propagation().Record(tree.conversation.ID(), "relay", Acknowledged)
+
+	reloaded, arbor := newTestOutbox(t, dir)
+	for _, c := range []struct {
+		name  string
+		state DeliveryState
+		id    string
+	}{
+		{"conversation", DeliverySent, tree.conversation.ID().String()},
+		{"reply", DeliveryPending, tree.reply.ID().String()},
+	} {
+		entry, ok := reloaded.entries[c.id]
+		if !ok {
+			t.Errorf("%s was not reloaded", c.name)
+			continue
+		}
+		if entry.State != c.state {
+			t.Errorf("%s is %v after reloading, expected %v", c.name, entry.State, c.state)
+		}
+	}
+	if _, has, _ := arbor.Store().Get(tree.reply.ID()); !has {
+		t.Errorf("undelivered reply was not restored to the store")
+	}
+}
+
+func TestOutboxCorruptFile(t *testing.T) {
+	dir := newTestDataDir(t)
+	path := filepath.Join(dir, "outbox.json")
+	corrupt := []byte(`[{"ID": "trunc`)
+	if err := ioutil.WriteFile(path, corrupt, 0660); err != nil {
+		t.Fatalf("writing outbox: %v", err)
+	}
+	outbox, _ := newTestOutbox(t, dir)
+	if len(outbox.entries) != 0 {
+		t.Errorf("outbox has %d entries, expected none", len(outbox.entries))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("corrupt outbox was left in place: %v", err)
+	}
+	saved, err := ioutil.ReadFile(path + ".corrupt")
+	if err != nil {
+		t.Fatalf("reading moved outbox: %v", err)
+	}
+	if string(saved) != string(corrupt) {
+		t.Errorf("moved outbox holds %q, expected %q", saved, corrupt)
+	}
+
+	tree := newTestTree(t)
+	if err := outbox.Send(tree.nodes()...); err != nil {
+		t.Fatalf("sending after recovering: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("outbox was not saved after recovering: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pin records the certificate that a relay presented the first time sprig
+// connected to it.
+type Pin struct {
+	// Address is the HOST:PORT of the relay.
+	Address string
+	// Fingerprint is the hex-encoded SHA-256 hash of the pinned certificate.
+	Fingerprint string
+	FirstSeen   time.Time
+	// Pending holds the fingerprint of a changed certificate that was refused
+	// and is awaiting review by the user.
+	Pending string `json:",omitempty"`
+	// PendingTrusted is set if the pending certificate chains to a trusted
+	// certificate authority.
+	PendingTrusted bool `json:",omitempty"`
+}
+
+// PinMismatchError is returned when a relay presents a certificate that
+// differs from its pin.
+type PinMismatchError struct {
+	Address       string
+	Expected, Got string
+	// Trusted is set if the new certificate chains to a trusted certificate
+	// authority.
+	Trusted bool
+}
+
+func (p *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate for %s changed: expected %s, got %s", p.Address, ShortFingerprint(p.Expected), ShortFingerprint(p.Got))
+}
+
+// Fingerprint returns the hex-encoded SHA-256 hash of a certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ShortFingerprint abbreviates a fingerprint for display.
+func ShortFingerprint(fingerprint string) string {
+	if len(fingerprint) <= 16 {
+		return fingerprint
+	}
+	return fingerprint[:16] + "..."
+}
+
+// PinStore implements trust-on-first-use certificate pinning for relays.
+// Pins are persisted as JSON at the path provided to NewPinStore. It is safe
+// for concurrent use.
+type PinStore struct {
+	sync.Mutex
+	path string
+	pins map[string]Pin
+}
+
+// NewPinStore loads the pins stored at path. A missing file is treated as an
+// empty set of pins.
+func NewPinStore(path string) (*PinStore, error) {
+	p := &PinStore{
+		path: path,
+		pins: make(map[string]Pin),
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed reading pin store: %w", err)
+	}
+	if err := json.Unmarshal(data, &p.pins); err != nil {
+		return nil, fmt.Errorf("failed parsing pin store: %w", err)
+	}
+	return p, nil
+}
+
+// Pins returns every pin, sorted by address.
+func (p *PinStore) Pins() []Pin {
+	p.Lock()
+	defer p.Unlock()
+	out := make([]Pin, 0, len(p.pins))
+	for _, pin := range p.pins {
+		out = append(out, pin)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+// Check validates the certificate presented by the relay at address. The
+// first certificate seen for an address is pinned. A changed certificate is
+// never accepted automatically, even if it chains to a trusted certificate
+// authority: the change is recorded as pending until the user accepts it,
+// and a *PinMismatchError is returned. trusted indicates whether cert chains
+// to a trusted certificate authority, which is reported to the user.
+func (p *PinStore) Check(address string, cert *x509.Certificate, trusted bool) error {
+	p.Lock()
+	defer p.Unlock()
+	fingerprint := Fingerprint(cert)
+	pin, ok := p.pins[address]
+	switch {
+	case !ok:
+		p.pins[address] = Pin{
+			Address:     address,
+			Fingerprint: fingerprint,
+			FirstSeen:   time.Now(),
+		}
+	case pin.Fingerprint == fingerprint:
+		if pin.Pending == "" {
+			return nil
+		}
+		pin.Pending = ""
+		pin.PendingTrusted = false
+		p.pins[address] = pin
+	default:
+		pin.Pending = fingerprint
+		pin.PendingTrusted = trusted
+		p.pins[address] = pin
+		if err := p.persist(); err != nil {
+			log.Printf("failed saving pending pin: %v", err)
+		}
+		return &PinMismatchError{
+			Address:  address,
+			Expected: pin.Fingerprint,
+			Got:      fingerprint,
+			Trusted:  trusted,
+		}
+	}
+	return p.persist()
+}
+
+// Accept replaces the pin for address with its pending certificate.
+func (p *PinStore) Accept(address string) error {
+	p.Lock()
+	defer p.Unlock()
+	pin, ok := p.pins[address]
+	if !ok || pin.Pending == "" {
+		return fmt.Errorf("no pending certificate for %s", address)
+	}
+	pin.Fingerprint = pin.Pending
+	pin.Pending = ""
+	pin.PendingTrusted = false
+	pin.FirstSeen = time.Now()
+	p.pins[address] = pin
+	return p.persist()
+}
+
+// Revoke forgets the pin for address. The next certificate presented by that
+// relay will be pinned in its place.
+func (p *PinStore) Revoke(address string) error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.pins, address)
+	return p.persist()
+}
+
+// persist writes the pins to disk. It must be called with the lock held.
+func (p *PinStore) persist() error {
+	data, err := json.MarshalIndent(p.pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal pins as json: %w", err)
+	}
+	if err := writeFileAtomic(p.path, data, 0660); err != nil {
+		return fmt.Errorf("couldn't save pin store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeCertificate generates a self-signed certificate for 127.0.0.1.
+func makeCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "relay"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed parsing certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func newTestPinStore(t *testing.T) (*PinStore, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "pins")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "pins.json")
+	pins, err := NewPinStore(path)
+	if err != nil {
+		t.Fatalf("failed creating pin store: %v", err)
+	}
+	return pins, path
+}
+
+func TestPinStoreMismatch(t *testing.T) {
+	pins, path := newTestPinStore(t)
+	const address = "relay.example.com:7117"
+	first, second := makeCertificate(t).Leaf, makeCertificate(t).Leaf
+
+	if err := pins.Check(address, first, false); err != nil {
+		t.Fatalf("first certificate should be pinned, got %v", err)
+	}
+	if err := pins.Check(address, first, false); err != nil {
+		t.Fatalf("pinned certificate should be accepted, got %v", err)
+	}
+	for _, trusted := range []bool{false, true} {
+		err := pins.Check(address, second, trusted)
+		var mismatch *PinMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("changed certificate (trusted=%v) should be refused, got %v", trusted, err)
+		}
+		if mismatch.Expected != Fingerprint(first) || mismatch.Got != Fingerprint(second) || mismatch.Trusted != trusted {
+			t.Errorf("unexpected mismatch %+v", mismatch)
+		}
+		if pending := pins.Pins()[0]; pending.Pending != Fingerprint(second) || pending.PendingTrusted != trusted {
+			t.Errorf("changed certificate not pending review: %+v", pending)
+		}
+	}
+
+	reloaded, err := NewPinStore(path)
+	if err != nil {
+		t.Fatalf("failed reloading pin store: %v", err)
+	}
+	if err := reloaded.Accept(address); err != nil {
+		t.Fatalf("failed accepting pending certificate: %v", err)
+	}
+	if err := reloaded.Check(address, second, false); err != nil {
+		t.Fatalf("accepted certificate should be pinned, got %v", err)
+	}
+	if err := reloaded.Check(address, first, true); err == nil {
+		t.Fatalf("previous certificate should be refused after accepting a new one")
+	}
+}
+
+func TestTLSTransportPinMismatch(t *testing.T) {
+	pins, _ := newTestPinStore(t)
+	transports := NewTransports()
+	transports.Register("tls", &TLSTransport{Pins: pins})
+
+	serve := func(cert tls.Certificate) string {
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+		if err != nil {
+			t.Fatalf("failed listening: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+		return listener.Addr().String()
+	}
+	address := serve(makeCertificate(t))
+	conn, err := transports.Dial("tls://"+address, "")
+	if err != nil {
+		t.Fatalf("first connection should pin the certificate, got %v", err)
+	}
+	conn.Close()
+
+	// Serve a different certificate under the pin of the first relay.
+	changed := serve(makeCertificate(t))
+	pins.Lock()
+	pins.pins[changed] = pins.pins[address]
+	pins.Unlock()
+	_, err = transports.Dial("tls://"+changed, "")
+	var mismatch *PinMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("changed certificate should be refused, got %v", err)
+	}
+
+	if _, err := Dial("tls://"+changed, ""); errors.As(err, &mismatch) {
+		t.Fatalf("pins of one set of transports leaked into the defaults")
+	}
+}
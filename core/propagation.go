@@ -0,0 +1,173 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprout-go"
+)
+
+// PropagationKind describes how a relay is known to have a node.
+type PropagationKind uint8
+
+const (
+	// Received indicates that the relay sent the node to us.
+	Received PropagationKind = iota
+	// Acknowledged indicates that the relay accepted the node from us.
+	Acknowledged
+)
+
+func (p PropagationKind) String() string {
+	switch p {
+	case Received:
+		return "received from"
+	case Acknowledged:
+		return "acknowledged by"
+	default:
+		return "unknown"
+	}
+}
+
+// Propagation records that a relay is known to have a node.
+type Propagation struct {
+	Relay string
+	Kind  PropagationKind
+	// At is when the propagation was first observed.
+	At time.Time
+}
+
+// PropagationTracker records which relays are known to have each node
+// during this session. It is safe for concurrent use.
+type PropagationTracker struct {
+	sync.RWMutex
+	nodes       map[string][]Propagation
+	subscribers []func(*fields.QualifiedHash, Propagation)
+}
+
+// NewPropagationTracker creates an empty PropagationTracker.
+func NewPropagationTracker() *PropagationTracker {
+	return &PropagationTracker{
+		nodes: make(map[string][]Propagation),
+	}
+}
+
+// Record notes that the relay has the node with the given ID. Repeated
+// observations of the same relay and kind are ignored.
+func (p *PropagationTracker) Record(id *fields.QualifiedHash, relay string, kind PropagationKind) {
+	p.Lock()
+	key := id.String()
+	for _, existing := range p.nodes[key] {
+		if existing.Relay == relay && existing.Kind == kind {
+			p.Unlock()
+			return
+		}
+	}
+	propagation := Propagation{
+		Relay: relay,
+		Kind:  kind,
+		At:    time.Now(),
+	}
+	p.nodes[key] = append(p.nodes[key], propagation)
+	subscribers := p.subscribers
+	p.Unlock()
+	for _, handler := range subscribers {
+		handler(id, propagation)
+	}
+}
+
+// Subscribe registers handler to be invoked with each propagation recorded
+// from now on. Like a store subscriber, the handler must not block.
+func (p *PropagationTracker) Subscribe(handler func(id *fields.QualifiedHash, propagation Propagation)) {
+	p.Lock()
+	defer p.Unlock()
+	p.subscribers = append(p.subscribers, handler)
+}
+
+// For returns every known propagation of the node with the given ID, sorted
+// by relay.
+func (p *PropagationTracker) For(id *fields.QualifiedHash) []Propagation {
+	p.RLock()
+	defer p.RUnlock()
+	out := append([]Propagation(nil), p.nodes[id.String()]...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Relay < out[j].Relay
+	})
+	return out
+}
+
+// trackAnnouncements records every node that the relay announces to worker
+// as Received from it, including nodes that are already stored and that the
+// worker therefore ignores.
+func trackAnnouncements(worker *sprout.Worker, relay string, tracker *PropagationTracker) {
+	onAnnounce := worker.Conn.OnAnnounce
+	worker.Conn.OnAnnounce = func(conn *sprout.Conn, messageID sprout.MessageID, nodes []forest.Node) error {
+		for _, node := range nodes {
+			tracker.Record(node.ID(), relay, Received)
+		}
+		return onAnnounce(conn, messageID, nodes)
+	}
+}
+
+// relayStore wraps the store given to the worker for a single relay. Nodes
+// that the worker fetches and stores while synchronizing are recorded as
+// Received from the relay. The worker announces new nodes to the relay as
+// they are stored without reporting the outcome, so relayStore announces
+// them in its place and records those that the relay acknowledges.
+type relayStore struct {
+	store.ExtendedStore
+	relay   string
+	tracker *PropagationTracker
+	// worker is the worker using the store. It must be set before the
+	// worker runs.
+	worker *sprout.Worker
+}
+
+var _ store.ExtendedStore = &relayStore{}
+
+func (r *relayStore) Add(node forest.Node) error {
+	if err := r.ExtendedStore.Add(node); err != nil {
+		return err
+	}
+	r.tracker.Record(node.ID(), r.relay, Received)
+	return nil
+}
+
+func (r *relayStore) AddAs(node forest.Node, id store.Subscription) error {
+	if err := r.ExtendedStore.AddAs(node, id); err != nil {
+		return err
+	}
+	r.tracker.Record(node.ID(), r.relay, Received)
+	return nil
+}
+
+// SubscribeToNewMessages subscribes the relayStore's own announcement of new
+// nodes in place of handler, which is the worker's HandleNewNode.
+func (r *relayStore) SubscribeToNewMessages(handler func(forest.Node)) store.Subscription {
+	if r.worker == nil {
+		return r.ExtendedStore.SubscribeToNewMessages(handler)
+	}
+	return r.ExtendedStore.SubscribeToNewMessages(r.announce)
+}
+
+// announce sends a new node to the relay as the worker's HandleNewNode would,
+// recording it as Acknowledged if the relay accepts it.
+func (r *relayStore) announce(node forest.Node) {
+	if reply, ok := node.(*forest.Reply); ok && !r.worker.IsSubscribed(&reply.CommunityID) {
+		return
+	}
+	go func() {
+		timeout := time.NewTimer(r.worker.DefaultTimeout)
+		err := r.worker.SendAnnounce([]forest.Node{node}, timeout.C)
+		timeout.Stop()
+		if err != nil {
+			log.Printf("failed announcing %s to %s: %v", node.ID(), r.relay, err)
+			return
+		}
+		r.tracker.Record(node.ID(), r.relay, Acknowledged)
+	}()
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DirectProxy is the proxy setting that disables proxying for a relay even
+// when a global proxy is configured.
+const DirectProxy = "direct"
+
+// Dialer establishes network connections. *net.Dialer implements it.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// directDialer connects without a proxy.
+var directDialer Dialer = &net.Dialer{Timeout: dialTimeout}
+
+// ProxyError reports a failure to reach a relay that was caused by the proxy
+// rather than by the relay itself.
+type ProxyError struct {
+	// Proxy is the address of the proxy.
+	Proxy string
+	Err   error
+}
+
+func (p *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %v", p.Proxy, p.Err)
+}
+
+func (p *ProxyError) Unwrap() error {
+	return p.Err
+}
+
+// NewProxyDialer returns a Dialer that connects through the given proxy.
+// Supported proxies are:
+//
+//	socks5://[USER:PASS@]HOST:PORT   a SOCKS5 proxy; hostnames are resolved
+//	                                 locally
+//	socks5h://[USER:PASS@]HOST:PORT  the same, but hostnames are resolved by
+//	                                 the proxy, as Tor requires
+//	http://[USER:PASS@]HOST:PORT     an HTTP proxy supporting CONNECT
+//
+// The empty string and DirectProxy return a Dialer that does not use a
+// proxy.
+func NewProxyDialer(proxy string) (Dialer, error) {
+	if proxy == "" || proxy == DirectProxy {
+		return directDialer, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address %q: %w", proxy, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %q: missing host", proxy)
+	}
+	switch u.Scheme {
+	case "socks5":
+		return &socks5Dialer{proxy: u.Host, auth: u.User, resolveLocally: true}, nil
+	case "socks5h":
+		return &socks5Dialer{proxy: u.Host, auth: u.User}, nil
+	case "http":
+		return &httpConnectDialer{proxy: u.Host, auth: u.User}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q in %s", u.Scheme, proxy)
+	}
+}
+
+// socks5Dialer implements the client side of RFC 1928, with the
+// username/password authentication of RFC 1929.
+type socks5Dialer struct {
+	proxy string
+	auth  *url.Userinfo
+	// resolveLocally sends the proxy the address of the destination rather
+	// than its hostname.
+	resolveLocally bool
+}
+
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthUnacceptable = 0xff
+	socks5CmdConnect       = 0x01
+	socks5AddrIPv4         = 0x01
+	socks5AddrDomain       = 0x03
+	socks5AddrIPv6         = 0x04
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// Dial connects to address through the SOCKS5 proxy. The hostname is sent
+// to the proxy unresolved unless resolveLocally is set.
+func (s *socks5Dialer) Dial(network, address string) (net.Conn, error) {
+	if s.resolveLocally {
+		resolved, err := resolve(address)
+		if err != nil {
+			return nil, err
+		}
+		address = resolved
+	}
+	conn, err := directDialer.Dial("tcp", s.proxy)
+	if err != nil {
+		return nil, &ProxyError{Proxy: s.proxy, Err: err}
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := s.handshake(conn, address); err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: s.proxy, Err: err}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// resolve replaces the hostname in address with one of its IP addresses,
+// preferring IPv4.
+func resolve(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination %q: %w", address, err)
+	}
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("failed resolving %s: %w", host, err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses found for %s", host)
+	}
+	resolved := ips[0]
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			resolved = ip
+			break
+		}
+	}
+	return net.JoinHostPort(resolved.String(), port), nil
+}
+
+// handshake negotiates authentication and requests a connection to address.
+func (s *socks5Dialer) handshake(conn net.Conn, address string) error {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid destination %q: %w", address, err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid destination port %q: %w", portString, err)
+	}
+
+	method := byte(socks5AuthNone)
+	if s.auth != nil {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("failed sending greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed reading greeting: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := s.authenticate(conn); err != nil {
+			return err
+		}
+	case socks5AuthUnacceptable:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unexpected authentication method %d", reply[1])
+	}
+
+	request := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("destination hostname too long: %s", host)
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socks5AddrIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socks5AddrIPv6)
+		request = append(request, ip.To16()...)
+	}
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed sending connect request: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed reading connect reply: %w", err)
+	}
+	if header[1] != 0 {
+		if msg, ok := socks5Replies[header[1]]; ok {
+			return fmt.Errorf("connecting to %s: %s", address, msg)
+		}
+		return fmt.Errorf("connecting to %s: unknown error %d", address, header[1])
+	}
+	// Discard the bound address, which is not needed.
+	var boundLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		boundLen = net.IPv4len
+	case socks5AddrIPv6:
+		boundLen = net.IPv6len
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("failed reading connect reply: %w", err)
+		}
+		boundLen = int(length[0])
+	default:
+		return fmt.Errorf("unexpected address type %d in connect reply", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, boundLen+2)); err != nil {
+		return fmt.Errorf("failed reading connect reply: %w", err)
+	}
+	return nil
+}
+
+// authenticate performs username/password authentication.
+func (s *socks5Dialer) authenticate(conn net.Conn) error {
+	user := s.auth.Username()
+	pass, _ := s.auth.Password()
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("proxy username or password too long")
+	}
+	request := []byte{0x01, byte(len(user))}
+	request = append(request, user...)
+	request = append(request, byte(len(pass)))
+	request = append(request, pass...)
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed sending credentials: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed reading authentication reply: %w", err)
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication rejected")
+	}
+	return nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy using the
+// CONNECT method.
+type httpConnectDialer struct {
+	proxy string
+	auth  *url.Userinfo
+}
+
+// Dial connects to address through the HTTP proxy.
+func (h *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := directDialer.Dial("tcp", h.proxy)
+	if err != nil {
+		return nil, &ProxyError{Proxy: h.proxy, Err: err}
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	tunnel, err := h.connect(conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: h.proxy, Err: err}
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// connect requests a tunnel to address over conn.
+func (h *httpConnectDialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if h.auth != nil {
+		pass, _ := h.auth.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(h.auth.Username() + ":" + pass))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed sending CONNECT request: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CONNECT response: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("connecting to %s: %s", address, response.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads are served from a reader that may
+// already hold data received from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// serveProxy runs handler for each connection accepted by a listener on the
+// loopback interface and returns the listener's address.
+func serveProxy(t *testing.T, handler func(net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echo verifies that conn relays data in both directions through a proxy
+// that echoes it.
+func echo(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed writing through proxy: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("failed reading through proxy: %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("expected echoed %q, got %q", "ping", reply)
+	}
+}
+
+// socks5Request is the destination requested from the fake SOCKS5 proxy.
+type socks5Request struct {
+	user, pass string
+	addrType   byte
+	host       string
+	port       int
+}
+
+// fakeSOCKS5 serves a single SOCKS5 handshake, reports the request, and
+// then echoes. If user is set, it requires username/password authentication.
+func fakeSOCKS5(user, pass string, requests chan<- socks5Request) func(net.Conn) {
+	return func(conn net.Conn) {
+		var request socks5Request
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		methods := make([]byte, greeting[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+		if user == "" {
+			conn.Write([]byte{socks5Version, socks5AuthNone})
+		} else {
+			conn.Write([]byte{socks5Version, socks5AuthPassword})
+			header := make([]byte, 2)
+			io.ReadFull(conn, header)
+			name := make([]byte, header[1])
+			io.ReadFull(conn, name)
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			password := make([]byte, length[0])
+			io.ReadFull(conn, password)
+			request.user, request.pass = string(name), string(password)
+			if request.user != user || request.pass != pass {
+				conn.Write([]byte{0x01, 0x01})
+				requests <- request
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		request.addrType = header[3]
+		switch header[3] {
+		case socks5AddrIPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			request.host = net.IP(ip).String()
+		case socks5AddrIPv6:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(conn, ip)
+			request.host = net.IP(ip).String()
+		case socks5AddrDomain:
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			host := make([]byte, length[0])
+			io.ReadFull(conn, host)
+			request.host = string(host)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		request.port = int(port[0])<<8 | int(port[1])
+		requests <- request
+		conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+		io.Copy(conn, conn)
+	}
+}
+
+func TestSOCKS5Handshake(t *testing.T) {
+	for _, tc := range []struct {
+		name, scheme, user, pass string
+		addrType                 byte
+		host                     string
+	}{
+		{"resolved locally", "socks5", "", "", socks5AddrIPv4, "127.0.0.1"},
+		{"resolved by proxy", "socks5h", "", "", socks5AddrDomain, "localhost"},
+		{"authenticated", "socks5h", "user", "secret", socks5AddrDomain, "localhost"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := make(chan socks5Request, 1)
+			proxy := serveProxy(t, fakeSOCKS5(tc.user, tc.pass, requests))
+			auth := ""
+			if tc.user != "" {
+				auth = tc.user + ":" + tc.pass + "@"
+			}
+			dialer, err := NewProxyDialer(tc.scheme + "://" + auth + proxy)
+			if err != nil {
+				t.Fatalf("failed creating dialer: %v", err)
+			}
+			conn, err := dialer.Dial("tcp", "localhost:7117")
+			if err != nil {
+				t.Fatalf("failed dialing through proxy: %v", err)
+			}
+			request := <-requests
+			if request.addrType != tc.addrType || request.host != tc.host || request.port != 7117 {
+				t.Errorf("expected request for %s:7117 (type %d), got %+v", tc.host, tc.addrType, request)
+			}
+			if request.user != tc.user || request.pass != tc.pass {
+				t.Errorf("expected credentials %s:%s, got %s:%s", tc.user, tc.pass, request.user, request.pass)
+			}
+			echo(t, conn)
+		})
+	}
+}
+
+func TestSOCKS5AuthenticationRejected(t *testing.T) {
+	requests := make(chan socks5Request, 1)
+	proxy := serveProxy(t, fakeSOCKS5("user", "secret", requests))
+	dialer, err := NewProxyDialer("socks5h://user:wrong@" + proxy)
+	if err != nil {
+		t.Fatalf("failed creating dialer: %v", err)
+	}
+	_, err = dialer.Dial("tcp", "localhost:7117")
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) {
+		t.Fatalf("expected a proxy error, got %v", err)
+	}
+}
+
+// fakeHTTPProxy serves a single CONNECT request, replying with status and
+// then echoing if the status is 200.
+func fakeHTTPProxy(status int, requests chan<- *http.Request) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		requests <- request
+		conn.Write([]byte("HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n\r\n"))
+		if status != http.StatusOK {
+			return
+		}
+		// The client may have written data behind the request.
+		io.Copy(conn, io.MultiReader(reader, conn))
+	}
+}
+
+func TestHTTPConnectHandshake(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	proxy := serveProxy(t, fakeHTTPProxy(http.StatusOK, requests))
+	dialer, err := NewProxyDialer("http://user:secret@" + proxy)
+	if err != nil {
+		t.Fatalf("failed creating dialer: %v", err)
+	}
+	conn, err := dialer.Dial("tcp", "relay.example.com:7117")
+	if err != nil {
+		t.Fatalf("failed dialing through proxy: %v", err)
+	}
+	request := <-requests
+	if request.Method != http.MethodConnect || request.Host != "relay.example.com:7117" {
+		t.Errorf("expected CONNECT relay.example.com:7117, got %s %s", request.Method, request.Host)
+	}
+	credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if auth := request.Header.Get("Proxy-Authorization"); auth != credentials {
+		t.Errorf("expected Proxy-Authorization %q, got %q", credentials, auth)
+	}
+	echo(t, conn)
+}
+
+func TestHTTPConnectRefused(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	proxy := serveProxy(t, fakeHTTPProxy(http.StatusForbidden, requests))
+	dialer, err := NewProxyDialer("http://" + proxy)
+	if err != nil {
+		t.Fatalf("failed creating dialer: %v", err)
+	}
+	_, err = dialer.Dial("tcp", "relay.example.com:7117")
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) {
+		t.Fatalf("expected a proxy error, got %v", err)
+	}
+}
+
+func TestNewProxyDialer(t *testing.T) {
+	for _, proxy := range []string{"", DirectProxy} {
+		if dialer, err := NewProxyDialer(proxy); err != nil || dialer != directDialer {
+			t.Errorf("expected %q to connect directly, got %v, %v", proxy, dialer, err)
+		}
+	}
+	for _, proxy := range []string{"ftp://proxy:21", "socks5://", "://"} {
+		if _, err := NewProxyDialer(proxy); err == nil {
+			t.Errorf("expected %q to be rejected", proxy)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+// RetentionRule selects how a RetentionPolicy limits stored messages.
+type RetentionRule string
+
+const (
+	// KeepEverything never prunes messages.
+	KeepEverything RetentionRule = ""
+	// KeepDays prunes messages older than the policy's number of days.
+	KeepDays RetentionRule = "days"
+	// KeepLatest prunes all but the policy's number of newest messages in
+	// each conversation.
+	KeepLatest RetentionRule = "latest"
+)
+
+// RetentionPolicy limits how many messages of a community are kept. The
+// local user's own messages are always kept, as are the messages needed to
+// reach them from the community.
+type RetentionPolicy struct {
+	Rule RetentionRule
+	// Amount is the number of days or of messages per conversation to
+	// keep, depending upon the rule.
+	Amount int `json:",omitempty"`
+}
+
+// Active reports whether the policy prunes anything.
+func (p RetentionPolicy) Active() bool {
+	return p.Rule != KeepEverything && p.Amount > 0
+}
+
+func (p RetentionPolicy) String() string {
+	switch {
+	case !p.Active():
+		return "Keep everything"
+	case p.Rule == KeepDays:
+		return fmt.Sprintf("Keep %d days", p.Amount)
+	case p.Rule == KeepLatest:
+		return fmt.Sprintf("Keep latest %d per conversation", p.Amount)
+	default:
+		return string(p.Rule)
+	}
+}
+
+// PruneResult summarizes the messages removed by retention policies.
+type PruneResult struct {
+	// Removed is the number of messages removed.
+	Removed int
+	// Reclaimed estimates the space freed in bytes, as the serialized size
+	// of the removed messages. The space freed on disk depends upon the
+	// store backend.
+	Reclaimed int64
+}
+
+func (r PruneResult) String() string {
+	return fmt.Sprintf("removed %d messages, about %s of message data", r.Removed, FormatBytes(r.Reclaimed))
+}
+
+func (r *PruneResult) add(other PruneResult) {
+	r.Removed += other.Removed
+	r.Reclaimed += other.Reclaimed
+}
+
+// FormatBytes describes a size in bytes using binary units.
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Prune applies the retention policy of every community to the store and
+// reports the space reclaimed to the user.
+func (a *arborService) Prune(ctx context.Context) PruneResult {
+	logger := log.New(log.Writer(), "prune ", log.Flags())
+	var total PruneResult
+	if a.StoreReadOnly() {
+		return total
+	}
+	communities, err := a.grove.Recent(fields.NodeTypeCommunity, 1024)
+	if err != nil {
+		logger.Printf("failed looking up communities: %v", err)
+		return total
+	}
+	own := make(map[string]struct{})
+	for _, id := range a.SettingsService.LocalIdentityIDs() {
+		own[id.String()] = struct{}{}
+	}
+	now := time.Now()
+	for _, community := range communities {
+		if ctx.Err() != nil {
+			break
+		}
+		policy := a.SettingsService.RetentionPolicy(community.ID().String())
+		if !policy.Active() {
+			continue
+		}
+		result, err := pruneCommunity(ctx, a.grove, community, policy, own, now)
+		if err != nil {
+			logger.Printf("failed pruning community %v: %v", community.ID(), err)
+		}
+		total.add(result)
+	}
+	a.recordPrune(total)
+	if total.Removed > 0 {
+		logger.Print(total)
+		banner := &MessageBanner{
+			Priority: Info,
+			Text:     "Retention policies " + total.String() + ".",
+		}
+		a.BannerService.Add(banner)
+		time.AfterFunc(10*time.Second, banner.Cancel)
+	}
+	return total
+}
+
+// pruneCommunity removes the messages within community that the policy no
+// longer keeps. A message is only removed along with all of its replies, so
+// a message is kept whenever any reply to it is kept. Messages authored by
+// the identities in own are always kept.
+func pruneCommunity(ctx context.Context, s store.ExtendedStore, community forest.Node, policy RetentionPolicy, own map[string]struct{}, now time.Time) (PruneResult, error) {
+	var (
+		result  PruneResult
+		replies []*forest.Reply
+	)
+	if err := store.WalkNodes(s, community, func(node forest.Node) error {
+		if reply, ok := node.(*forest.Reply); ok {
+			replies = append(replies, reply)
+		}
+		return ctx.Err()
+	}); err != nil {
+		return result, fmt.Errorf("failed walking community: %w", err)
+	}
+
+	expired := make(map[string]bool, len(replies))
+	switch policy.Rule {
+	case KeepDays:
+		cutoff := now.AddDate(0, 0, -policy.Amount)
+		for _, reply := range replies {
+			expired[reply.ID().String()] = reply.CreatedAt().Before(cutoff)
+		}
+	case KeepLatest:
+		conversations := make(map[string][]*forest.Reply)
+		for _, reply := range replies {
+			conversation := reply.ConversationID.String()
+			if reply.ConversationID.Equals(fields.NullHash()) {
+				conversation = reply.ID().String()
+			}
+			conversations[conversation] = append(conversations[conversation], reply)
+		}
+		for _, conversation := range conversations {
+			sort.Slice(conversation, func(i, j int) bool {
+				return conversation[i].CreatedAt().After(conversation[j].CreatedAt())
+			})
+			for i, reply := range conversation {
+				expired[reply.ID().String()] = i >= policy.Amount
+			}
+		}
+	}
+
+	children := make(map[string][]*forest.Reply)
+	for _, reply := range replies {
+		parent := reply.ParentID().String()
+		children[parent] = append(children[parent], reply)
+	}
+	removable := make(map[string]bool, len(replies))
+	var isRemovable func(reply *forest.Reply) bool
+	isRemovable = func(reply *forest.Reply) bool {
+		id := reply.ID().String()
+		if answer, ok := removable[id]; ok {
+			return answer
+		}
+		_, mine := own[reply.AuthorID().String()]
+		answer := expired[id] && !mine
+		for _, child := range children[id] {
+			// Visit every child so that the whole subtree is memoized.
+			if !isRemovable(child) {
+				answer = false
+			}
+		}
+		removable[id] = answer
+		return answer
+	}
+	size := func(reply *forest.Reply) int64 {
+		data, err := reply.MarshalBinary()
+		if err != nil {
+			return 0
+		}
+		return int64(len(data))
+	}
+	var subtreeSize func(reply *forest.Reply) (int, int64)
+	subtreeSize = func(reply *forest.Reply) (int, int64) {
+		count, bytes := 1, size(reply)
+		for _, child := range children[reply.ID().String()] {
+			c, b := subtreeSize(child)
+			count += c
+			bytes += b
+		}
+		return count, bytes
+	}
+	for _, reply := range replies {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if !isRemovable(reply) || removable[reply.ParentID().String()] {
+			// Kept, or removed along with its parent.
+			continue
+		}
+		count, bytes := subtreeSize(reply)
+		if err := s.RemoveSubtree(reply.ID()); err != nil {
+			return result, fmt.Errorf("failed removing subtree rooted at %v: %w", reply.ID(), err)
+		}
+		result.Removed += count
+		result.Reclaimed += bytes
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+// addTestNodes adds the nodes to s, failing the test on error.
+func addTestNodes(t *testing.T, s forest.Store, nodes ...forest.Node) {
+	t.Helper()
+	for _, node := range nodes {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("adding %s: %v", node.ID(), err)
+		}
+	}
+}
+
+// newTestReply writes a reply with the given content to parent at the given
+// time.
+func newTestReply(t *testing.T, builder *forest.Builder, parent interface{}, content string, at time.Time) *forest.Reply {
+	t.Helper()
+	builder.Timer = func() time.Time { return at }
+	reply, err := builder.NewReply(parent, content, []byte{})
+	if err != nil {
+		t.Fatalf("creating reply: %v", err)
+	}
+	return reply
+}
+
+func nodeSize(t *testing.T, nodes ...forest.Node) int64 {
+	t.Helper()
+	var size int64
+	for _, node := range nodes {
+		data, err := node.MarshalBinary()
+		if err != nil {
+			t.Fatalf("serializing %s: %v", node.ID(), err)
+		}
+		size += int64(len(data))
+	}
+	return size
+}
+
+func checkPresent(t *testing.T, s forest.Store, kept, removed []forest.Node) {
+	t.Helper()
+	for _, node := range kept {
+		if _, present, _ := s.Get(node.ID()); !present {
+			t.Errorf("node %s was removed, expected it to be kept", node.ID())
+		}
+	}
+	for _, node := range removed {
+		if _, present, _ := s.Get(node.ID()); present {
+			t.Errorf("node %s was kept, expected it to be removed", node.ID())
+		}
+	}
+}
+
+func TestPruneCommunityDays(t *testing.T) {
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	mine, own := newTestAuthor(t, "mine")
+	other, builder := newTestAuthor(t, "other")
+	community, err := builder.NewCommunity("community", []byte{})
+	if err != nil {
+		t.Fatalf("creating community: %v", err)
+	}
+	expired := newTestReply(t, builder, community, "expired", old)
+	expiredReply := newTestReply(t, builder, expired, "expiredReply", old)
+	answered := newTestReply(t, builder, community, "answered", old)
+	answer := newTestReply(t, own, answered, "answer", old)
+	recent := newTestReply(t, builder, community, "recent", now)
+
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, mine, other, community, expired, expiredReply, answered, answer, recent)
+	result, err := pruneCommunity(context.Background(), s, community, RetentionPolicy{Rule: KeepDays, Amount: 5}, map[string]struct{}{mine.ID().String(): {}}, now)
+	if err != nil {
+		t.Fatalf("pruning: %v", err)
+	}
+	if result.Removed != 2 {
+		t.Errorf("removed %d messages, expected 2", result.Removed)
+	}
+	if size := nodeSize(t, expired, expiredReply); result.Reclaimed != size {
+		t.Errorf("reclaimed %d bytes, expected %d", result.Reclaimed, size)
+	}
+	checkPresent(t, s, []forest.Node{community, answered, answer, recent}, []forest.Node{expired, expiredReply})
+}
+
+func TestPruneCommunityLatest(t *testing.T) {
+	now := time.Now()
+	other, builder := newTestAuthor(t, "other")
+	community, err := builder.NewCommunity("community", []byte{})
+	if err != nil {
+		t.Fatalf("creating community: %v", err)
+	}
+	conversation := newTestReply(t, builder, community, "conversation", now.Add(-3*time.Hour))
+	earlier := newTestReply(t, builder, conversation, "earlier", now.Add(-2*time.Hour))
+	latest := newTestReply(t, builder, conversation, "latest", now.Add(-time.Hour))
+
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, other, community, conversation, earlier, latest)
+	result, err := pruneCommunity(context.Background(), s, community, RetentionPolicy{Rule: KeepLatest, Amount: 1}, nil, now)
+	if err != nil {
+		t.Fatalf("pruning: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("removed %d messages, expected 1", result.Removed)
+	}
+	// The conversation is kept because its latest reply is.
+	checkPresent(t, s, []forest.Node{community, conversation, latest}, []forest.Node{earlier})
+}
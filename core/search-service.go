@@ -0,0 +1,200 @@
+package core
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"git.sr.ht/~athorp96/forest-ex/expiration"
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+// SearchService finds stored replies by their content.
+type SearchService interface {
+	// Search returns up to limit replies, newest first, whose content
+	// contains every word of the query. The final word of the query also
+	// matches words that it is a prefix of.
+	Search(query string, limit int) []ds.ReplyData
+	// Ready reports whether the index has finished loading the replies
+	// that were stored before launch.
+	Ready() bool
+}
+
+// searchIndexLimit bounds the number of stored replies indexed at launch.
+const searchIndexLimit = 1 << 16
+
+type searchService struct {
+	ArborService
+	Invalidator
+
+	sync.RWMutex
+	ready bool
+	// replies holds the indexed replies by ID.
+	replies map[string]ds.ReplyData
+	// words maps each lowercase word to the IDs of the replies containing
+	// it.
+	words map[string]map[string]struct{}
+}
+
+var _ SearchService = &searchService{}
+
+func newSearchService(tasks *taskGroup, arbor ArborService, invalidator Invalidator) (SearchService, error) {
+	s := &searchService{
+		ArborService: arbor,
+		Invalidator:  invalidator,
+		replies:      make(map[string]ds.ReplyData),
+		words:        make(map[string]map[string]struct{}),
+	}
+	index := func(node forest.Node) {
+		tasks.Go(func(context.Context) {
+			s.index(node)
+		})
+	}
+	tasks.Subscribe(arbor.Store(), index)
+	tasks.SubscribeToReplays(arbor.Store(), index)
+	tasks.SubscribeToRemovals(arbor.Store(), func(ids []*fields.QualifiedHash) {
+		tasks.Go(func(context.Context) {
+			s.remove(ids)
+		})
+	})
+	tasks.Go(s.load)
+	return s, nil
+}
+
+// load indexes the replies that were already stored at launch.
+func (s *searchService) load(ctx context.Context) {
+	nodes, err := s.ArborService.Store().Recent(fields.NodeTypeReply, searchIndexLimit)
+	if err != nil {
+		log.Printf("search: failed loading some stored replies: %v", err)
+	}
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return
+		}
+		s.index(node)
+	}
+	s.Lock()
+	s.ready = true
+	s.Unlock()
+	s.Invalidator.Invalidate()
+}
+
+// index adds the node to the index if it is a visible reply.
+func (s *searchService) index(node forest.Node) {
+	var rd ds.ReplyData
+	if !rd.Populate(node, s.ArborService.Store()) {
+		return
+	}
+	if expired, err := expiration.IsExpiredTwig(rd.Metadata); err != nil || expired {
+		return
+	}
+	id := rd.ID.String()
+	s.Lock()
+	defer s.Unlock()
+	if _, indexed := s.replies[id]; indexed {
+		return
+	}
+	s.replies[id] = rd
+	for _, word := range searchWords(rd.Content) {
+		ids, ok := s.words[word]
+		if !ok {
+			ids = make(map[string]struct{})
+			s.words[word] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// remove drops the replies with the given IDs from the index.
+func (s *searchService) remove(ids []*fields.QualifiedHash) {
+	s.Lock()
+	defer s.Unlock()
+	for _, id := range ids {
+		key := id.String()
+		rd, indexed := s.replies[key]
+		if !indexed {
+			continue
+		}
+		delete(s.replies, key)
+		for _, word := range searchWords(rd.Content) {
+			delete(s.words[word], key)
+			if len(s.words[word]) == 0 {
+				delete(s.words, word)
+			}
+		}
+	}
+}
+
+// searchWords splits text into its distinct lowercase words.
+func searchWords(text string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]struct{}, len(tokens))
+	words := tokens[:0]
+	for _, word := range tokens {
+		if _, dup := seen[word]; dup {
+			continue
+		}
+		seen[word] = struct{}{}
+		words = append(words, word)
+	}
+	return words
+}
+
+func (s *searchService) Search(query string, limit int) []ds.ReplyData {
+	terms := searchWords(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	s.RLock()
+	defer s.RUnlock()
+	var matches map[string]struct{}
+	for i, term := range terms {
+		termMatches := make(map[string]struct{})
+		if i == len(terms)-1 {
+			for word, ids := range s.words {
+				if strings.HasPrefix(word, term) {
+					for id := range ids {
+						termMatches[id] = struct{}{}
+					}
+				}
+			}
+		} else {
+			for id := range s.words[term] {
+				termMatches[id] = struct{}{}
+			}
+		}
+		if matches == nil {
+			matches = termMatches
+			continue
+		}
+		for id := range matches {
+			if _, ok := termMatches[id]; !ok {
+				delete(matches, id)
+			}
+		}
+	}
+	results := make([]ds.ReplyData, 0, len(matches))
+	for id := range matches {
+		results = append(results, s.replies[id])
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (s *searchService) Ready() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.ready
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+// newTestSearch indexes replies with the given contents, written an hour
+// apart from the oldest to the newest. It returns the service and the
+// replies.
+func newTestSearch(t *testing.T, contents ...string) (*searchService, []*forest.Reply) {
+	t.Helper()
+	identity, builder := newTestAuthor(t, "test")
+	community, err := builder.NewCommunity("community", []byte{})
+	if err != nil {
+		t.Fatalf("creating community: %v", err)
+	}
+	s := newSwappableStore(store.NewMemoryStore())
+	addTestNodes(t, s, identity, community)
+	search := &searchService{
+		ArborService: ancestryArbor{store: s},
+		replies:      make(map[string]ds.ReplyData),
+		words:        make(map[string]map[string]struct{}),
+	}
+	start := time.Now().Add(-time.Duration(len(contents)) * time.Hour)
+	replies := make([]*forest.Reply, len(contents))
+	for i, content := range contents {
+		replies[i] = newTestReply(t, builder, community, content, start.Add(time.Duration(i)*time.Hour))
+		addTestNodes(t, s, replies[i])
+		search.index(replies[i])
+	}
+	return search, replies
+}
+
+// checkSearch compares the results of the query with the expected replies.
+func checkSearch(t *testing.T, search *searchService, query string, limit int, expected ...*forest.Reply) {
+	t.Helper()
+	var got, want []string
+	for _, result := range search.Search(query, limit) {
+		got = append(got, result.ID.String())
+	}
+	for _, reply := range expected {
+		want = append(want, reply.ID().String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("search for %q found %v, expected %v", query, got, want)
+	}
+}
+
+func TestSearchMatchesEveryTerm(t *testing.T) {
+	search, replies := newTestSearch(t, "Apple banana", "apple, cherry", "banana cherry")
+	checkSearch(t, search, "apple banana", 0, replies[0])
+	checkSearch(t, search, "CHERRY apple", 0, replies[1])
+	checkSearch(t, search, "apple durian", 0)
+	checkSearch(t, search, "", 0)
+}
+
+func TestSearchPrefixMatchesLastTerm(t *testing.T) {
+	search, replies := newTestSearch(t, "apple banana", "applesauce", "banana cherry")
+	checkSearch(t, search, "app", 0, replies[1], replies[0])
+	checkSearch(t, search, "banana app", 0, replies[0])
+	checkSearch(t, search, "ban apple", 0)
+}
+
+func TestSearchRemove(t *testing.T) {
+	search, replies := newTestSearch(t, "apple banana", "apple cherry")
+	search.remove([]*fields.QualifiedHash{replies[0].ID()})
+	checkSearch(t, search, "apple", 0, replies[1])
+	checkSearch(t, search, "banana", 0)
+	if _, ok := search.words["banana"]; ok {
+		t.Errorf("removed reply's words are still indexed")
+	}
+}
+
+func TestSearchOrdersNewestFirst(t *testing.T) {
+	search, replies := newTestSearch(t, "apple one", "apple two", "apple three")
+	checkSearch(t, search, "apple", 0, replies[2], replies[1], replies[0])
+	checkSearch(t, search, "apple", 2, replies[2], replies[1])
+}
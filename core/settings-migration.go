@@ -0,0 +1,65 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// settingsMigrations upgrade the settings file format. The migration at
+// index i upgrades settings of version i to version i+1, so the current
+// version is the number of migrations.
+var settingsMigrations = []func(*Settings) error{
+	// Version 1 replaces the single relay Address with the Addresses list.
+	func(settings *Settings) error {
+		if settings.Address == "" {
+			return nil
+		}
+		for _, existing := range settings.Addresses {
+			if existing == settings.Address {
+				settings.Address = ""
+				return nil
+			}
+		}
+		settings.Addresses = append(append([]string(nil), settings.Addresses...), settings.Address)
+		settings.Address = ""
+		return nil
+	},
+}
+
+// settingsVersion is the version of the settings file format written by
+// Persist.
+var settingsVersion = len(settingsMigrations)
+
+// migrateSettings upgrades the settings to the current version. If a
+// migration fails, the settings are left at the last version that migrated
+// successfully so that they remain usable, and the failing migration is
+// retried on the next load.
+func migrateSettings(settings *Settings) error {
+	for settings.Version < settingsVersion {
+		// Migrate a copy so that a failed migration leaves no partial
+		// changes behind. Maps and slices are shared with the copy, so
+		// migrations must replace them rather than modify them in place.
+		migrated := *settings
+		if err := settingsMigrations[settings.Version](&migrated); err != nil {
+			return fmt.Errorf("failed migrating settings from version %d: %w", settings.Version, err)
+		}
+		migrated.Version++
+		*settings = migrated
+	}
+	return nil
+}
+
+// readSettingsFile reads and decodes the settings file at path without
+// migrating it.
+func readSettingsFile(path string) (Settings, error) {
+	var settings Settings
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return settings, fmt.Errorf("failed to load settings: %w", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("couldn't parse json settings: %w", err)
+	}
+	return settings, nil
+}
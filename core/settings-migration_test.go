@@ -0,0 +1,81 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMigrateSettings(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		settings Settings
+		expected []string
+	}{
+		{"moves address", Settings{Address: "a:7117"}, []string{"a:7117"}},
+		{"appends address", Settings{Address: "b:7117", Addresses: []string{"a:7117"}}, []string{"a:7117", "b:7117"}},
+		{"deduplicates address", Settings{Address: "a:7117", Addresses: []string{"a:7117"}}, []string{"a:7117"}},
+		{"without address", Settings{}, nil},
+		{"current version", Settings{Version: settingsVersion, Addresses: []string{"a:7117"}}, []string{"a:7117"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			settings := c.settings
+			if err := migrateSettings(&settings); err != nil {
+				t.Fatalf("migrating: %v", err)
+			}
+			if settings.Version != settingsVersion {
+				t.Errorf("version %d, expected %d", settings.Version, settingsVersion)
+			}
+			if settings.Address != "" {
+				t.Errorf("address %q was kept", settings.Address)
+			}
+			if !reflect.DeepEqual(settings.Addresses, c.expected) {
+				t.Errorf("addresses %v, expected %v", settings.Addresses, c.expected)
+			}
+		})
+	}
+}
+
+func TestMigrateSettingsKeepsOriginal(t *testing.T) {
+	addresses := []string{"a:7117"}
+	settings := Settings{Address: "b:7117", Addresses: addresses}
+	if err := migrateSettings(&settings); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	if !reflect.DeepEqual(addresses, []string{"a:7117"}) {
+		t.Errorf("migration modified the original addresses: %v", addresses)
+	}
+}
+
+func TestPersistConcurrently(t *testing.T) {
+	dir := newTestDataDir(t)
+	service, err := newSettingsService(dir)
+	if err != nil {
+		t.Fatalf("creating settings: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				service.SetDarkMode(j%2 == 0)
+				service.SetStoreBackend(backendFor(i%2 == 0, false))
+				service.AddAddress("a:7117")
+				if err := service.Persist(); err != nil {
+					t.Errorf("persisting: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	settings, err := readSettingsFile(filepath.Join(dir, "settings.json"))
+	if err != nil {
+		t.Fatalf("reading settings: %v", err)
+	}
+	if !reflect.DeepEqual(settings.Addresses, []string{"a:7117"}) {
+		t.Errorf("addresses %v, expected [a:7117]", settings.Addresses)
+	}
+}
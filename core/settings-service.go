@@ -3,14 +3,17 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"git.sr.ht/~whereswaldon/forest-go"
 	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/sprig/ds"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/packet"
 )
@@ -24,8 +27,19 @@ type SettingsService interface {
 	AddSubscription(id string)
 	RemoveSubscription(id string)
 	Subscriptions() []string
-	Address() string
-	SetAddress(string)
+	Addresses() []string
+	AddAddress(string)
+	RemoveAddress(string)
+	// Proxy returns the proxy used for relays without a proxy of their own.
+	Proxy() string
+	SetProxy(string)
+	// RelayProxy returns the proxy configured for a specific relay address,
+	// if any.
+	RelayProxy(address string) string
+	SetRelayProxy(address, proxy string)
+	// ProxyFor returns the proxy that should be used to reach the given
+	// relay address, or the empty string for a direct connection.
+	ProxyFor(address string) string
 	BottomAppBar() bool
 	SetBottomAppBar(bool)
 	DockNavDrawer() bool
@@ -35,16 +49,114 @@ type SettingsService interface {
 	ActiveArborIdentityID() *fields.QualifiedHash
 	Identity() (*forest.Identity, error)
 	DataPath() string
+	// ArchivesDir returns the directory that exported archives are written
+	// to.
+	ArchivesDir() string
+	// QuarantineDir returns the directory that corrupt node files are moved
+	// to by the integrity checker.
+	QuarantineDir() string
 	Persist() error
 	CreateIdentity(name string) error
 	Builder() (*forest.Builder, error)
 	UseOrchardStore() bool
 	SetUseOrchardStore(bool)
+	// EncryptStore reports whether the user wants the node store encrypted
+	// with a passphrase.
+	EncryptStore() bool
+	SetEncryptStore(bool)
+	// StoreBackend returns the backend that currently holds the node data.
+	// It differs from the one requested by UseOrchardStore until a
+	// migration completes.
+	StoreBackend() StoreBackend
+	// SetStoreBackend records that the node data now lives in the given
+	// backend and that the previous backend may be removed.
+	SetStoreBackend(StoreBackend)
+	// RetiredStoreBackend returns the backend left behind by the last
+	// completed migration, if any.
+	RetiredStoreBackend() StoreBackend
+	ClearRetiredStoreBackend()
+	// RetentionPolicy returns the policy limiting how many messages of the
+	// community with the given ID are kept.
+	RetentionPolicy(communityID string) RetentionPolicy
+	SetRetentionPolicy(communityID string, policy RetentionPolicy)
+	// LocalIdentityIDs returns the IDs of the identities whose private keys
+	// are held locally, along with the identities that they replaced.
+	LocalIdentityIDs() []*fields.QualifiedHash
+	// ActiveIdentityIDs returns the ID of the active identity followed by
+	// the IDs of the identities that it replaced, or nil if there is no
+	// active identity.
+	ActiveIdentityIDs() []*fields.QualifiedHash
+	// Identities returns every identity in the identities directory.
+	Identities() ([]LocalIdentity, error)
+	// SetActiveIdentity makes the identity with the given ID the one used
+	// to author messages.
+	SetActiveIdentity(id *fields.QualifiedHash) error
+	// RenameIdentity sets the local label of an identity. The name
+	// published in the identity cannot be changed.
+	RenameIdentity(id *fields.QualifiedHash, label string) error
+	// DeleteIdentity removes an identity and its private key. If it was
+	// active, another identity becomes active.
+	DeleteIdentity(id *fields.QualifiedHash) error
+	// ExportIdentity writes the identity with the given ID and its private
+	// key to w as an IdentityExport. The current passphrase decrypts the
+	// key if it is protected, and the key is encrypted with the passphrase
+	// in the export unless it is empty.
+	ExportIdentity(id *fields.QualifiedHash, current, passphrase string, w io.Writer) error
+	// ImportIdentity reads an IdentityExport from r and adds its identity
+	// to the local identities once its private key has been verified to
+	// belong to it. The passphrase decrypts a key exported with one.
+	ImportIdentity(r io.Reader, passphrase string) (*forest.Identity, error)
+	// SetProfile publishes the profile in the twig metadata of the active
+	// identity. As identity nodes cannot change, this replaces the active
+	// identity with a new one that has the same name and private key, which
+	// is returned.
+	SetProfile(profile ds.Profile) (*forest.Identity, error)
+	// KeyProtected reports whether the private key of the active identity
+	// is encrypted with a passphrase.
+	KeyProtected() bool
+	// KeyLocked reports whether the private key of the active identity is
+	// protected and needs its passphrase before messages can be signed.
+	// While it is locked, Builder returns ErrKeyLocked.
+	KeyLocked() bool
+	// UnlockKey decrypts the private key of the active identity for the
+	// rest of the session.
+	UnlockKey(passphrase string) error
+	// LockKey forgets the decrypted private key of the active identity if
+	// it is protected.
+	LockKey()
+	// OnKeyLocked registers handler to be invoked in its own goroutine each
+	// time a protected private key is locked, so that anything holding a
+	// Builder can let go of it.
+	OnKeyLocked(handler func())
+	// SetKeyPassphrase changes the passphrase protecting the private key of
+	// the active identity. The current passphrase is ignored if the key is
+	// not protected, and an empty passphrase removes the protection.
+	SetKeyPassphrase(current, passphrase string) error
+	// KeyTimeout returns how long a protected private key may go unused
+	// before it is locked again. Zero keeps it unlocked until exit.
+	KeyTimeout() time.Duration
+	SetKeyTimeout(time.Duration)
 }
 
 type Settings struct {
-	// relay address to connect to
-	Address string
+	// version of the settings file format, which is upgraded by
+	// settingsMigrations when the settings are loaded.
+	Version int
+
+	// relay addresses to connect to
+	Addresses []string
+
+	// single relay address used by older versions of sprig. It is migrated
+	// into Addresses by version 1 of the settings file format.
+	Address string `json:",omitempty"`
+
+	// proxy used to reach relays, such as "socks5://127.0.0.1:9050". Empty
+	// for direct connections.
+	Proxy string
+
+	// per-relay proxies that take precedence over Proxy. The value "direct"
+	// bypasses the global proxy.
+	RelayProxies map[string]string `json:",omitempty"`
 
 	// user's local identity ID
 	ActiveIdentity *fields.QualifiedHash
@@ -71,16 +183,56 @@ type Settings struct {
 	// Will become default in future release.
 	OrchardStore bool
 
+	// whether the user wants the node store encrypted with a passphrase.
+	EncryptStore bool `json:",omitempty"`
+
+	// the backend currently holding the node data. Empty for settings
+	// written before migrations were supported, in which case the data
+	// lives in the backend selected by OrchardStore.
+	StoreBackend StoreBackend `json:",omitempty"`
+
+	// a backend whose data was migrated away and can be removed.
+	RetiredStoreBackend StoreBackend `json:",omitempty"`
+
 	Subscriptions []string
+
+	// retention policies by community ID. Communities without a policy
+	// keep every message.
+	Retention map[string]RetentionPolicy `json:",omitempty"`
+
+	// local labels for identities by identity ID.
+	IdentityLabels map[string]string `json:",omitempty"`
+
+	// the IDs of the identities replaced by profile changes, by the ID of
+	// the identity that replaced them. Messages written by them are still
+	// the local user's own.
+	PreviousIdentities map[string][]*fields.QualifiedHash `json:",omitempty"`
+
+	// minutes that a protected private key may go unused before it is
+	// locked again. Zero keeps it unlocked until exit.
+	KeyTimeoutMinutes int `json:",omitempty"`
 }
 
 type settingsService struct {
-	subscriptionLock sync.Mutex
+	// lock guards the settings other than those of identities, which
+	// identityLock guards. Background tasks such as store migrations
+	// update them concurrently with the UI.
+	lock sync.RWMutex
+	// persistLock serializes writes of the settings file.
+	persistLock sync.Mutex
 	Settings
 	dataDir string
+	// identityLock guards the active identity and the state below.
+	identityLock sync.Mutex
 	// state used for authoring messages
 	activePrivKey *openpgp.Entity
 	activeIdCache *forest.Identity
+	// keyProtected is set when activePrivKey was decrypted with a
+	// passphrase, and keyTimer locks it again after the session timeout.
+	keyProtected bool
+	keyTimer     *time.Timer
+	// keyLockHandlers are invoked when a protected private key is locked.
+	keyLockHandlers []func()
 }
 
 var _ SettingsService = &settingsService{}
@@ -91,25 +243,41 @@ func newSettingsService(stateDir string) (SettingsService, error) {
 	}
 	if err := s.Load(); err != nil {
 		log.Printf("no loadable settings file found; defaults will be used: %v", err)
+		s.Settings.Version = settingsVersion
 	}
 	s.DiscoverIdentities()
 	return s, nil
 }
 
 func (s *settingsService) Load() error {
-	jsonSettings, err := ioutil.ReadFile(s.SettingsFile())
+	settings, err := readSettingsFile(s.SettingsFile())
 	if err != nil {
-		return fmt.Errorf("failed to load settings: %w", err)
+		// The settings file may have been damaged outside of Persist, so
+		// fall back to the copy made before it was last written.
+		backup, backupErr := readSettingsFile(s.SettingsBackupFile())
+		if backupErr != nil {
+			return err
+		}
+		log.Printf("using backup settings file: %v", err)
+		settings = backup
 	}
-	if err = json.Unmarshal(jsonSettings, &s.Settings); err != nil {
-		return fmt.Errorf("couldn't parse json settings: %w", err)
+	if settings.Version > settingsVersion {
+		log.Printf("settings file version %d is newer than supported version %d; unknown settings will be lost", settings.Version, settingsVersion)
 	}
+	if err := migrateSettings(&settings); err != nil {
+		log.Printf("using settings of version %d: %v", settings.Version, err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.Settings = settings
 	return nil
 }
 
 func (s *settingsService) AddSubscription(id string) {
-	s.subscriptionLock.Lock()
-	defer s.subscriptionLock.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	found := false
 	for _, comm := range s.Settings.Subscriptions {
 		if comm == id {
@@ -123,8 +291,8 @@ func (s *settingsService) AddSubscription(id string) {
 }
 
 func (s *settingsService) RemoveSubscription(id string) {
-	s.subscriptionLock.Lock()
-	defer s.subscriptionLock.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	length := len(s.Settings.Subscriptions)
 	for i, comm := range s.Settings.Subscriptions {
 		if comm == id {
@@ -135,47 +303,130 @@ func (s *settingsService) RemoveSubscription(id string) {
 }
 
 func (s *settingsService) Subscriptions() []string {
-	s.subscriptionLock.Lock()
-	defer s.subscriptionLock.Unlock()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	var out []string
 	out = append(out, s.Settings.Subscriptions...)
 	return out
 }
 
 func (s *settingsService) DockNavDrawer() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.DockNavDrawer
 }
 
 func (s *settingsService) SetDockNavDrawer(shouldDock bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.DockNavDrawer = shouldDock
 }
 
 func (s *settingsService) AcknowledgedNoticeVersion() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.AcknowledgedNoticeVersion
 }
 
 func (s *settingsService) SetAcknowledgedNoticeVersion(version int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.AcknowledgedNoticeVersion = version
 }
 
 func (s *settingsService) NotificationsGloballyAllowed() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.NotificationsEnabled == nil || *s.Settings.NotificationsEnabled
 }
 
 func (s *settingsService) SetNotificationsGloballyAllowed(allowed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.NotificationsEnabled = &allowed
 }
 
 func (s *settingsService) ActiveArborIdentityID() *fields.QualifiedHash {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
 	return s.Settings.ActiveIdentity
 }
 
-func (s *settingsService) Address() string {
-	return s.Settings.Address
+func (s *settingsService) Addresses() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var out []string
+	out = append(out, s.Settings.Addresses...)
+	return out
+}
+
+func (s *settingsService) AddAddress(addr string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, existing := range s.Settings.Addresses {
+		if existing == addr {
+			return
+		}
+	}
+	s.Settings.Addresses = append(s.Settings.Addresses, addr)
+}
+
+func (s *settingsService) RemoveAddress(addr string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, existing := range s.Settings.Addresses {
+		if existing == addr {
+			s.Settings.Addresses = append(s.Settings.Addresses[:i], s.Settings.Addresses[i+1:]...)
+			delete(s.Settings.RelayProxies, addr)
+			return
+		}
+	}
+}
+
+func (s *settingsService) Proxy() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Settings.Proxy
+}
+
+func (s *settingsService) SetProxy(proxy string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Settings.Proxy = proxy
+}
+
+func (s *settingsService) RelayProxy(addr string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Settings.RelayProxies[addr]
+}
+
+// SetRelayProxy configures the proxy for a single relay. The empty string
+// reverts the relay to the global proxy.
+func (s *settingsService) SetRelayProxy(addr, proxy string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if proxy == "" {
+		delete(s.Settings.RelayProxies, addr)
+		return
+	}
+	if s.Settings.RelayProxies == nil {
+		s.Settings.RelayProxies = make(map[string]string)
+	}
+	s.Settings.RelayProxies[addr] = proxy
 }
 
-func (s *settingsService) SetAddress(addr string) {
-	s.Settings.Address = addr
+func (s *settingsService) ProxyFor(addr string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	proxy, ok := s.Settings.RelayProxies[addr]
+	if !ok {
+		return s.Settings.Proxy
+	}
+	if proxy == DirectProxy {
+		return ""
+	}
+	return proxy
 }
 
 func (s *settingsService) DataPath() string {
@@ -183,33 +434,99 @@ func (s *settingsService) DataPath() string {
 }
 
 func (s *settingsService) BottomAppBar() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.BottomAppBar
 }
 
 func (s *settingsService) SetBottomAppBar(bottom bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.BottomAppBar = bottom
 }
 
 func (s *settingsService) DarkMode() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.DarkMode
 }
 
 func (s *settingsService) SetDarkMode(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.DarkMode = enabled
 }
 
 func (s *settingsService) UseOrchardStore() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.Settings.OrchardStore
 }
 
 func (s *settingsService) SetUseOrchardStore(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Settings.OrchardStore = enabled
 }
 
+func (s *settingsService) EncryptStore() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Settings.EncryptStore
+}
+
+func (s *settingsService) SetEncryptStore(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Settings.EncryptStore = enabled
+}
+
+func (s *settingsService) StoreBackend() StoreBackend {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.storeBackend()
+}
+
+// storeBackend returns the active store backend. The caller must hold
+// lock.
+func (s *settingsService) storeBackend() StoreBackend {
+	if s.Settings.StoreBackend != "" {
+		return s.Settings.StoreBackend
+	}
+	return backendFor(s.Settings.EncryptStore, s.Settings.OrchardStore)
+}
+
+func (s *settingsService) SetStoreBackend(backend StoreBackend) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if previous := s.storeBackend(); previous != backend {
+		s.Settings.RetiredStoreBackend = previous
+	}
+	s.Settings.StoreBackend = backend
+}
+
+func (s *settingsService) RetiredStoreBackend() StoreBackend {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Settings.RetiredStoreBackend
+}
+
+func (s *settingsService) ClearRetiredStoreBackend() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Settings.RetiredStoreBackend = ""
+}
+
 func (s *settingsService) SettingsFile() string {
 	return filepath.Join(s.dataDir, "settings.json")
 }
 
+// SettingsBackupFile returns the path of the copy of the settings file made
+// before it was last replaced.
+func (s *settingsService) SettingsBackupFile() string {
+	return filepath.Join(s.dataDir, "settings.json.bak")
+}
+
 func (s *settingsService) KeysDir() string {
 	return filepath.Join(s.dataDir, "keys")
 }
@@ -218,64 +535,140 @@ func (s *settingsService) IdentitiesDir() string {
 	return filepath.Join(s.dataDir, "identities")
 }
 
-func (s *settingsService) DiscoverIdentities() error {
-	idsDir, err := os.Open(s.IdentitiesDir())
-	if err != nil {
-		return fmt.Errorf("failed opening identities directory: %w", err)
+func (s *settingsService) ArchivesDir() string {
+	return filepath.Join(s.dataDir, "archives")
+}
+
+func (s *settingsService) QuarantineDir() string {
+	return filepath.Join(s.dataDir, "quarantine")
+}
+
+func (s *settingsService) RetentionPolicy(communityID string) RetentionPolicy {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Settings.Retention[communityID]
+}
+
+// SetRetentionPolicy configures the retention policy of a single community.
+// A policy that keeps everything removes the community's entry.
+func (s *settingsService) SetRetentionPolicy(communityID string, policy RetentionPolicy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !policy.Active() {
+		delete(s.Settings.Retention, communityID)
+		return
 	}
-	names, err := idsDir.Readdirnames(0)
+	if s.Settings.Retention == nil {
+		s.Settings.Retention = make(map[string]RetentionPolicy)
+	}
+	s.Settings.Retention[communityID] = policy
+}
+
+func (s *settingsService) LocalIdentityIDs() []*fields.QualifiedHash {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	ids, err := s.listIdentityIDs()
 	if err != nil {
-		return fmt.Errorf("failed listing identities directory: %w", err)
+		log.Printf("%v", err)
 	}
-	name := names[0]
-	id := &fields.QualifiedHash{}
-	err = id.UnmarshalText([]byte(name))
+	if s.ActiveIdentity != nil {
+		found := false
+		for _, id := range ids {
+			found = found || id.Equals(s.ActiveIdentity)
+		}
+		if !found {
+			ids = append(ids, s.ActiveIdentity)
+		}
+	}
+	local := len(ids)
+	for _, id := range ids[:local] {
+		ids = append(ids, s.PreviousIdentities[id.String()]...)
+	}
+	return ids
+}
+
+func (s *settingsService) ActiveIdentityIDs() []*fields.QualifiedHash {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	if s.ActiveIdentity == nil {
+		return nil
+	}
+	previous := s.PreviousIdentities[s.ActiveIdentity.String()]
+	return append([]*fields.QualifiedHash{s.ActiveIdentity}, previous...)
+}
+
+// DiscoverIdentities ensures that the active identity is one in the
+// identities directory, choosing the first one found if the configured
+// identity is missing. The active identity is cleared if there are none.
+func (s *settingsService) DiscoverIdentities() error {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	ids, err := s.listIdentityIDs()
 	if err != nil {
-		return fmt.Errorf("failed unmarshalling name of first identity %s: %w", name, err)
+		return err
+	}
+	for _, id := range ids {
+		if s.ActiveIdentity != nil && id.Equals(s.ActiveIdentity) {
+			return nil
+		}
+	}
+	s.clearActiveIdentity()
+	if len(ids) > 0 {
+		s.ActiveIdentity = ids[0]
 	}
-	s.ActiveIdentity = id
 	return nil
 }
 
 func (s *settingsService) Identity() (*forest.Identity, error) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return s.identity()
+}
+
+// identity returns the active identity. The caller must hold identityLock.
+func (s *settingsService) identity() (*forest.Identity, error) {
 	if s.ActiveIdentity == nil {
 		return nil, fmt.Errorf("no identity configured")
 	}
 	if s.activeIdCache != nil {
 		return s.activeIdCache, nil
 	}
-	idData, err := ioutil.ReadFile(filepath.Join(s.IdentitiesDir(), s.ActiveIdentity.String()))
+	identity, err := s.loadIdentity(s.ActiveIdentity)
 	if err != nil {
-		return nil, fmt.Errorf("failed reading identity data: %w", err)
-	}
-	identity, err := forest.UnmarshalIdentity(idData)
-	if err != nil {
-		return nil, fmt.Errorf("failed decoding identity data: %w", err)
+		return nil, err
 	}
 	s.activeIdCache = identity
 	return identity, nil
 }
 
 func (s *settingsService) Signer() (forest.Signer, error) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return s.signer()
+}
+
+// signer returns a signer for the active identity. The caller must hold
+// identityLock.
+func (s *settingsService) signer() (forest.Signer, error) {
 	if s.ActiveIdentity == nil {
 		return nil, fmt.Errorf("no identity configured, therefore no private key")
 	}
-	var privkey *openpgp.Entity
-	if s.activePrivKey != nil {
-		privkey = s.activePrivKey
-	} else {
-		keyfilePath := filepath.Join(s.KeysDir(), s.ActiveIdentity.String())
-		keyfile, err := os.Open(keyfilePath)
+	if s.activePrivKey == nil {
+		path, err := s.keyFile()
 		if err != nil {
-			return nil, fmt.Errorf("unable to read key file: %w", err)
+			return nil, err
 		}
-		defer keyfile.Close()
-		privkey, err = openpgp.ReadEntity(packet.NewReader(keyfile))
+		privkey, err := readKeyFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("unable to decode key data: %w", err)
+			return nil, err
+		}
+		if entityEncrypted(privkey) {
+			return nil, ErrKeyLocked
 		}
 		s.activePrivKey = privkey
 	}
+	privkey := s.activePrivKey
+	s.touchKey()
 	signer, err := forest.NewNativeSigner(privkey)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't wrap privkey in forest signer: %w", err)
@@ -284,11 +677,13 @@ func (s *settingsService) Signer() (forest.Signer, error) {
 }
 
 func (s *settingsService) Builder() (*forest.Builder, error) {
-	id, err := s.Identity()
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	id, err := s.identity()
 	if err != nil {
 		return nil, err
 	}
-	signer, err := s.Signer()
+	signer, err := s.signer()
 	if err != nil {
 		return nil, err
 	}
@@ -356,19 +751,41 @@ func (s *settingsService) CreateIdentity(name string) (err error) {
 		return fmt.Errorf("failed writing identity: %w", err)
 	}
 
+	s.identityLock.Lock()
+	s.clearActiveIdentity()
 	s.ActiveIdentity = id
 	s.activePrivKey = keypair
+	s.activeIdCache = identity
+	s.identityLock.Unlock()
 	return s.Persist()
 }
 
 func (s *settingsService) Persist() error {
-	data, err := json.MarshalIndent(&s, "", "  ")
+	s.persistLock.Lock()
+	defer s.persistLock.Unlock()
+	data, err := s.marshal()
 	if err != nil {
 		return fmt.Errorf("couldn't marshal settings as json: %w", err)
 	}
-	err = ioutil.WriteFile(s.SettingsFile(), data, 0770)
-	if err != nil {
+	path := s.SettingsFile()
+	// Keep the previous file in case the new one is damaged. A file that
+	// cannot be parsed would replace a good backup, so it is skipped.
+	if previous, err := ioutil.ReadFile(path); err == nil && json.Valid(previous) {
+		if err := writeFileAtomic(s.SettingsBackupFile(), previous, 0660); err != nil {
+			log.Printf("failed backing up settings file: %v", err)
+		}
+	}
+	if err := writeFileAtomic(path, data, 0660); err != nil {
 		return fmt.Errorf("couldn't save settings file: %w", err)
 	}
 	return nil
 }
+
+// marshal encodes the settings while holding every lock that guards them.
+func (s *settingsService) marshal() ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return json.MarshalIndent(&s.Settings, "", "  ")
+}
@@ -1,9 +1,12 @@
 package core
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,47 +17,176 @@ import (
 	"git.sr.ht/~whereswaldon/sprout-go"
 )
 
+// SproutService manages sprout connections to any number of relays. Each
+// relay address has an independent worker lifecycle.
 type SproutService interface {
 	ConnectTo(address string) error
+	Disconnect(address string) error
+	Reconnect(address string) error
 	Connections() []string
 	WorkerFor(address string) *sprout.Worker
+	// Status returns the current state of the connection to the given
+	// address.
+	Status(address string) ConnectionStatus
+	// SubscribeToStatus registers a handler that is invoked with each
+	// change in the state of any relay connection. Handlers should not
+	// block.
+	SubscribeToStatus(handler func(ConnectionStatus)) ConnectionSubscription
+	// UnsubscribeFromStatus removes a handler registered with
+	// SubscribeToStatus.
+	UnsubscribeFromStatus(ConnectionSubscription)
+	// Pins returns the certificate pins for TLS relays.
+	Pins() *PinStore
 	MarkSelfOffline()
 }
 
+const (
+	// minRetryDelay is the delay after the first failed connection attempt.
+	minRetryDelay = time.Second
+	// maxRetryDelay caps the delay between connection attempts.
+	maxRetryDelay = 5 * time.Minute
+	// maxConnectAttempts is the number of consecutive failures after which
+	// a relay is marked as Failed.
+	maxConnectAttempts = 12
+	// stableConnectionDuration is how long a connection must last before
+	// its failure no longer counts toward the backoff.
+	stableConnectionDuration = 30 * time.Second
+)
+
 type sproutService struct {
+	tasks *taskGroup
 	ArborService
 	BannerService
 	SettingsService
 	workerLock sync.Mutex
-	workerDone chan struct{}
-	workers    map[string]*sprout.Worker
+	// workerDone holds the done channel for each address that should
+	// currently have a worker running.
+	workerDone map[string]chan struct{}
+	// workers holds only workers that are currently connected.
+	workers map[string]*sprout.Worker
+	// pinAlerts holds the banner warning about a changed certificate for
+	// each address that was refused.
+	pinAlerts map[string]Banner
+	pins      *PinStore
+	// transports dial relays, pinning TLS certificates in pins.
+	transports *Transports
+	// retry is the backoff policy copied by each worker.
+	retry backoff
+
+	statusLock        sync.Mutex
+	statuses          map[string]ConnectionStatus
+	nextSubscription  ConnectionSubscription
+	statusSubscribers map[ConnectionSubscription]func(ConnectionStatus)
 }
 
 var _ SproutService = &sproutService{}
 
-func newSproutService(arbor ArborService, banner BannerService, settings SettingsService) (SproutService, error) {
+func newSproutService(tasks *taskGroup, arbor ArborService, banner BannerService, settings SettingsService) (SproutService, error) {
+	pins, err := NewPinStore(filepath.Join(settings.DataPath(), "pins.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed loading certificate pins: %w", err)
+	}
+	transports := NewTransports()
+	transports.Register("tls", &TLSTransport{Pins: pins})
 	s := &sproutService{
+		tasks:           tasks,
 		ArborService:    arbor,
 		BannerService:   banner,
 		SettingsService: settings,
 		workers:         make(map[string]*sprout.Worker),
-		workerDone:      make(chan struct{}),
+		workerDone:      make(map[string]chan struct{}),
+		pinAlerts:       make(map[string]Banner),
+		pins:            pins,
+		transports:      transports,
+		retry: backoff{
+			Min:         minRetryDelay,
+			Max:         maxRetryDelay,
+			MaxAttempts: maxConnectAttempts,
+		},
+		statuses:          make(map[string]ConnectionStatus),
+		statusSubscribers: make(map[ConnectionSubscription]func(ConnectionStatus)),
 	}
+	tasks.Go(s.stopAllOnDone)
 	return s, nil
 }
 
-// ConnectTo (re)connects to the specified address.
+// stopAllOnDone stops every worker once ctx is cancelled.
+func (s *sproutService) stopAllOnDone(ctx context.Context) {
+	<-ctx.Done()
+	s.workerLock.Lock()
+	defer s.workerLock.Unlock()
+	for address := range s.workerDone {
+		s.stopWorker(address)
+	}
+}
+
+// ConnectTo starts a worker connected to the specified address. It does
+// nothing if a worker for that address is already running. Connections to
+// other addresses are unaffected.
 func (s *sproutService) ConnectTo(address string) error {
 	s.workerLock.Lock()
 	defer s.workerLock.Unlock()
-	if s.workerDone != nil {
-		close(s.workerDone)
+	if _, running := s.workerDone[address]; running {
+		return nil
+	}
+	s.startWorker(address)
+	return nil
+}
+
+// Disconnect stops the worker for the specified address. Relays that have
+// Failed no longer have a worker, but can still be disconnected to clear
+// their status.
+func (s *sproutService) Disconnect(address string) error {
+	s.workerLock.Lock()
+	if _, running := s.workerDone[address]; !running && s.Status(address).State != Failed {
+		s.workerLock.Unlock()
+		return fmt.Errorf("no worker running for %s", address)
 	}
-	s.workerDone = make(chan struct{})
-	go s.launchWorker(address)
+	s.stopWorker(address)
+	notify := s.recordStatus(ConnectionStatus{
+		Address: address,
+		State:   Disconnected,
+	})
+	s.workerLock.Unlock()
+	notify()
+	return nil
+}
+
+// Reconnect stops the worker for the specified address (if any) and starts
+// a fresh one. This also restarts relays that have Failed.
+func (s *sproutService) Reconnect(address string) error {
+	s.workerLock.Lock()
+	defer s.workerLock.Unlock()
+	s.stopWorker(address)
+	s.startWorker(address)
 	return nil
 }
 
+// startWorker launches a worker for the given address. It must be called with
+// the workerLock held.
+func (s *sproutService) startWorker(address string) {
+	done := make(chan struct{})
+	s.workerDone[address] = done
+	s.tasks.Go(func(context.Context) {
+		s.launchWorker(address, done)
+	})
+}
+
+// stopWorker shuts down the worker for the given address. It must be called
+// with the workerLock held.
+func (s *sproutService) stopWorker(address string) {
+	if done, ok := s.workerDone[address]; ok {
+		close(done)
+		delete(s.workerDone, address)
+	}
+	if worker, ok := s.workers[address]; ok {
+		// Closing the connection unblocks the worker's read loop.
+		worker.Conn.Conn.Close()
+		delete(s.workers, address)
+	}
+}
+
+// Connections returns the addresses of all currently-connected relays.
 func (s *sproutService) Connections() []string {
 	s.workerLock.Lock()
 	defer s.workerLock.Unlock()
@@ -62,9 +194,12 @@ func (s *sproutService) Connections() []string {
 	for addr := range s.workers {
 		out = append(out, addr)
 	}
+	sort.Strings(out)
 	return out
 }
 
+// WorkerFor returns the worker connected to the given address, or nil if
+// there is no live connection to it.
 func (s *sproutService) WorkerFor(address string) *sprout.Worker {
 	s.workerLock.Lock()
 	defer s.workerLock.Unlock()
@@ -75,62 +210,262 @@ func (s *sproutService) WorkerFor(address string) *sprout.Worker {
 	return out
 }
 
-func (s *sproutService) launchWorker(addr string) {
-	firstAttempt := true
+// Status returns the most recent status of the given address. Addresses
+// that have never been connected are Disconnected.
+func (s *sproutService) Status(address string) ConnectionStatus {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	status, ok := s.statuses[address]
+	if !ok {
+		return ConnectionStatus{Address: address, State: Disconnected}
+	}
+	return status
+}
+
+func (s *sproutService) SubscribeToStatus(handler func(ConnectionStatus)) ConnectionSubscription {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.nextSubscription++
+	s.statusSubscribers[s.nextSubscription] = handler
+	return s.nextSubscription
+}
+
+func (s *sproutService) UnsubscribeFromStatus(id ConnectionSubscription) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	delete(s.statusSubscribers, id)
+}
+
+func (s *sproutService) Pins() *PinStore {
+	return s.pins
+}
+
+// recordStatus stores the provided status and returns a function that
+// notifies subscribers of it. The returned function should be invoked
+// after releasing any locks.
+func (s *sproutService) recordStatus(status ConnectionStatus) func() {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.statuses[status.Address] = status
+	handlers := make([]func(ConnectionStatus), 0, len(s.statusSubscribers))
+	for _, handler := range s.statusSubscribers {
+		handlers = append(handlers, handler)
+	}
+	return func() {
+		for _, handler := range handlers {
+			handler(status)
+		}
+	}
+}
+
+// reportStatus records a status change on behalf of the worker goroutine
+// identified by done. Reports from workers that have since been stopped are
+// discarded. It returns false if the report was discarded.
+func (s *sproutService) reportStatus(done chan struct{}, status ConnectionStatus) bool {
+	s.workerLock.Lock()
+	if s.workerDone[status.Address] != done {
+		s.workerLock.Unlock()
+		return false
+	}
+	notify := s.recordStatus(status)
+	s.workerLock.Unlock()
+	notify()
+	return true
+}
+
+// giveUp marks the worker identified by done as Failed and releases its
+// address so that it can be connected again later.
+func (s *sproutService) giveUp(done chan struct{}, status ConnectionStatus) {
+	s.workerLock.Lock()
+	if s.workerDone[status.Address] != done {
+		s.workerLock.Unlock()
+		return
+	}
+	delete(s.workerDone, status.Address)
+	notify := s.recordStatus(status)
+	s.workerLock.Unlock()
+	notify()
+}
+
+// isDone returns whether the provided done channel has been closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// launchWorker runs the connection state machine for a single address until
+// the done channel is closed or the connection attempts are exhausted.
+func (s *sproutService) launchWorker(addr string, done chan struct{}) {
+	var (
+		lastErr error
+		retry   = s.retry
+	)
 	logger := log.New(log.Writer(), "worker "+addr, log.LstdFlags|log.Lshortfile)
 	for {
-		worker, done := func() (*sprout.Worker, chan struct{}) {
-			connectionBanner := &LoadingBanner{
-				Priority: Info,
-				Text:     "Connecting to " + addr + "...",
+		if retry.Exhausted() {
+			logger.Printf("Giving up on %s after %d attempts: %v", addr, retry.Attempts(), lastErr)
+			s.giveUp(done, ConnectionStatus{
+				Address:  addr,
+				State:    Failed,
+				Err:      lastErr,
+				Attempts: retry.Attempts(),
+			})
+			return
+		}
+		if retry.Attempts() > 0 {
+			delay := retry.Delay()
+			logger.Printf("Restarting worker for address %s in %v", addr, delay)
+			if !s.reportStatus(done, ConnectionStatus{
+				Address:  addr,
+				State:    BackingOff,
+				Err:      lastErr,
+				Attempts: retry.Attempts(),
+				RetryAt:  time.Now().Add(delay),
+			}) {
+				return
 			}
-			defer connectionBanner.Cancel()
-			s.BannerService.Add(connectionBanner)
-			if !firstAttempt {
-				logger.Printf("Restarting worker for address %s", addr)
-				time.Sleep(time.Second)
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
 			}
-			firstAttempt = false
-
-			s.workerLock.Lock()
-			done := s.workerDone
-			s.workerLock.Unlock()
-
-			worker, err := NewWorker(addr, done, s.ArborService.Store())
-			if err != nil {
-				log.Printf("Failed starting worker: %v", err)
-				return nil, nil
+		}
+		if !s.reportStatus(done, ConnectionStatus{
+			Address:  addr,
+			State:    Connecting,
+			Err:      lastErr,
+			Attempts: retry.Attempts(),
+		}) {
+			return
+		}
+		worker, err := s.dial(addr, done, logger)
+		if isDone(done) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed starting worker: %v", err)
+			var mismatch *PinMismatchError
+			if errors.As(err, &mismatch) {
+				// Retrying cannot help until the user reviews the pin.
+				s.alertPinMismatch(addr, mismatch)
+				s.giveUp(done, ConnectionStatus{
+					Address:  addr,
+					State:    Failed,
+					Err:      err,
+					Attempts: retry.Attempts() + 1,
+				})
+				return
 			}
-			worker.Logger = log.New(logger.Writer(), fmt.Sprintf("worker-%v ", addr), log.Flags())
-
-			s.workerLock.Lock()
-			s.workers[addr] = worker
-			s.workerLock.Unlock()
-			return worker, done
-		}()
-		if worker == nil {
+			lastErr = err
+			retry.Fail()
 			continue
 		}
+		s.clearPinAlert(addr)
 
-		go func() {
+		s.reportStatus(done, ConnectionStatus{
+			Address: addr,
+			State:   Syncing,
+		})
+		s.tasks.Go(func(context.Context) {
 			synchronizingBanner := &LoadingBanner{
 				Priority: Info,
 				Text:     "Syncing with " + addr + "...",
 			}
 			s.BannerService.Add(synchronizingBanner)
 			defer synchronizingBanner.Cancel()
-			BootstrapSubscribed(worker, s.SettingsService.Subscriptions())
-		}()
+			err := BootstrapSubscribed(worker, s.SettingsService.Subscriptions())
+			if s.WorkerFor(addr) != worker {
+				return
+			}
+			s.reportStatus(done, ConnectionStatus{
+				Address: addr,
+				State:   Connected,
+				Err:     err,
+			})
+		})
 
+		connectedAt := time.Now()
 		worker.Run()
-		select {
-		case <-done:
+
+		s.workerLock.Lock()
+		if s.workers[addr] == worker {
+			delete(s.workers, addr)
+		}
+		s.workerLock.Unlock()
+
+		if isDone(done) {
 			return
-		default:
 		}
+		if time.Since(connectedAt) > stableConnectionDuration {
+			retry.Reset()
+		}
+		lastErr = fmt.Errorf("lost connection to %s", addr)
+		retry.Fail()
+	}
+}
+
+// alertPinMismatch displays an Error banner warning that the relay at addr
+// presented a certificate that does not match its pin.
+func (s *sproutService) alertPinMismatch(addr string, mismatch *PinMismatchError) {
+	s.clearPinAlert(addr)
+	alert := &MessageBanner{
+		Priority: Error,
+		Text:     fmt.Sprintf("Refused changed certificate for %s. Review it in Settings before reconnecting.", mismatch.Address),
+	}
+	s.workerLock.Lock()
+	s.pinAlerts[addr] = alert
+	s.workerLock.Unlock()
+	s.BannerService.Add(alert)
+}
+
+// clearPinAlert cancels any certificate warning displayed for addr.
+func (s *sproutService) clearPinAlert(addr string) {
+	s.workerLock.Lock()
+	defer s.workerLock.Unlock()
+	if alert, ok := s.pinAlerts[addr]; ok {
+		alert.Cancel()
+		delete(s.pinAlerts, addr)
 	}
 }
 
+// dial establishes a new worker for the given address and registers it as
+// live.
+func (s *sproutService) dial(addr string, done chan struct{}, logger *log.Logger) (*sprout.Worker, error) {
+	connectionBanner := &LoadingBanner{
+		Priority: Info,
+		Text:     "Connecting to " + addr + "...",
+	}
+	defer connectionBanner.Cancel()
+	s.BannerService.Add(connectionBanner)
+
+	relayStore := &relayStore{
+		ExtendedStore: s.ArborService.Store(),
+		relay:         addr,
+		tracker:       s.ArborService.Propagation(),
+	}
+	worker, err := newWorker(s.transports, addr, s.SettingsService.ProxyFor(addr), done, relayStore)
+	if err != nil {
+		return nil, err
+	}
+	worker.Logger = log.New(logger.Writer(), fmt.Sprintf("worker-%v ", addr), log.Flags())
+	relayStore.worker = worker
+	trackAnnouncements(worker, addr, relayStore.tracker)
+
+	s.workerLock.Lock()
+	defer s.workerLock.Unlock()
+	if isDone(done) {
+		// We were disconnected while dialing.
+		worker.Conn.Conn.Close()
+		return nil, fmt.Errorf("disconnected from %s while dialing", addr)
+	}
+	s.workers[addr] = worker
+	return worker, nil
+}
+
 // MarkSelfOffline announces that the local user is offline in all known
 // communities.
 func (s *sproutService) MarkSelfOffline() {
@@ -208,11 +543,24 @@ func BootstrapSubscribed(worker *sprout.Worker, subscribed []string) error {
 }
 
 // NewWorker creates a sprout worker connected to the provided address using
-// TLS over TCP as a transport.
-func NewWorker(addr string, done <-chan struct{}, s store.ExtendedStore) (*sprout.Worker, error) {
-	conn, err := tls.Dial("tcp", addr, nil)
+// the transport in DefaultTransports selected by the address scheme. See
+// ParseAddress for the supported schemes. If proxy is not empty, the
+// connection is made through it. Failures caused by the proxy wrap a
+// *ProxyError.
+func NewWorker(addr, proxy string, done <-chan struct{}, s store.ExtendedStore) (*sprout.Worker, error) {
+	return newWorker(DefaultTransports, addr, proxy, done, s)
+}
+
+// newWorker creates a sprout worker connected to the provided address using
+// the given transports.
+func newWorker(transports *Transports, addr, proxy string, done <-chan struct{}, s store.ExtendedStore) (*sprout.Worker, error) {
+	conn, err := transports.Dial(addr, proxy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+		var proxyErr *ProxyError
+		if errors.As(err, &proxyErr) {
+			return nil, fmt.Errorf("failed to reach %s through proxy: %w", addr, err)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
 
 	worker, err := sprout.NewWorker(done, conn, s)
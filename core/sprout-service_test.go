@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprout-go"
+)
+
+// stubArbor, stubBanners and stubSettings provide just enough of their
+// services for a sproutService to dial relays that cannot be reached.
+type stubArbor struct{ ArborService }
+
+func (stubArbor) Store() store.ExtendedStore       { return nil }
+func (stubArbor) Propagation() *PropagationTracker { return nil }
+
+type stubBanners struct{ BannerService }
+
+func (stubBanners) Add(Banner) {}
+
+type stubSettings struct{ SettingsService }
+
+func (stubSettings) ProxyFor(string) string { return "" }
+
+func newTestSproutService(t *testing.T) *sproutService {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &sproutService{
+		tasks:           newTaskGroup(ctx),
+		ArborService:    stubArbor{},
+		BannerService:   stubBanners{},
+		SettingsService: stubSettings{},
+		workers:         make(map[string]*sprout.Worker),
+		workerDone:      make(map[string]chan struct{}),
+		pinAlerts:       make(map[string]Banner),
+		transports:      NewTransports(),
+		retry: backoff{
+			Min:         time.Millisecond,
+			Max:         time.Millisecond,
+			MaxAttempts: 3,
+		},
+		statuses:          make(map[string]ConnectionStatus),
+		statusSubscribers: make(map[ConnectionSubscription]func(ConnectionStatus)),
+	}
+}
+
+func TestUnreachableRelayFails(t *testing.T) {
+	s := newTestSproutService(t)
+	const address = "pipe://unreachable"
+	states := make(chan ConnectionStatus, 32)
+	s.SubscribeToStatus(func(status ConnectionStatus) {
+		states <- status
+	})
+	if err := s.ConnectTo(address); err != nil {
+		t.Fatalf("failed connecting: %v", err)
+	}
+	var failed ConnectionStatus
+	backoffs := 0
+	timeout := time.After(5 * time.Second)
+	for failed.State != Failed {
+		select {
+		case status := <-states:
+			switch status.State {
+			case BackingOff:
+				backoffs++
+			case Failed:
+				failed = status
+			}
+		case <-timeout:
+			t.Fatalf("relay never Failed, last status %+v", s.Status(address))
+		}
+	}
+	if backoffs != 2 {
+		t.Errorf("expected to back off between each of 3 attempts, backed off %d times", backoffs)
+	}
+	if failed.Attempts != 3 || failed.Err == nil {
+		t.Errorf("expected Failed after 3 attempts with an error, got %+v", failed)
+	}
+
+	if err := s.Disconnect(address); err != nil {
+		t.Fatalf("failed disconnecting Failed relay: %v", err)
+	}
+	if state := s.Status(address).State; state != Disconnected {
+		t.Fatalf("expected Disconnected after disconnecting, got %v", state)
+	}
+	if err := s.Disconnect(address); err == nil {
+		t.Fatalf("expected error disconnecting a relay that is not running")
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// statisticsLimit bounds the number of nodes of each type examined when
+// computing StoreStatistics.
+const statisticsLimit = 100000
+
+// StoreActivity summarizes the nodes removed from the store since launch.
+type StoreActivity struct {
+	// LastPurge is when an expired node was last purged.
+	LastPurge time.Time
+	// Expired is the number of expired subtrees removed.
+	Expired int
+	// LastPrune is when retention policies were last applied.
+	LastPrune time.Time
+	// Pruned totals the messages removed by retention policies.
+	Pruned PruneResult
+}
+
+// CommunityStatistics describes the stored messages of a single community.
+type CommunityStatistics struct {
+	*forest.Community
+	// Replies is the number of stored replies within the community.
+	Replies int
+	// Authors is the number of distinct authors of those replies.
+	Authors int
+	// Newest is when the newest of those replies was created.
+	Newest time.Time
+}
+
+// StoreStatistics describes the contents of the store.
+type StoreStatistics struct {
+	Backend  StoreBackend
+	Computed time.Time
+	// Identities, Communities, and Replies count the stored nodes of each
+	// type.
+	Identities, Communities, Replies int
+	// Truncated is set when some type had more than statisticsLimit
+	// nodes, in which case the counts are lower bounds.
+	Truncated bool
+	// Authors is the number of distinct authors of the stored replies.
+	Authors int
+	// Oldest and Newest are when the oldest and newest replies were
+	// created.
+	Oldest, Newest time.Time
+	// DiskSize is the size of the store's files in bytes.
+	DiskSize int64
+	// PerCommunity describes each known community, busiest first.
+	PerCommunity []CommunityStatistics
+	Activity     StoreActivity
+}
+
+// Statistics computes StoreStatistics for the current store. This reads
+// every stored node, so it should not be called from the UI goroutine.
+func (a *arborService) Statistics(ctx context.Context) (*StoreStatistics, error) {
+	a.storeLock.Lock()
+	backend := a.backend
+	a.storeLock.Unlock()
+	stats := &StoreStatistics{
+		Backend:  backend,
+		Computed: time.Now(),
+		Activity: a.Activity(),
+	}
+	counts := []struct {
+		nodeType fields.NodeType
+		count    *int
+	}{
+		{fields.NodeTypeIdentity, &stats.Identities},
+		{fields.NodeTypeCommunity, &stats.Communities},
+	}
+	for _, c := range counts {
+		nodes, err := a.grove.Recent(c.nodeType, statisticsLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed counting %s nodes: %w", c.nodeType, err)
+		}
+		*c.count = len(nodes)
+		stats.Truncated = stats.Truncated || len(nodes) >= statisticsLimit
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	replies, err := a.grove.Recent(fields.NodeTypeReply, statisticsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed counting replies: %w", err)
+	}
+	stats.Replies = len(replies)
+	stats.Truncated = stats.Truncated || len(replies) >= statisticsLimit
+	communities := make(map[string]*CommunityStatistics)
+	communityAuthors := make(map[string]map[string]struct{})
+	a.cl.WithCommunities(func(known []*forest.Community) {
+		stats.PerCommunity = make([]CommunityStatistics, len(known))
+		for i, community := range known {
+			stats.PerCommunity[i].Community = community
+			id := community.ID().String()
+			communities[id] = &stats.PerCommunity[i]
+			communityAuthors[id] = make(map[string]struct{})
+		}
+	})
+	authors := make(map[string]struct{})
+	for i, node := range replies {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		reply, ok := node.(*forest.Reply)
+		if !ok {
+			continue
+		}
+		author := reply.Author.String()
+		authors[author] = struct{}{}
+		created := reply.CreatedAt()
+		if stats.Newest.IsZero() || created.After(stats.Newest) {
+			stats.Newest = created
+		}
+		if stats.Oldest.IsZero() || created.Before(stats.Oldest) {
+			stats.Oldest = created
+		}
+		community := reply.CommunityID.String()
+		c, ok := communities[community]
+		if !ok {
+			continue
+		}
+		c.Replies++
+		communityAuthors[community][author] = struct{}{}
+		if created.After(c.Newest) {
+			c.Newest = created
+		}
+	}
+	stats.Authors = len(authors)
+	for id, c := range communities {
+		c.Authors = len(communityAuthors[id])
+	}
+	sort.SliceStable(stats.PerCommunity, func(i, j int) bool {
+		return stats.PerCommunity[i].Replies > stats.PerCommunity[j].Replies
+	})
+	if stats.DiskSize, err = storeSize(a.SettingsService.DataPath(), backend); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// storeSize returns the size in bytes of the files holding the data of the
+// given backend within the data path.
+func storeSize(path string, backend StoreBackend) (int64, error) {
+	switch backend {
+	case OrchardBackend:
+		info, err := os.Stat(filepath.Join(path, orchardFile))
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("measuring Orchard store: %w", err)
+		}
+		return info.Size(), nil
+	case GroveBackend, EncryptedBackend:
+		if backend == EncryptedBackend {
+			path = filepath.Join(path, encryptedDir)
+		}
+		infos, err := ioutil.ReadDir(path)
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("measuring Grove store: %w", err)
+		}
+		var size int64
+		for _, info := range infos {
+			var id fields.QualifiedHash
+			if !info.Mode().IsRegular() || id.UnmarshalText([]byte(info.Name())) != nil {
+				continue
+			}
+			size += info.Size()
+		}
+		return size, nil
+	default:
+		return 0, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// Activity returns the removals made from the store since launch.
+func (a *arborService) Activity() StoreActivity {
+	a.activityLock.Lock()
+	defer a.activityLock.Unlock()
+	return a.activity
+}
+
+// recordPurge adds the number of expired subtrees that were just purged to
+// the activity.
+func (a *arborService) recordPurge(expired int) {
+	a.activityLock.Lock()
+	defer a.activityLock.Unlock()
+	a.activity.LastPurge = time.Now()
+	a.activity.Expired += expired
+}
+
+// recordPrune adds the result of applying retention policies to the
+// activity.
+func (a *arborService) recordPrune(result PruneResult) {
+	a.activityLock.Lock()
+	defer a.activityLock.Unlock()
+	a.activity.LastPrune = time.Now()
+	a.activity.Pruned.add(result)
+}
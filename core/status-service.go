@@ -13,13 +13,15 @@ type StatusService interface {
 }
 
 type statusService struct {
+	tasks *taskGroup
 	*status.StatusManager
 }
 
 var _ StatusService = &statusService{}
 
-func newStatusService() (StatusService, error) {
+func newStatusService(tasks *taskGroup) (StatusService, error) {
 	return &statusService{
+		tasks:         tasks,
 		StatusManager: status.NewStatusManager(),
 	}, nil
 }
@@ -27,7 +29,7 @@ func newStatusService() (StatusService, error) {
 // Register subscribes the StatusService to new nodes within
 // the provided store.
 func (s *statusService) Register(stor store.ExtendedStore) {
-	stor.SubscribeToNewMessages(s.StatusManager.HandleNode)
+	s.tasks.Subscribe(stor, s.StatusManager.HandleNode)
 }
 
 // IsActive returns whether or not a given user is listed as currently
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"git.sr.ht/~whereswaldon/forest-go/grove"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedDir is the directory within the data path holding the encrypted
+// store.
+const encryptedDir = "encrypted"
+
+// encryptionFile is the name of the file within encryptedDir that holds the
+// store key, encrypted with a key derived from the passphrase. Grove ignores
+// it because it is not named after a node ID.
+const encryptionFile = "encryption.json"
+
+// encryptionVersion is the version of the encryption format written by
+// createStoreKey.
+const encryptionVersion = 1
+
+var (
+	// ErrStoreLocked is returned when opening an encrypted store before
+	// its passphrase has been provided.
+	ErrStoreLocked = errors.New("store is locked")
+	// ErrWrongPassphrase is returned when the passphrase does not unlock
+	// the store key.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// storeEncryption is the contents of encryptionFile.
+type storeEncryption struct {
+	Version int
+	// Salt, N, R, and P are the scrypt parameters used to derive the key
+	// that encrypts Key from the passphrase.
+	Salt    []byte
+	N, R, P int
+	// Key is the nonce followed by the encrypted store key.
+	Key []byte
+}
+
+// storeKey encrypts and decrypts the node files of an encrypted store.
+type storeKey struct {
+	aead cipher.AEAD
+}
+
+func newStoreKey(key []byte) (*storeKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &storeKey{aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts the plaintext, binding it to the given name so that it
+// cannot be swapped with the contents of another file. The nonce is
+// prepended to the result.
+func seal(aead cipher.AEAD, plaintext []byte, name string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(name)), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, ciphertext []byte, name string) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(name))
+}
+
+// deriveKey derives the key that encrypts the store key from the
+// passphrase.
+func (e *storeEncryption) deriveKey(passphrase string) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed deriving key from passphrase: %w", err)
+	}
+	return newAEAD(derived)
+}
+
+// hasStoreKey reports whether an encrypted store has been set up within the
+// data path.
+func hasStoreKey(path string) bool {
+	_, err := os.Stat(filepath.Join(path, encryptedDir, encryptionFile))
+	return err == nil
+}
+
+// createStoreKey generates a new store key protected by the passphrase and
+// saves it within the data path, replacing any existing encrypted store.
+func createStoreKey(path, passphrase string) (*storeKey, error) {
+	dir := filepath.Join(path, encryptedDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed removing previous encrypted store: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed creating encrypted store: %w", err)
+	}
+	encryption := storeEncryption{
+		Version: encryptionVersion,
+		Salt:    make([]byte, 16),
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+	}
+	key := make([]byte, 32)
+	for _, b := range [][]byte{encryption.Salt, key} {
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			return nil, fmt.Errorf("failed generating key: %w", err)
+		}
+	}
+	wrapper, err := encryption.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if encryption.Key, err = seal(wrapper, key, encryptionFile); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(&encryption, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding store key: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, encryptionFile), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed saving store key: %w", err)
+	}
+	return newStoreKey(key)
+}
+
+// unlockStoreKey loads the store key saved within the data path, decrypting
+// it with the passphrase.
+func unlockStoreKey(path, passphrase string) (*storeKey, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, encryptedDir, encryptionFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading store key: %w", err)
+	}
+	var encryption storeEncryption
+	if err := json.Unmarshal(data, &encryption); err != nil {
+		return nil, fmt.Errorf("failed parsing store key: %w", err)
+	}
+	if encryption.Version < 1 || encryption.Version > encryptionVersion {
+		return nil, fmt.Errorf("unsupported store encryption version %d", encryption.Version)
+	}
+	wrapper, err := encryption.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := open(wrapper, encryption.Key, encryptionFile)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return newStoreKey(key)
+}
+
+// writeFileAtomic replaces the file at path with data, so that the file is
+// never left partially written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Flush the data to disk before the rename so that a power loss
+	// cannot leave an empty file in place of the previous one.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// encryptedFS is a grove filesystem that encrypts the contents of every
+// file. File names, which are node IDs, are not encrypted.
+type encryptedFS struct {
+	root string
+	key  *storeKey
+}
+
+var _ grove.FS = &encryptedFS{}
+
+func (e *encryptedFS) resolve(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+// Open decrypts the file at path. Directories are opened directly so that
+// they can be listed.
+func (e *encryptedFS) Open(path string) (grove.File, error) {
+	full := e.resolve(path)
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return os.Open(full)
+	}
+	data, err := ioutil.ReadFile(full)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(e.key.aead, data, filepath.Base(full))
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting %s: %w", path, err)
+	}
+	return &decryptedFile{name: full, Reader: bytes.NewReader(plaintext)}, nil
+}
+
+// Create returns a file whose contents are encrypted and written to path
+// when it is closed.
+func (e *encryptedFS) Create(path string) (grove.File, error) {
+	return &encryptingFile{name: e.resolve(path), key: e.key}, nil
+}
+
+// OpenFile supports reading and truncating writes, which is all that grove
+// requires.
+func (e *encryptedFS) OpenFile(path string, flag int, perm os.FileMode) (grove.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return e.Open(path)
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, fmt.Errorf("appending to encrypted file %s is unsupported", path)
+	}
+	return e.Create(path)
+}
+
+func (e *encryptedFS) Remove(path string) error {
+	return os.Remove(e.resolve(path))
+}
+
+// decryptedFile is a read-only file holding decrypted contents.
+type decryptedFile struct {
+	name string
+	*bytes.Reader
+}
+
+func (d *decryptedFile) Name() string {
+	return d.name
+}
+
+func (d *decryptedFile) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("%s is open read-only", d.name)
+}
+
+func (d *decryptedFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", d.name)
+}
+
+func (d *decryptedFile) Close() error {
+	return nil
+}
+
+// encryptingFile buffers the data written to it and saves it encrypted
+// with each write, so that grove, which ignores the error of Close, sees any
+// failure. Grove writes each node in a single call, so each node is only
+// encrypted and saved once.
+type encryptingFile struct {
+	name string
+	key  *storeKey
+	bytes.Buffer
+	// saved is set once the contents have been saved.
+	saved bool
+}
+
+func (e *encryptingFile) Name() string {
+	return e.name
+}
+
+func (e *encryptingFile) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("%s is open write-only", e.name)
+}
+
+func (e *encryptingFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", e.name)
+}
+
+// Write appends p to the contents and saves all of them encrypted. If they
+// cannot be saved, p is discarded.
+func (e *encryptingFile) Write(p []byte) (int, error) {
+	e.Buffer.Write(p)
+	if err := e.save(); err != nil {
+		e.Truncate(e.Len() - len(p))
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close saves the file if nothing was written to it.
+func (e *encryptingFile) Close() error {
+	if e.saved {
+		return nil
+	}
+	return e.save()
+}
+
+func (e *encryptingFile) save() error {
+	sealed, err := seal(e.key.aead, e.Bytes(), filepath.Base(e.name))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(e.name, sealed, 0600); err != nil {
+		return err
+	}
+	e.saved = true
+	return nil
+}
+
+func (a *arborService) StoreLocked() bool {
+	a.storeLock.Lock()
+	defer a.storeLock.Unlock()
+	return a.locked
+}
+
+func (a *arborService) UnlockStore(passphrase string) error {
+	path := a.SettingsService.DataPath()
+	a.storeLock.Lock()
+	if !a.locked {
+		a.storeLock.Unlock()
+		return nil
+	}
+	key, err := unlockStoreKey(path, passphrase)
+	if err != nil {
+		a.storeLock.Unlock()
+		return err
+	}
+	a.key = key
+	a.locked = false
+	a.storeBanner.Cancel()
+	backend := a.backend
+	s := a.grove.UnderlyingStore()
+	if backend == EncryptedBackend {
+		s, err = openStore(path, backend, key)
+		if err != nil {
+			a.storeErr = err
+			a.storeBanner = &MessageBanner{
+				Priority: Error,
+				Text:     "Sprig could not open its message store, so new messages will not be saved. Open the store recovery page in the settings to fix this.",
+			}
+			banner := a.storeBanner
+			a.storeLock.Unlock()
+			a.BannerService.Add(banner)
+			return fmt.Errorf("failed opening encrypted store: %w", err)
+		}
+		a.installStore(s, true)
+	}
+	a.storeLock.Unlock()
+
+	if backend == EncryptedBackend {
+		a.replayStore(s)
+	}
+	if to := preferredBackend(a.SettingsService); to != backend {
+		a.startMigration(s, to)
+	}
+	return nil
+}
+
+// EnableEncryption creates a new store key protected by the passphrase,
+// replacing any encrypted store left behind by an earlier attempt, and
+// migrates the nodes into an encrypted store.
+func (a *arborService) EnableEncryption(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase is empty")
+	}
+	a.storeLock.Lock()
+	switch {
+	case a.storeErr != nil || a.readOnly:
+		a.storeLock.Unlock()
+		return fmt.Errorf("the store is unavailable")
+	case a.backend == EncryptedBackend || a.locked:
+		a.storeLock.Unlock()
+		return fmt.Errorf("the store is already encrypted")
+	}
+	if a.cancelMigration != nil {
+		a.cancelMigration()
+		a.cancelMigration = nil
+	}
+	key, err := createStoreKey(a.SettingsService.DataPath(), passphrase)
+	if err != nil {
+		a.storeLock.Unlock()
+		return err
+	}
+	a.key = key
+	a.storeLock.Unlock()
+
+	a.SettingsService.SetEncryptStore(true)
+	if err := a.SettingsService.Persist(); err != nil {
+		return fmt.Errorf("failed saving settings: %w", err)
+	}
+	a.startMigration(a.grove.UnderlyingStore(), EncryptedBackend)
+	return nil
+}
+
+// DisableEncryption migrates the encrypted store into the preferred
+// plaintext backend. If the encrypted store is not in use yet, the
+// migration into it is abandoned instead.
+func (a *arborService) DisableEncryption() error {
+	a.storeLock.Lock()
+	switch {
+	case a.locked:
+		a.storeLock.Unlock()
+		return ErrStoreLocked
+	case a.storeErr != nil || a.readOnly:
+		a.storeLock.Unlock()
+		return fmt.Errorf("the store is unavailable")
+	}
+	if a.cancelMigration != nil {
+		a.cancelMigration()
+		a.cancelMigration = nil
+	}
+	// With the migration cancelled, the backend cannot change until the
+	// next one starts.
+	backend := a.backend
+	a.storeLock.Unlock()
+
+	a.SettingsService.SetEncryptStore(false)
+	if err := a.SettingsService.Persist(); err != nil {
+		return fmt.Errorf("failed saving settings: %w", err)
+	}
+	if backend == EncryptedBackend {
+		a.startMigration(a.grove.UnderlyingStore(), preferredBackend(a.SettingsService))
+	}
+	return nil
+}
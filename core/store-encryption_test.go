@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDataDir creates a temporary data directory that is removed when the
+// test ends.
+func newTestDataDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sprig-test")
+	if err != nil {
+		t.Fatalf("creating data directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	tree := newTestTree(t)
+	dir := newTestDataDir(t)
+	key, err := createStoreKey(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("creating store key: %v", err)
+	}
+	s, err := openStore(dir, EncryptedBackend, key)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	addTestNodes(t, s, tree.nodes()...)
+
+	key, err = unlockStoreKey(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("unlocking store key: %v", err)
+	}
+	reopened, err := openStore(dir, EncryptedBackend, key)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	for _, node := range tree.nodes() {
+		stored, present, err := reopened.Get(node.ID())
+		if err != nil || !present {
+			t.Fatalf("reading %s back: present %v, %v", node.ID(), present, err)
+		}
+		if !stored.Equals(node) {
+			t.Errorf("node %s differs after reopening the store", node.ID())
+		}
+	}
+
+	// The node contents must not be stored in the clear.
+	data, err := ioutil.ReadFile(filepath.Join(dir, encryptedDir, tree.reply.ID().String()))
+	if err != nil {
+		t.Fatalf("reading node file: %v", err)
+	}
+	plain, err := tree.reply.MarshalBinary()
+	if err != nil {
+		t.Fatalf("serializing reply: %v", err)
+	}
+	if string(data) == string(plain) {
+		t.Errorf("node file is not encrypted")
+	}
+}
+
+func TestEncryptedStoreWrongPassphrase(t *testing.T) {
+	dir := newTestDataDir(t)
+	if _, err := createStoreKey(dir, "passphrase"); err != nil {
+		t.Fatalf("creating store key: %v", err)
+	}
+	if _, err := unlockStoreKey(dir, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("unlocking with the wrong passphrase returned %v, expected ErrWrongPassphrase", err)
+	}
+}
+
+func TestEncryptedStoreWriteError(t *testing.T) {
+	tree := newTestTree(t)
+	dir := newTestDataDir(t)
+	key, err := createStoreKey(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("creating store key: %v", err)
+	}
+	s, err := openStore(dir, EncryptedBackend, key)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	// Node files cannot be written once their directory is gone.
+	if err := os.RemoveAll(filepath.Join(dir, encryptedDir)); err != nil {
+		t.Fatalf("removing store directory: %v", err)
+	}
+	if err := s.Add(tree.identity); err == nil {
+		t.Errorf("adding a node that could not be written succeeded")
+	}
+}
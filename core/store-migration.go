@@ -0,0 +1,343 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/grove"
+	"git.sr.ht/~whereswaldon/forest-go/orchard"
+)
+
+// StoreBackend identifies an on-disk node store implementation.
+type StoreBackend string
+
+const (
+	// GroveBackend stores each node in its own file.
+	GroveBackend StoreBackend = "grove"
+	// OrchardBackend stores all nodes in a single database file.
+	OrchardBackend StoreBackend = "orchard"
+	// EncryptedBackend stores each node in its own encrypted file.
+	EncryptedBackend StoreBackend = "encrypted"
+)
+
+func (b StoreBackend) String() string {
+	switch b {
+	case GroveBackend:
+		return "Grove"
+	case OrchardBackend:
+		return "Orchard"
+	case EncryptedBackend:
+		return "Encrypted Grove"
+	default:
+		return string(b)
+	}
+}
+
+// preferredBackend returns the backend that the user has asked to use.
+func preferredBackend(settings SettingsService) StoreBackend {
+	return backendFor(settings.EncryptStore(), settings.UseOrchardStore())
+}
+
+// backendFor returns the backend selected by the store settings.
+func backendFor(encrypt, orchard bool) StoreBackend {
+	if encrypt {
+		return EncryptedBackend
+	}
+	if orchard {
+		return OrchardBackend
+	}
+	return GroveBackend
+}
+
+// orchardFile is the name of the Orchard database within the data path.
+const orchardFile = "orchard.db"
+
+// openStore opens the given backend within the data path. The key is only
+// required by the encrypted backend.
+func openStore(path string, backend StoreBackend, key *storeKey) (forest.Store, error) {
+	switch backend {
+	case OrchardBackend:
+		o, err := orchard.Open(filepath.Join(path, orchardFile))
+		if err != nil {
+			return nil, fmt.Errorf("opening Orchard store: %v", err)
+		}
+		return o, nil
+	case GroveBackend:
+		g, err := grove.New(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening Grove store: %v", err)
+		}
+		g.SetCorruptNodeHandler(func(id string) {
+			log.Printf("Grove: corrupt node %s", id)
+		})
+		return g, nil
+	case EncryptedBackend:
+		if key == nil {
+			return nil, ErrStoreLocked
+		}
+		dir := filepath.Join(path, encryptedDir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("opening encrypted store: %v", err)
+		}
+		g, err := grove.NewWithFS(&encryptedFS{root: dir, key: key})
+		if err != nil {
+			return nil, fmt.Errorf("opening encrypted store: %v", err)
+		}
+		g.SetCorruptNodeHandler(func(id string) {
+			log.Printf("encrypted Grove: corrupt node %s", id)
+		})
+		return g, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// removeStore deletes the data of the given backend from the data path. It
+// must not be called on a backend that is open.
+func removeStore(path string, backend StoreBackend) error {
+	switch backend {
+	case OrchardBackend:
+		if err := os.Remove(filepath.Join(path, orchardFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing Orchard store: %w", err)
+		}
+		return nil
+	case GroveBackend:
+		// Grove only treats files named after node IDs as nodes, so only
+		// those are removed.
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("listing Grove store: %w", err)
+		}
+		for _, info := range infos {
+			var id fields.QualifiedHash
+			if !info.Mode().IsRegular() || id.UnmarshalText([]byte(info.Name())) != nil {
+				continue
+			}
+			if err := os.Remove(filepath.Join(path, info.Name())); err != nil {
+				return fmt.Errorf("removing Grove node %s: %w", info.Name(), err)
+			}
+		}
+		return nil
+	case EncryptedBackend:
+		if err := os.RemoveAll(filepath.Join(path, encryptedDir)); err != nil {
+			return fmt.Errorf("removing encrypted store: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// migrationProgressInterval is how many nodes are copied between updates of
+// the migration banner.
+const migrationProgressInterval = 250
+
+// migrationSink receives the nodes copied out of the old store, verifying
+// each of them before and after adding it to the new one. It also mirrors the
+// changes made to the live store while the copy runs.
+type migrationSink struct {
+	forest.Store
+	// source is the store being migrated. Nodes that are no longer in it
+	// have been removed since the copy began and are skipped.
+	source forest.Store
+	ctx    context.Context
+	// progress is invoked with the number of nodes copied so far.
+	progress func(copied int)
+
+	// lock serializes additions with mirrored removals, so that a removed
+	// node cannot be copied in after its removal was mirrored.
+	lock            sync.Mutex
+	copied, skipped int
+}
+
+var _ storeMirror = &migrationSink{}
+
+// Add validates the node, adds it to the destination store, and checks that
+// the destination returns an identical node.
+func (m *migrationSink) Add(node forest.Node) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := node.ValidateInternal(); err != nil {
+		log.Printf("migration: skipping invalid node %s: %v", node.ID(), err)
+		m.skipped++
+		return nil
+	}
+	if _, present, err := m.source.Get(node.ID()); err != nil {
+		return fmt.Errorf("checking node %s: %w", node.ID(), err)
+	} else if !present {
+		return nil
+	}
+	if err := m.Store.Add(node); err != nil {
+		return fmt.Errorf("adding node %s: %w", node.ID(), err)
+	}
+	stored, present, err := m.Store.Get(node.ID())
+	if err != nil {
+		return fmt.Errorf("reading back node %s: %w", node.ID(), err)
+	}
+	if !present || !stored.Equals(node) {
+		return fmt.Errorf("node %s differs after migration", node.ID())
+	}
+	m.copied++
+	if m.progress != nil && m.copied%migrationProgressInterval == 0 {
+		m.progress(m.copied)
+	}
+	return nil
+}
+
+// Remove removes the nodes that were removed from the live store from the
+// destination store, if they were copied already.
+func (m *migrationSink) Remove(ids []*fields.QualifiedHash) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, id := range ids {
+		// Removing a node removes its descendants, which may be later
+		// in the list.
+		if _, present, err := m.Store.Get(id); err != nil {
+			return fmt.Errorf("checking node %s: %w", id, err)
+		} else if !present {
+			continue
+		}
+		if err := m.Store.RemoveSubtree(id); err != nil {
+			return fmt.Errorf("removing node %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// migrateStore copies every node from source, which must be the store
+// backing the live store, into the destination backend. Changes made to the
+// live store while the copy runs are mirrored into the destination. Once the
+// copy completes, the destination replaces the source in the live store, the
+// settings record it as the active backend, and the source is removed.
+func (a *arborService) migrateStore(ctx context.Context, source forest.Store, to StoreBackend) {
+	copier, ok := source.(forest.Copiable)
+	if !ok {
+		log.Printf("migration: %T cannot be migrated", source)
+		return
+	}
+	banner := &LoadingBanner{
+		Priority: Info,
+		Text:     fmt.Sprintf("Migrating messages to %s...", to),
+	}
+	a.BannerService.Add(banner)
+	fail := func(err error) {
+		banner.Cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("migration to %s failed: %v", to, err)
+		a.BannerService.Add(&MessageBanner{
+			Priority: Error,
+			Text:     fmt.Sprintf("Migrating messages to %s failed; the previous store is still in use: %v", to, err),
+		})
+	}
+	path := a.SettingsService.DataPath()
+	a.storeLock.Lock()
+	key := a.key
+	a.storeLock.Unlock()
+	dest, err := openStore(path, to, key)
+	if err != nil {
+		fail(err)
+		return
+	}
+	switched := false
+	defer func() {
+		if closer, ok := dest.(io.Closer); ok && !switched {
+			closer.Close()
+		}
+	}()
+
+	sink := &migrationSink{
+		Store:  dest,
+		source: source,
+		ctx:    ctx,
+		progress: func(copied int) {
+			banner.Cancel()
+			banner = &LoadingBanner{
+				Priority: Info,
+				Text:     fmt.Sprintf("Migrating messages to %s (%d copied)...", to, copied),
+			}
+			a.BannerService.Add(banner)
+		},
+	}
+	a.grove.SetMirror(sink)
+	defer func() {
+		if !switched {
+			a.grove.SetMirror(nil)
+		}
+	}()
+	if err := copier.CopyInto(sink); err != nil {
+		fail(err)
+		return
+	}
+
+	from, err := a.switchStore(ctx, source, dest, to)
+	if err != nil {
+		fail(err)
+		return
+	}
+	switched = true
+	if closer, ok := source.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("migration: failed closing %s store: %v", from, err)
+		}
+	}
+	if err := removeStore(path, from); err != nil {
+		// The retired store is removed on the next start instead.
+		log.Printf("migration: failed removing %s store: %v", from, err)
+	} else {
+		a.SettingsService.ClearRetiredStoreBackend()
+		if err := a.SettingsService.Persist(); err != nil {
+			log.Printf("migration: failed saving settings: %v", err)
+		}
+	}
+
+	banner.Cancel()
+	sink.lock.Lock()
+	copied, skipped := sink.copied, sink.skipped
+	sink.lock.Unlock()
+	text := fmt.Sprintf("Migrated %d messages to %s.", copied, to)
+	if skipped > 0 {
+		text = fmt.Sprintf("Migrated %d messages to %s, skipping %d invalid ones.", copied, to, skipped)
+	}
+	a.BannerService.Add(&MessageBanner{
+		Priority: Info,
+		Text:     text,
+	})
+}
+
+// switchStore makes dest the store backing the live store in place of
+// source, recording the new backend in the settings, and returns the backend
+// of source. It fails if the migration was cancelled or source no longer
+// backs the live store.
+func (a *arborService) switchStore(ctx context.Context, source, dest forest.Store, to StoreBackend) (StoreBackend, error) {
+	a.storeLock.Lock()
+	defer a.storeLock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if a.grove.UnderlyingStore() != source {
+		return "", fmt.Errorf("the store was replaced during the migration")
+	}
+	from := a.backend
+	a.SettingsService.SetStoreBackend(to)
+	if err := a.SettingsService.Persist(); err != nil {
+		a.SettingsService.SetStoreBackend(from)
+		a.SettingsService.ClearRetiredStoreBackend()
+		return "", fmt.Errorf("recording migration: %w", err)
+	}
+	a.grove.Replace(dest)
+	a.backend = to
+	return from, nil
+}
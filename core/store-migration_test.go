@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// testTree is a community holding a conversation with one reply to it.
+type testTree struct {
+	identity     *forest.Identity
+	builder      *forest.Builder
+	community    *forest.Community
+	conversation *forest.Reply
+	reply        *forest.Reply
+}
+
+// newTestIdentity generates an identity and its private key.
+func newTestIdentity(t *testing.T, name string) (*forest.Identity, *openpgp.Entity) {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", "", &packet.Config{})
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	signer, err := forest.NewNativeSigner(entity)
+	if err != nil {
+		t.Fatalf("wrapping key: %v", err)
+	}
+	identity, err := forest.NewIdentity(signer, name, []byte{})
+	if err != nil {
+		t.Fatalf("creating identity: %v", err)
+	}
+	return identity, entity
+}
+
+// newTestAuthor generates an identity and a builder writing as it.
+func newTestAuthor(t *testing.T, name string) (*forest.Identity, *forest.Builder) {
+	t.Helper()
+	identity, entity := newTestIdentity(t, name)
+	signer, err := forest.NewNativeSigner(entity)
+	if err != nil {
+		t.Fatalf("wrapping key: %v", err)
+	}
+	return identity, forest.As(identity, signer)
+}
+
+// newTestTree generates an identity and a small tree of nodes written by it.
+func newTestTree(t *testing.T) *testTree {
+	t.Helper()
+	identity, builder := newTestAuthor(t, "test")
+	tree := &testTree{identity: identity, builder: builder}
+	var err error
+	if tree.community, err = tree.builder.NewCommunity("community", []byte{}); err != nil {
+		t.Fatalf("creating community: %v", err)
+	}
+	if tree.conversation, err = tree.builder.NewReply(tree.community, "conversation", []byte{}); err != nil {
+		t.Fatalf("creating conversation: %v", err)
+	}
+	if tree.reply, err = tree.builder.NewReply(tree.conversation, "reply", []byte{}); err != nil {
+		t.Fatalf("creating reply: %v", err)
+	}
+	return tree
+}
+
+// nodes returns every node of the tree, parents first.
+func (tree *testTree) nodes() []forest.Node {
+	return []forest.Node{tree.identity, tree.community, tree.conversation, tree.reply}
+}
+
+func TestMigrationMirrorsChanges(t *testing.T) {
+	tree := newTestTree(t)
+	source := store.NewMemoryStore()
+	live := newSwappableStore(source)
+	for _, node := range tree.nodes()[:3] {
+		if err := live.Add(node); err != nil {
+			t.Fatalf("adding %s: %v", node.ID(), err)
+		}
+	}
+	dest := store.NewMemoryStore()
+	sink := &migrationSink{Store: dest, source: source, ctx: context.Background()}
+	live.SetMirror(sink)
+
+	// The identity is copied before the changes, the rest after them.
+	if err := sink.Add(tree.identity); err != nil {
+		t.Fatalf("copying identity: %v", err)
+	}
+	if err := live.Add(tree.reply); err != nil {
+		t.Fatalf("adding reply: %v", err)
+	}
+	if err := live.RemoveSubtree(tree.conversation.ID()); err != nil {
+		t.Fatalf("removing conversation: %v", err)
+	}
+	for _, node := range []forest.Node{tree.community, tree.conversation, tree.reply} {
+		if err := sink.Add(node); err != nil {
+			t.Fatalf("copying %s: %v", node.ID(), err)
+		}
+	}
+
+	for _, c := range []struct {
+		node    forest.Node
+		present bool
+	}{
+		{tree.identity, true},
+		{tree.community, true},
+		{tree.conversation, false},
+		{tree.reply, false},
+	} {
+		if _, present, _ := dest.Get(c.node.ID()); present != c.present {
+			t.Errorf("node %s present %v in the new store, expected %v", c.node.ID(), present, c.present)
+		}
+	}
+
+	live.Replace(dest)
+	if live.mirror != nil {
+		t.Errorf("mirror was kept after replacing the store")
+	}
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/grove"
+	"git.sr.ht/~whereswaldon/forest-go/orchard"
+)
+
+// StoreRecovery is a strategy for recovering from a store that failed to
+// open.
+type StoreRecovery uint8
+
+const (
+	// RecoverByRetrying attempts to open the store again.
+	RecoverByRetrying StoreRecovery = iota
+	// RecoverReadOnly opens the existing store without allowing changes to
+	// it. New nodes are rejected.
+	RecoverReadOnly
+	// RecoverWithFreshStore moves the existing store aside and creates an
+	// empty one in its place.
+	RecoverWithFreshStore
+)
+
+func (r StoreRecovery) String() string {
+	switch r {
+	case RecoverByRetrying:
+		return "retry"
+	case RecoverReadOnly:
+		return "open read-only"
+	case RecoverWithFreshStore:
+		return "start fresh"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrReadOnlyStore is returned when adding to or removing from a store that
+// was opened read-only.
+var ErrReadOnlyStore = errors.New("store is open read-only")
+
+// readOnlyStore rejects all changes to the wrapped store.
+type readOnlyStore struct {
+	forest.Store
+	// snapshot is a temporary copy of the store's data that is deleted when
+	// the store is closed, if any.
+	snapshot string
+}
+
+var _ io.Closer = &readOnlyStore{}
+
+func (r *readOnlyStore) Add(forest.Node) error {
+	return ErrReadOnlyStore
+}
+
+func (r *readOnlyStore) RemoveSubtree(*fields.QualifiedHash) error {
+	return ErrReadOnlyStore
+}
+
+func (r *readOnlyStore) Close() error {
+	var err error
+	if closer, ok := r.Store.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if r.snapshot != "" {
+		os.Remove(r.snapshot)
+	}
+	return err
+}
+
+// openReadOnlyStore opens the given backend within the data path without
+// modifying it. Orchard requires write access to its database, so a
+// temporary copy of it is opened instead.
+func openReadOnlyStore(path string, backend StoreBackend, key *storeKey) (forest.Store, error) {
+	switch backend {
+	case OrchardBackend:
+		snapshot, err := snapshotFile(filepath.Join(path, orchardFile))
+		if err != nil {
+			return nil, fmt.Errorf("copying Orchard store: %w", err)
+		}
+		o, err := orchard.Open(snapshot)
+		if err != nil {
+			os.Remove(snapshot)
+			return nil, fmt.Errorf("opening Orchard store: %v", err)
+		}
+		return &readOnlyStore{Store: o, snapshot: snapshot}, nil
+	case GroveBackend:
+		g, err := grove.New(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening Grove store: %v", err)
+		}
+		return &readOnlyStore{Store: g}, nil
+	case EncryptedBackend:
+		g, err := openStore(path, backend, key)
+		if err != nil {
+			return nil, err
+		}
+		return &readOnlyStore{Store: g}, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// snapshotFile copies the file at path to a new temporary file and returns
+// the name of the copy.
+func snapshotFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := ioutil.TempFile("", "sprig-store-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// moveStoreAside renames the data of the given backend so that a new,
+// empty store can be created in its place. It returns the new location of
+// the old data.
+func moveStoreAside(path string, backend StoreBackend) (string, error) {
+	suffix := "broken-" + time.Now().Format("20060102-150405")
+	switch backend {
+	case OrchardBackend:
+		target := filepath.Join(path, orchardFile+"."+suffix)
+		if err := os.Rename(filepath.Join(path, orchardFile), target); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("moving Orchard store aside: %w", err)
+		}
+		return target, nil
+	case GroveBackend:
+		target := filepath.Join(path, "grove-"+suffix)
+		if err := moveNodeFiles(path, target); err != nil {
+			return "", fmt.Errorf("moving Grove store aside: %w", err)
+		}
+		return target, nil
+	case EncryptedBackend:
+		// The key file stays behind so that the fresh store uses the same
+		// passphrase and the moved files remain readable with it.
+		target := filepath.Join(path, "encrypted-"+suffix)
+		if err := moveNodeFiles(filepath.Join(path, encryptedDir), target); err != nil {
+			return "", fmt.Errorf("moving encrypted store aside: %w", err)
+		}
+		return target, nil
+	default:
+		return "", fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// moveNodeFiles moves the files named after node IDs in dir into a new
+// directory at target.
+func moveNodeFiles(dir, target string) error {
+	if err := os.Mkdir(target, 0770); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("listing nodes: %w", err)
+	}
+	for _, info := range infos {
+		var id fields.QualifiedHash
+		if !info.Mode().IsRegular() || id.UnmarshalText([]byte(info.Name())) != nil {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, info.Name()), filepath.Join(target, info.Name())); err != nil {
+			return fmt.Errorf("moving node %s: %w", info.Name(), err)
+		}
+	}
+	return nil
+}
+
+// replayLimit bounds how many nodes of each type are replayed to
+// subscribers after the store is recovered.
+const replayLimit = 2048
+
+// RecoverStore replaces the temporary in-memory store used after a failed
+// open with the configured store, according to the strategy. Nodes that
+// were added to the temporary store are copied into the recovered one unless
+// it is read-only.
+func (a *arborService) RecoverStore(strategy StoreRecovery) error {
+	a.storeLock.Lock()
+	if a.storeErr == nil {
+		a.storeLock.Unlock()
+		return nil
+	}
+	var (
+		path = a.SettingsService.DataPath()
+		s    forest.Store
+		err  error
+	)
+	switch strategy {
+	case RecoverByRetrying:
+		s, err = openStore(path, a.backend, a.key)
+	case RecoverReadOnly:
+		s, err = openReadOnlyStore(path, a.backend, a.key)
+	case RecoverWithFreshStore:
+		var moved string
+		if moved, err = moveStoreAside(path, a.backend); err == nil {
+			log.Printf("moved %s store aside to %s", a.backend, moved)
+			s, err = openStore(path, a.backend, a.key)
+		}
+	default:
+		err = fmt.Errorf("unknown recovery strategy %d", strategy)
+	}
+	if err != nil {
+		a.storeLock.Unlock()
+		return fmt.Errorf("failed to %s: %w", strategy, err)
+	}
+	a.installStore(s, strategy != RecoverReadOnly)
+	a.storeBanner.Cancel()
+	if strategy == RecoverReadOnly {
+		a.storeBanner = &MessageBanner{
+			Priority: Warn,
+			Text:     "The message store is open read-only. New messages will not be saved.",
+		}
+	} else {
+		a.storeErr = nil
+	}
+	a.readOnly = strategy == RecoverReadOnly
+	banner, readOnly, backend := a.storeBanner, a.readOnly, a.backend
+	a.storeLock.Unlock()
+
+	// Adding a banner can wait on the UI, which must not be blocked on
+	// storeLock in the meantime.
+	if readOnly {
+		a.BannerService.Add(banner)
+	}
+
+	a.replayStore(s)
+	if to := preferredBackend(a.SettingsService); !readOnly && to != backend {
+		a.startMigration(s, to)
+	}
+	return nil
+}
+
+// installStore replaces the temporary in-memory store with s, closing the
+// temporary store. If copyInterim is set, the nodes added to the temporary
+// store are copied into s first. The caller must hold storeLock.
+func (a *arborService) installStore(s forest.Store, copyInterim bool) {
+	if copyInterim {
+		if interim, ok := a.grove.UnderlyingStore().(forest.Copiable); ok {
+			if err := interim.CopyInto(s); err != nil {
+				log.Printf("failed copying unsaved nodes into recovered store: %v", err)
+			}
+		}
+	}
+	previous := a.grove.Replace(s)
+	if closer, ok := previous.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// replayStore informs the lists showing the store, such as the community
+// list, of the recent nodes in s after it replaced the temporary store.
+// Other subscribers, such as relays and notifications, are not informed, as
+// the nodes are not new.
+func (a *arborService) replayStore(s forest.Store) {
+	log.Printf("Store: %T\n", s)
+	for _, nodeType := range []fields.NodeType{fields.NodeTypeCommunity, fields.NodeTypeReply} {
+		nodes, err := s.Recent(nodeType, replayLimit)
+		if err != nil {
+			log.Printf("failed loading recovered nodes: %v", err)
+		}
+		for i := len(nodes) - 1; i >= 0; i-- {
+			a.grove.Replay(nodes[i])
+		}
+	}
+}
+
+func (a *arborService) StoreError() error {
+	a.storeLock.Lock()
+	defer a.storeLock.Unlock()
+	return a.storeErr
+}
+
+func (a *arborService) StoreReadOnly() bool {
+	a.storeLock.Lock()
+	defer a.storeLock.Unlock()
+	return a.readOnly
+}
@@ -0,0 +1,316 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+// swappableStore is an ExtendedStore whose underlying store can be replaced
+// while it is in use. It manages its own subscribers so that they keep
+// receiving nodes across replacements, and reports the nodes removed from it
+// to removal subscribers. Nodes that are replayed are only delivered to
+// replay subscribers. It is safe for concurrent use.
+type swappableStore struct {
+	// lock is held for reading by every use of the archive, so that
+	// nothing uses the previous archive once Replace returns.
+	lock    sync.RWMutex
+	archive *store.Archive
+	// writes is held by every write, and by PauseWrites to keep the store
+	// unchanged. It is acquired before lock.
+	writes sync.Mutex
+	// mirror receives the changes made to the underlying store, if set.
+	mirror storeMirror
+
+	subscriberLock   sync.Mutex
+	nextSubscription store.Subscription
+	subscribers      map[store.Subscription]func(forest.Node)
+	replays          map[store.Subscription]func(forest.Node)
+	removals         map[store.Subscription]func([]*fields.QualifiedHash)
+}
+
+// storeMirror receives a copy of the changes made to a swappableStore, such
+// as the store that its nodes are being migrated into.
+type storeMirror interface {
+	// Add is invoked with each node added to the store.
+	Add(forest.Node) error
+	// Remove is invoked with the IDs of the nodes removed by each call to
+	// RemoveSubtree.
+	Remove(ids []*fields.QualifiedHash) error
+}
+
+var _ store.ExtendedStore = &swappableStore{}
+var _ ds.RemovalNotifier = &swappableStore{}
+var _ ds.ReplayNotifier = &swappableStore{}
+
+func newSwappableStore(s forest.Store) *swappableStore {
+	return &swappableStore{
+		archive:          store.NewArchive(s),
+		nextSubscription: 1,
+		subscribers:      make(map[store.Subscription]func(forest.Node)),
+		replays:          make(map[store.Subscription]func(forest.Node)),
+		removals:         make(map[store.Subscription]func([]*fields.QualifiedHash)),
+	}
+}
+
+// UnderlyingStore returns the store currently backing the swappableStore.
+func (s *swappableStore) UnderlyingStore() forest.Store {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.UnderlyingStore()
+}
+
+// Replace makes replacement the underlying store and returns the previous
+// one, removing the mirror if one was set. Calls using the previous store
+// have finished by the time it returns, so the caller may close it. Existing
+// subscribers are not notified of the nodes in replacement; use Replay for
+// that.
+func (s *swappableStore) Replace(replacement forest.Store) forest.Store {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous := s.archive
+	s.archive = store.NewArchive(replacement)
+	s.mirror = nil
+	previous.Destroy()
+	return previous.UnderlyingStore()
+}
+
+// PauseWrites blocks writes to the store until resume is called. Reads and
+// Replace are still allowed in the meantime. Calling resume more than once
+// has no further effect.
+func (s *swappableStore) PauseWrites() (resume func()) {
+	s.writes.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(s.writes.Unlock)
+	}
+}
+
+// SetMirror makes mirror receive every change made to the underlying store
+// from now on, until it is replaced or SetMirror is called again. A nil
+// mirror removes the current one.
+func (s *swappableStore) SetMirror(mirror storeMirror) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mirror = mirror
+}
+
+// Replay notifies the replay subscribers of the given nodes, which are
+// already stored, so that the lists showing the store include them.
+func (s *swappableStore) Replay(nodes ...forest.Node) {
+	s.subscriberLock.Lock()
+	handlers := make([]func(forest.Node), 0, len(s.replays))
+	for _, handler := range s.replays {
+		handlers = append(handlers, handler)
+	}
+	s.subscriberLock.Unlock()
+	for _, node := range nodes {
+		for _, handler := range handlers {
+			handler(node)
+		}
+	}
+}
+
+func (s *swappableStore) SubscribeToNewMessages(handler func(forest.Node)) store.Subscription {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	id := s.nextSubscription
+	s.nextSubscription++
+	s.subscribers[id] = handler
+	return id
+}
+
+func (s *swappableStore) UnsubscribeToNewMessages(id store.Subscription) {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	delete(s.subscribers, id)
+}
+
+// SubscribeToReplays registers handler to be invoked with each node passed
+// to Replay.
+func (s *swappableStore) SubscribeToReplays(handler func(forest.Node)) store.Subscription {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	id := s.nextSubscription
+	s.nextSubscription++
+	s.replays[id] = handler
+	return id
+}
+
+func (s *swappableStore) UnsubscribeToReplays(id store.Subscription) {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	delete(s.replays, id)
+}
+
+// SubscribeToRemovals registers handler to be invoked with the IDs of the
+// nodes removed by each call to RemoveSubtree.
+func (s *swappableStore) SubscribeToRemovals(handler func([]*fields.QualifiedHash)) store.Subscription {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	id := s.nextSubscription
+	s.nextSubscription++
+	s.removals[id] = handler
+	return id
+}
+
+func (s *swappableStore) UnsubscribeToRemovals(id store.Subscription) {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
+	delete(s.removals, id)
+}
+
+// notifyRemoved invokes every removal subscriber with the IDs.
+func (s *swappableStore) notifyRemoved(ids []*fields.QualifiedHash) {
+	s.subscriberLock.Lock()
+	handlers := make([]func([]*fields.QualifiedHash), 0, len(s.removals))
+	for _, handler := range s.removals {
+		handlers = append(handlers, handler)
+	}
+	s.subscriberLock.Unlock()
+	for _, handler := range handlers {
+		handler(ids)
+	}
+}
+
+// notify invokes every subscriber other than ignore with the node.
+func (s *swappableStore) notify(node forest.Node, ignore store.Subscription) {
+	s.subscriberLock.Lock()
+	handlers := make([]func(forest.Node), 0, len(s.subscribers))
+	for id, handler := range s.subscribers {
+		if id != ignore {
+			handlers = append(handlers, handler)
+		}
+	}
+	s.subscriberLock.Unlock()
+	for _, handler := range handlers {
+		handler(node)
+	}
+}
+
+func (s *swappableStore) Add(node forest.Node) error {
+	return s.AddAs(node, 0)
+}
+
+// AddAs adds the node to the underlying store and notifies every subscriber
+// other than addedBy, unless the node was already present.
+func (s *swappableStore) AddAs(node forest.Node, addedBy store.Subscription) error {
+	added, err := func() (bool, error) {
+		s.writes.Lock()
+		defer s.writes.Unlock()
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		if _, has, _ := s.archive.Get(node.ID()); has {
+			return false, nil
+		}
+		if err := s.archive.Add(node); err != nil {
+			return false, err
+		}
+		if s.mirror != nil {
+			if err := s.mirror.Add(node); err != nil {
+				log.Printf("failed mirroring node %s: %v", node.ID(), err)
+			}
+		}
+		return true, nil
+	}()
+	if err != nil || !added {
+		return err
+	}
+	s.notify(node, addedBy)
+	return nil
+}
+
+func (s *swappableStore) Get(id *fields.QualifiedHash) (forest.Node, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.Get(id)
+}
+
+func (s *swappableStore) GetIdentity(id *fields.QualifiedHash) (forest.Node, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.GetIdentity(id)
+}
+
+func (s *swappableStore) GetCommunity(id *fields.QualifiedHash) (forest.Node, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.GetCommunity(id)
+}
+
+func (s *swappableStore) GetConversation(communityID, conversationID *fields.QualifiedHash) (forest.Node, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.GetConversation(communityID, conversationID)
+}
+
+func (s *swappableStore) GetReply(communityID, conversationID, replyID *fields.QualifiedHash) (forest.Node, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.GetReply(communityID, conversationID, replyID)
+}
+
+func (s *swappableStore) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.Children(id)
+}
+
+func (s *swappableStore) Recent(nodeType fields.NodeType, quantity int) ([]forest.Node, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.Recent(nodeType, quantity)
+}
+
+// RemoveSubtree removes the node and its descendants from the underlying
+// store and notifies the removal subscribers of their IDs.
+func (s *swappableStore) RemoveSubtree(id *fields.QualifiedHash) error {
+	removed, err := func() ([]*fields.QualifiedHash, error) {
+		s.writes.Lock()
+		defer s.writes.Unlock()
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		descendants, err := s.archive.DescendantsOf(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing descendants of %s: %w", id, err)
+		}
+		if err := s.archive.RemoveSubtree(id); err != nil {
+			return nil, err
+		}
+		removed := append(descendants, id)
+		if s.mirror != nil {
+			if err := s.mirror.Remove(removed); err != nil {
+				log.Printf("failed mirroring removal of %s: %v", id, err)
+			}
+		}
+		return removed, nil
+	}()
+	if err != nil {
+		return err
+	}
+	s.notifyRemoved(removed)
+	return nil
+}
+
+func (s *swappableStore) AncestryOf(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.AncestryOf(id)
+}
+
+func (s *swappableStore) DescendantsOf(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.DescendantsOf(id)
+}
+
+func (s *swappableStore) LeavesOf(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.archive.LeavesOf(id)
+}
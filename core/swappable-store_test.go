@@ -0,0 +1,88 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+)
+
+func TestReplayReachesOnlyReplaySubscribers(t *testing.T) {
+	tree := newTestTree(t)
+	s := newSwappableStore(store.NewMemoryStore())
+	var added, replayed []forest.Node
+	s.SubscribeToNewMessages(func(node forest.Node) {
+		added = append(added, node)
+	})
+	s.SubscribeToReplays(func(node forest.Node) {
+		replayed = append(replayed, node)
+	})
+
+	if err := s.Add(tree.community); err != nil {
+		t.Fatalf("adding community: %v", err)
+	}
+	s.Replay(tree.conversation, tree.reply)
+	if len(added) != 1 || !added[0].Equals(tree.community) {
+		t.Errorf("new message subscriber received %d nodes, expected only the community", len(added))
+	}
+	if len(replayed) != 2 || !replayed[0].Equals(tree.conversation) || !replayed[1].Equals(tree.reply) {
+		t.Errorf("replay subscriber received %d nodes, expected the replayed ones", len(replayed))
+	}
+}
+
+func TestReplaceKeepsSubscribers(t *testing.T) {
+	tree := newTestTree(t)
+	first := store.NewMemoryStore()
+	s := newSwappableStore(first)
+	var added []forest.Node
+	s.SubscribeToNewMessages(func(node forest.Node) {
+		added = append(added, node)
+	})
+	if err := s.Add(tree.community); err != nil {
+		t.Fatalf("adding community: %v", err)
+	}
+
+	second := store.NewMemoryStore()
+	if previous := s.Replace(second); previous != first {
+		t.Fatalf("Replace returned %v, expected the first store", previous)
+	}
+	if _, present, _ := s.Get(tree.community.ID()); present {
+		t.Errorf("community found after replacing the store")
+	}
+	if err := s.Add(tree.conversation); err != nil {
+		t.Fatalf("adding conversation: %v", err)
+	}
+	if _, present, _ := second.Get(tree.conversation.ID()); !present {
+		t.Errorf("conversation was not added to the replacement store")
+	}
+	if len(added) != 2 {
+		t.Errorf("subscriber received %d nodes, expected 2", len(added))
+	}
+}
+
+func TestPauseWrites(t *testing.T) {
+	tree := newTestTree(t)
+	s := newSwappableStore(store.NewMemoryStore())
+	resume := s.PauseWrites()
+	added := make(chan error, 1)
+	go func() {
+		added <- s.Add(tree.community)
+	}()
+	select {
+	case <-added:
+		t.Fatalf("node was added while writes were paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, _, err := s.Get(tree.community.ID()); err != nil {
+		t.Errorf("reading while writes were paused: %v", err)
+	}
+	resume()
+	resume()
+	if err := <-added; err != nil {
+		t.Fatalf("adding community: %v", err)
+	}
+	if _, present, _ := s.Get(tree.community.ID()); !present {
+		t.Errorf("community missing after resuming writes")
+	}
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"git.sr.ht/~whereswaldon/forest-go/store"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+)
+
+// taskGroup tracks the background goroutines of the application services so
+// that shutdown can wait for them to exit. It is safe for concurrent use.
+type taskGroup struct {
+	ctx context.Context
+	// lock orders calls to wg.Add before wg.Wait once ctx is cancelled.
+	lock sync.Mutex
+	wg   sync.WaitGroup
+}
+
+// newTaskGroup creates a taskGroup whose tasks are stopped when ctx is
+// cancelled.
+func newTaskGroup(ctx context.Context) *taskGroup {
+	return &taskGroup{ctx: ctx}
+}
+
+// Context returns the context that is cancelled when tasks should stop.
+func (t *taskGroup) Context() context.Context {
+	return t.ctx
+}
+
+// Go runs task on a new goroutine. Tasks must return promptly once the
+// provided context is cancelled. Go does nothing if the context has already
+// been cancelled.
+func (t *taskGroup) Go(task func(ctx context.Context)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.ctx.Err() != nil {
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		task(t.ctx)
+	}()
+}
+
+// Subscribe registers handler to be invoked with each node added to s until
+// the context is cancelled. Like any store subscriber, the handler must not
+// block.
+func (t *taskGroup) Subscribe(s store.ExtendedStore, handler func(forest.Node)) {
+	id := s.SubscribeToNewMessages(handler)
+	t.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		s.UnsubscribeToNewMessages(id)
+	})
+	if t.ctx.Err() != nil {
+		s.UnsubscribeToNewMessages(id)
+	}
+}
+
+// SubscribeToReplays registers handler to be invoked with each node replayed
+// by s until the context is cancelled. It does nothing if s does not replay
+// nodes. The handler must not block.
+func (t *taskGroup) SubscribeToReplays(s store.ExtendedStore, handler func(forest.Node)) {
+	notifier, ok := s.(ds.ReplayNotifier)
+	if !ok {
+		return
+	}
+	id := notifier.SubscribeToReplays(handler)
+	t.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		notifier.UnsubscribeToReplays(id)
+	})
+	if t.ctx.Err() != nil {
+		notifier.UnsubscribeToReplays(id)
+	}
+}
+
+// SubscribeToRemovals registers handler to be invoked with the IDs of the
+// nodes removed from s until the context is cancelled. It does nothing if s
+// does not report removals. The handler must not block.
+func (t *taskGroup) SubscribeToRemovals(s store.ExtendedStore, handler func([]*fields.QualifiedHash)) {
+	notifier, ok := s.(ds.RemovalNotifier)
+	if !ok {
+		return
+	}
+	id := notifier.SubscribeToRemovals(handler)
+	t.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		notifier.UnsubscribeToRemovals(id)
+	})
+	if t.ctx.Err() != nil {
+		notifier.UnsubscribeToRemovals(id)
+	}
+}
+
+// Wait blocks until every task has returned or the timeout elapses. The
+// context should be cancelled before calling Wait.
+func (t *taskGroup) Wait(timeout time.Duration) error {
+	// Wait for any concurrent call to Go to finish registering its task.
+	t.lock.Lock()
+	t.lock.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("background tasks still running after %v", timeout)
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address, scheme, host, path string
+	}{
+		{"arbor.example.com:7117", "tls", "arbor.example.com:7117", ""},
+		{"tls://arbor.example.com:7117", "tls", "arbor.example.com:7117", ""},
+		{"tcp://localhost:7117", "tcp", "localhost:7117", ""},
+		{"unix:///run/relay.sock", "unix", "", "/run/relay.sock"},
+		{"pipe://relay", "pipe", "relay", ""},
+	} {
+		u, err := ParseAddress(tc.address)
+		if err != nil {
+			t.Errorf("failed parsing %q: %v", tc.address, err)
+			continue
+		}
+		if u.Scheme != tc.scheme || u.Host != tc.host || u.Path != tc.path {
+			t.Errorf("parsing %q: expected %s://%s%s, got %s://%s%s", tc.address, tc.scheme, tc.host, tc.path, u.Scheme, u.Host, u.Path)
+		}
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	const name = "pipe-transport-test"
+	Pipes.Serve(name, func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+	defer Pipes.Remove(name)
+
+	conn, err := Dial("pipe://"+name, "")
+	if err != nil {
+		t.Fatalf("failed dialing in-process relay: %v", err)
+	}
+	defer conn.Close()
+	go conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("failed reading from in-process relay: %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("expected echoed %q, got %q", "ping", reply)
+	}
+
+	Pipes.Remove(name)
+	if _, err := Dial("pipe://"+name, ""); err == nil {
+		t.Fatalf("expected error dialing a removed in-process relay")
+	}
+}
+
+func TestDialUnknownScheme(t *testing.T) {
+	if _, err := Dial("carrier-pigeon://relay", ""); err == nil {
+		t.Fatalf("expected error dialing an address with an unknown scheme")
+	}
+}
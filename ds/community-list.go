@@ -74,6 +74,7 @@ type ReplyData struct {
 	CommunityName  string
 	AuthorID       *fields.QualifiedHash
 	AuthorName     string
+	AuthorProfile  Profile
 	ParentID       *fields.QualifiedHash
 	ConversationID *fields.QualifiedHash
 	Depth          int
@@ -123,6 +124,7 @@ func (r *ReplyData) Populate(reply forest.Node, store store.ExtendedStore) bool
 	}
 	asAuthor := author.(*forest.Identity)
 	r.AuthorName = string(asAuthor.Name.Blob)
+	r.AuthorProfile = IdentityProfile(asAuthor)
 
 	return true
 }
@@ -150,6 +152,23 @@ type NodeList struct {
 type NodeFilter func(forest.Node) forest.Node
 type NodeSorter func(a, b forest.Node) bool
 
+// RemovalNotifier is implemented by stores that report the nodes removed
+// from them. Handlers are invoked with the IDs of every node removed at once,
+// after they have been removed, and must not block.
+type RemovalNotifier interface {
+	SubscribeToRemovals(handler func(ids []*fields.QualifiedHash)) store.Subscription
+	UnsubscribeToRemovals(store.Subscription)
+}
+
+// ReplayNotifier is implemented by stores that deliver nodes that were
+// already stored to the lists showing them, such as after the store was
+// replaced. Replayed nodes are not delivered to SubscribeToNewMessages
+// handlers. Handlers must not block.
+type ReplayNotifier interface {
+	SubscribeToReplays(handler func(forest.Node)) store.Subscription
+	UnsubscribeToReplays(store.Subscription)
+}
+
 // NewNodeList creates a nodelist subscribed to the provided store and initialized with the
 // return value of initialize(). The nodes will be sorted using the provided sort function
 // (via sort.Slice) and nodes will only be inserted into the list if the filter() function
@@ -187,13 +206,49 @@ outer:
 	n.sort()
 }
 
+// Remove deletes the nodes with the given IDs from the list. IDs that are not
+// present are ignored.
+func (n *NodeList) Remove(ids ...*fields.QualifiedHash) {
+	n.withNodesWritable(func() {
+		n.remove(ids...)
+	})
+}
+
+func (n *NodeList) remove(ids ...*fields.QualifiedHash) {
+	remove := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		remove[id.String()] = struct{}{}
+	}
+	// Build a new slice rather than filtering in place, as WithNodes
+	// closures may retain elements of the old one.
+	kept := make([]forest.Node, 0, len(n.nodes))
+	for _, node := range n.nodes {
+		if _, removed := remove[node.ID().String()]; !removed {
+			kept = append(kept, node)
+		}
+	}
+	n.nodes = kept
+}
+
+// subscribeTo keeps the list up to date with the nodes added to s, with the
+// nodes replayed by it if s is a ReplayNotifier, and with the nodes removed
+// from it if s is a RemovalNotifier.
 func (n *NodeList) subscribeTo(s store.ExtendedStore) {
-	s.SubscribeToNewMessages(func(node forest.Node) {
+	insert := func(node forest.Node) {
 		// cannot block in subscription
 		go func() {
 			n.Insert(node)
 		}()
-	})
+	}
+	s.SubscribeToNewMessages(insert)
+	if notifier, ok := s.(ReplayNotifier); ok {
+		notifier.SubscribeToReplays(insert)
+	}
+	if notifier, ok := s.(RemovalNotifier); ok {
+		notifier.SubscribeToRemovals(func(ids []*fields.QualifiedHash) {
+			go n.Remove(ids...)
+		})
+	}
 }
 
 // WithNodes executes the provided closure with readonly access to the nodes managed
@@ -0,0 +1,124 @@
+package ds
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/twig"
+)
+
+// Twig keys of the profile fields, all at version 1.
+const (
+	profileBioKey     = "bio"
+	profileColorKey   = "color"
+	profileContactKey = "contact"
+	profileVersion    = 1
+)
+
+// Limits on the length of profile fields, in characters.
+const (
+	MaxBioLength     = 500
+	MaxContactLength = 200
+)
+
+// Profile holds the optional profile fields that an identity publishes in
+// its twig metadata.
+type Profile struct {
+	// Bio is a short free-form description of the author.
+	Bio string
+	// Color is the author's preferred color for their name, as #rrggbb.
+	Color string
+	// Contact describes how to reach the author outside of arbor.
+	Contact string
+}
+
+// ProfileOf reads the profile fields from twig metadata. Missing fields are
+// left empty, and a malformed color is ignored.
+func ProfileOf(md *twig.Data) Profile {
+	var p Profile
+	if md == nil {
+		return p
+	}
+	if bio, ok := md.Get(profileBioKey, profileVersion); ok {
+		p.Bio = string(bio)
+	}
+	if c, ok := md.Get(profileColorKey, profileVersion); ok {
+		if _, valid := ParseColor(string(c)); valid {
+			p.Color = string(c)
+		}
+	}
+	if contact, ok := md.Get(profileContactKey, profileVersion); ok {
+		p.Contact = string(contact)
+	}
+	return p
+}
+
+// IdentityProfile returns the profile published by an identity. Identities
+// with malformed metadata have an empty profile.
+func IdentityProfile(identity *forest.Identity) Profile {
+	md, err := identity.TwigMetadata()
+	if err != nil {
+		return Profile{}
+	}
+	return ProfileOf(md)
+}
+
+// IsZero reports whether none of the profile fields are set.
+func (p Profile) IsZero() bool {
+	return p == Profile{}
+}
+
+// Validate checks that the fields fit within their limits and that the color
+// is well-formed.
+func (p Profile) Validate() error {
+	if utf8.RuneCountInString(p.Bio) > MaxBioLength {
+		return fmt.Errorf("bio is longer than %d characters", MaxBioLength)
+	}
+	if utf8.RuneCountInString(p.Contact) > MaxContactLength {
+		return fmt.Errorf("contact is longer than %d characters", MaxContactLength)
+	}
+	if _, ok := ParseColor(p.Color); p.Color != "" && !ok {
+		return fmt.Errorf("color %q is not of the form #rrggbb", p.Color)
+	}
+	if strings.ContainsRune(p.Bio+p.Contact, 0) {
+		return fmt.Errorf("profile cannot contain null characters")
+	}
+	return nil
+}
+
+// Metadata returns the binary twig metadata holding the fields that are set.
+func (p Profile) Metadata() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	md := twig.New()
+	for key, value := range map[string]string{
+		profileBioKey:     p.Bio,
+		profileColorKey:   p.Color,
+		profileContactKey: p.Contact,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := md.Set(key, profileVersion, []byte(value)); err != nil {
+			return nil, fmt.Errorf("failed setting %s: %w", key, err)
+		}
+	}
+	return md.MarshalBinary()
+}
+
+// ParseColor parses a color of the form #rrggbb.
+func ParseColor(s string) (color.NRGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.NRGBA{}, false
+	}
+	rgb, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, true
+}
@@ -84,6 +84,55 @@ func (s *sortable) Insert(nodes ...ReplyData) {
 	}
 	s.data = append(s.data, newNodes...)
 	s.Sort()
+	s.reindex()
+}
+
+// Remove deletes the data for the given IDs from the list, preserving the
+// order of the remaining elements. IDs that are not present are ignored.
+func (s *sortable) Remove(ids ...*fields.QualifiedHash) {
+	s.initialize()
+	remove := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if s.Contains(id) {
+			remove[id.String()] = struct{}{}
+		}
+	}
+	if len(remove) == 0 {
+		return
+	}
+	// Build a new slice rather than filtering in place, as callers may
+	// hold pointers into the old one.
+	kept := make([]ReplyData, 0, len(s.data)-len(remove))
+	for _, rd := range s.data {
+		if _, removed := remove[rd.ID.String()]; !removed {
+			kept = append(kept, rd)
+		}
+	}
+	s.data = kept
+	s.indexForID = make(map[string]int, len(s.data))
+	s.reindex()
+}
+
+// Refilter removes the elements that the filter no longer allows, such as
+// replies that have expired since they were inserted, and returns their IDs.
+func (s *sortable) Refilter() []*fields.QualifiedHash {
+	var removed []*fields.QualifiedHash
+	for _, rd := range s.data {
+		if !s.shouldAllow(rd) {
+			removed = append(removed, rd.ID)
+		}
+	}
+	s.Remove(removed...)
+	return removed
+}
+
+// reindex records the index of every element. Sorting only indexes the
+// elements that it compares, which excludes the only element of a list of
+// length one.
+func (s *sortable) reindex() {
+	for i := range s.data {
+		s.ensureIndexed(i)
+	}
 }
 
 // AlphaReplyList creates a thread-safe list of ReplyData that maintains its
@@ -119,6 +168,22 @@ func (r *AlphaReplyList) Insert(nodes ...ReplyData) {
 	})
 }
 
+// Remove deletes the ReplyData with the given IDs from the list.
+func (r *AlphaReplyList) Remove(ids ...*fields.QualifiedHash) {
+	r.asWritable(func() {
+		r.sortable.Remove(ids...)
+	})
+}
+
+// Refilter removes the ReplyData that the filter no longer allows and
+// returns their IDs.
+func (r *AlphaReplyList) Refilter() (removed []*fields.QualifiedHash) {
+	r.asWritable(func() {
+		removed = r.sortable.Refilter()
+	})
+	return
+}
+
 // IndexForID returns the index at which the given ID's data is stored.
 // It is safe (and recommended) to call this function from within the function
 // passed to WithReplies(), as otherwise the node may by moved by another
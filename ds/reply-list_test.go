@@ -0,0 +1,95 @@
+package ds
+
+import (
+	"crypto/sha512"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// testReplies returns n replies created a minute apart, oldest first.
+func testReplies(t *testing.T, n int) []ReplyData {
+	t.Helper()
+	start := time.Now()
+	replies := make([]ReplyData, n)
+	for i := range replies {
+		digest := sha512.Sum512_256([]byte{byte(i)})
+		id, err := fields.NewQualifiedHash(fields.HashTypeSHA512, digest[:])
+		if err != nil {
+			t.Fatalf("creating ID: %v", err)
+		}
+		replies[i] = ReplyData{ID: id, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	return replies
+}
+
+// checkIndexes verifies that the list holds exactly the expected replies in
+// order, and that each of them is indexed at its position.
+func checkIndexes(t *testing.T, s *sortable, expected []ReplyData) {
+	t.Helper()
+	if len(s.data) != len(expected) {
+		t.Fatalf("list holds %d replies, expected %d", len(s.data), len(expected))
+	}
+	if len(s.indexForID) != len(expected) {
+		t.Errorf("index holds %d replies, expected %d", len(s.indexForID), len(expected))
+	}
+	for i, rd := range expected {
+		if !s.data[i].ID.Equals(rd.ID) {
+			t.Errorf("reply %d is %s, expected %s", i, s.data[i].ID, rd.ID)
+		}
+		if index := s.IndexForID(rd.ID); index != i {
+			t.Errorf("reply %s indexed at %d, expected %d", rd.ID, index, i)
+		}
+	}
+}
+
+func TestSortableRemove(t *testing.T) {
+	replies := testReplies(t, 5)
+	var s sortable
+	// Insert out of order so that sorting moves the replies.
+	s.Insert(replies[3], replies[0], replies[4])
+	s.Insert(replies[2], replies[1])
+	checkIndexes(t, &s, replies)
+
+	s.Remove(replies[1].ID, replies[3].ID)
+	checkIndexes(t, &s, []ReplyData{replies[0], replies[2], replies[4]})
+	for _, removed := range []ReplyData{replies[1], replies[3]} {
+		if s.Contains(removed.ID) {
+			t.Errorf("removed reply %s is still contained", removed.ID)
+		}
+	}
+
+	// Removing IDs that are not present changes nothing.
+	s.Remove(replies[1].ID)
+	checkIndexes(t, &s, []ReplyData{replies[0], replies[2], replies[4]})
+
+	// Removed replies can be inserted again.
+	s.Insert(replies[3])
+	checkIndexes(t, &s, []ReplyData{replies[0], replies[2], replies[3], replies[4]})
+
+	s.Remove(replies[0].ID, replies[2].ID, replies[3].ID)
+	checkIndexes(t, &s, []ReplyData{replies[4]})
+	s.Remove(replies[4].ID)
+	checkIndexes(t, &s, nil)
+}
+
+func TestSortableRefilter(t *testing.T) {
+	replies := testReplies(t, 4)
+	hidden := make(map[string]bool)
+	s := sortable{allow: func(rd ReplyData) bool {
+		return !hidden[rd.ID.String()]
+	}}
+	s.Insert(replies...)
+
+	hidden[replies[1].ID.String()] = true
+	hidden[replies[2].ID.String()] = true
+	removed := s.Refilter()
+	if len(removed) != 2 || !removed[0].Equals(replies[1].ID) || !removed[1].Equals(replies[2].ID) {
+		t.Errorf("refiltering removed %v, expected the hidden replies", removed)
+	}
+	checkIndexes(t, &s, []ReplyData{replies[0], replies[3]})
+	if removed := s.Refilter(); len(removed) != 0 {
+		t.Errorf("refiltering again removed %v", removed)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"image"
 	"log"
 	"runtime"
@@ -99,6 +100,12 @@ func NewDynamicChatView(app core.App) View {
 	})
 
 	c.Arbor().Store().SubscribeToNewMessages(c.handleNewNode)
+	if notifier, ok := c.Arbor().Store().(ds.ReplayNotifier); ok {
+		notifier.SubscribeToReplays(c.handleNewNode)
+	}
+	if notifier, ok := c.Arbor().Store().(ds.RemovalNotifier); ok {
+		notifier.SubscribeToRemovals(c.handleRemovedNodes)
+	}
 	return c
 }
 
@@ -127,6 +134,19 @@ func (c *DynamicChatView) handleNewNode(node forest.Node) {
 	}()
 }
 
+// handleRemovedNodes drops the replies that have been removed from the store.
+func (c *DynamicChatView) handleRemovedNodes(ids []*fields.QualifiedHash) {
+	go func() {
+		serials := make([]list.Serial, len(ids))
+		for i, id := range ids {
+			serials[i] = list.Serial(id.String())
+		}
+		c.chatManager.Modify(nil, nil, serials)
+		c.FocusTracker.Invalidate()
+		c.manager.RequestInvalidate()
+	}()
+}
+
 // AppBarData returns the configuration of the app bar for this view.
 func (c *DynamicChatView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
 	return true, DynamicChatViewName, []materials.AppBarAction{}, []materials.OverflowAction{}
@@ -162,8 +182,12 @@ func (c *DynamicChatView) sendMessage() {
 	replyText = strings.TrimSpace(replyText)
 
 	nodeBuilder, err := c.Settings().Builder()
-	if err != nil {
+	if errors.Is(err, core.ErrKeyLocked) {
+		c.manager.RequestViewSwitch(KeyPassphraseViewID)
+		return
+	} else if err != nil {
 		log.Printf("failed acquiring node builder: %v", err)
+		return
 	}
 	author = nodeBuilder.User
 	if c.ReplyingTo == nil {
@@ -206,18 +230,13 @@ func (c *DynamicChatView) sendMessage() {
 	c.postReplies(author, newReplies)
 }
 
-// postReplies actually adds the replies to the store of history (and
-// causes them to be sent because the sprout working is watching the
-// store for updates).
+// postReplies adds the replies to the store of history and queues them
+// in the outbox for delivery to the connected relays.
 func (c *DynamicChatView) postReplies(author *forest.Identity, replies []*forest.Reply) {
 	go func() {
 		for _, reply := range replies {
-			if err := c.Arbor().Store().Add(author); err != nil {
-				log.Printf("failed adding replying identity to store: %v", err)
-				return
-			}
-			if err := c.Arbor().Store().Add(reply); err != nil {
-				log.Printf("failed adding reply to store: %v", err)
+			if err := c.Outbox().Send(author, reply); err != nil {
+				log.Printf("failed sending reply: %v", err)
 				return
 			}
 		}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+
+	"gioui.org/app"
+	"gioui.org/x/haptic"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// hapticService buzzes the haptic feedback device of the window's platform.
+type hapticService struct {
+	*haptic.Buzzer
+}
+
+var _ core.HapticService = &hapticService{}
+
+func newHapticService(w *app.Window) core.HapticService {
+	return &hapticService{
+		Buzzer: haptic.NewBuzzer(w),
+	}
+}
+
+func (h *hapticService) UpdateAndroidViewRef(view uintptr) {
+	h.Buzzer.SetView(view)
+}
+
+func (h *hapticService) Buzz() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Recovered from buzz panic: %v", err)
+		}
+	}()
+	h.Buzzer.Buzz()
+}
@@ -99,3 +99,8 @@ var ExpandIcon *widget.Icon = func() *widget.Icon {
     icon, _ := widget.NewIcon(icons.NavigationUnfoldMore)
     return icon
 }()
+
+var SearchIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionSearch)
+	return icon
+}()
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/sprig/core"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+	sprigTheme "git.sr.ht/~whereswaldon/sprig/widget/theme"
+)
+
+// IdentitiesView lists the local identities and creates, imports, exports,
+// renames, deletes, and switches between them.
+type IdentitiesView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Identities   []IdentityControl
+	NewName      widget.Editor
+	CreateButton widget.Clickable
+	ImportForm   IdentityImportForm
+
+	// Operation creates, imports, and exports identities in the background.
+	Operation BackgroundOperation
+}
+
+// IdentityControl holds the UI state for a single local identity.
+type IdentityControl struct {
+	core.LocalIdentity
+	LabelEditor                 widget.Editor
+	SwitchButton, RenameButton  widget.Clickable
+	DeleteButton, ConfirmButton widget.Clickable
+	// confirming is set while waiting for the deletion to be confirmed.
+	confirming bool
+	// CurrentPassphrase decrypts a protected private key for export, which
+	// is encrypted with ExportPassphrase in the export file.
+	CurrentPassphrase, ExportPassphrase widget.Editor
+	ExportButton, SaveExportButton      widget.Clickable
+	// exporting is set while the export form is shown.
+	exporting bool
+}
+
+// IdentityImportForm holds the UI state for importing an identity exported
+// from another device.
+type IdentityImportForm struct {
+	Path, Passphrase widget.Editor
+	ImportButton     widget.Clickable
+}
+
+func (f *IdentityImportForm) init() {
+	f.Path.SingleLine = true
+	f.Passphrase.SingleLine = true
+	f.Passphrase.Mask = '•'
+}
+
+// Layout displays the editors of the form side by side with its button,
+// which is replaced by a loader while working.
+func (f *IdentityImportForm) Layout(gtx C, theme *material.Theme, working bool) D {
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(2, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Editor(theme, &f.Path, "Path to identity export").Layout)
+		}),
+		layout.Flexed(1, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Editor(theme, &f.Passphrase, "Passphrase, if any").Layout)
+		}),
+		layout.Rigid(func(gtx C) D {
+			if working {
+				return itemInset.Layout(gtx, material.Loader(theme).Layout)
+			}
+			return itemInset.Layout(gtx, material.Button(theme, &f.ImportButton, "Import").Layout)
+		}),
+	)
+}
+
+var _ View = &IdentitiesView{}
+
+func NewIdentitiesView(app core.App) View {
+	c := &IdentitiesView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	c.NewName.SingleLine = true
+	c.NewName.Submit = true
+	c.ImportForm.init()
+	return c
+}
+
+func (c *IdentitiesView) HandleIntent(intent Intent) {}
+
+func (c *IdentitiesView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Identities", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *IdentitiesView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *IdentitiesView) BecomeVisible() {
+	c.Operation.SetResult("")
+	c.reload()
+}
+
+// reload lists the local identities again.
+func (c *IdentitiesView) reload() {
+	identities, err := c.Settings().Identities()
+	if err != nil {
+		c.Operation.SetResult("Failed listing identities: " + err.Error())
+	}
+	c.Identities = make([]IdentityControl, len(identities))
+	for i, identity := range identities {
+		control := &c.Identities[i]
+		control.LocalIdentity = identity
+		control.LabelEditor.SingleLine = true
+		control.LabelEditor.SetText(identity.Label)
+		for _, editor := range []*widget.Editor{&control.CurrentPassphrase, &control.ExportPassphrase} {
+			editor.SingleLine = true
+			editor.Mask = '•'
+		}
+	}
+}
+
+// identitiesChanged restarts the active-status heartbeat for the active
+// identity and reloads the list.
+func (c *IdentitiesView) identitiesChanged() {
+	go c.Arbor().StartHeartbeat()
+	c.reload()
+}
+
+func (c *IdentitiesView) Update(gtx layout.Context) {
+	c.Operation.Update()
+	for i := range c.Identities {
+		control := &c.Identities[i]
+		id := control.ID()
+		if control.SwitchButton.Clicked() {
+			if err := c.Settings().SetActiveIdentity(id); err != nil {
+				c.Operation.SetResult(err.Error())
+			} else {
+				c.Operation.SetResult("Now posting as " + control.DisplayName() + ".")
+			}
+			c.identitiesChanged()
+			if c.Settings().KeyLocked() {
+				c.manager.RequestViewSwitch(KeyPassphraseViewID)
+			}
+			return
+		}
+		if control.RenameButton.Clicked() {
+			if err := c.Settings().RenameIdentity(id, strings.TrimSpace(control.LabelEditor.Text())); err != nil {
+				c.Operation.SetResult(err.Error())
+			}
+			c.reload()
+			return
+		}
+		if control.ExportButton.Clicked() {
+			control.exporting = !control.exporting
+		}
+		if control.SaveExportButton.Clicked() {
+			c.export(control.LocalIdentity, control.CurrentPassphrase.Text(), control.ExportPassphrase.Text())
+			control.exporting = false
+			control.CurrentPassphrase.SetText("")
+			control.ExportPassphrase.SetText("")
+		}
+		if control.DeleteButton.Clicked() {
+			control.confirming = !control.confirming
+		}
+		if control.ConfirmButton.Clicked() {
+			name := control.DisplayName()
+			if err := c.Settings().DeleteIdentity(id); err != nil {
+				c.Operation.SetResult(err.Error())
+			} else {
+				c.Operation.SetResult("Deleted " + name + ".")
+			}
+			c.identitiesChanged()
+			return
+		}
+	}
+	submitted := false
+	for _, event := range c.NewName.Events() {
+		if _, ok := event.(widget.SubmitEvent); ok {
+			submitted = true
+		}
+	}
+	if c.CreateButton.Clicked() || submitted {
+		c.create(strings.TrimSpace(c.NewName.Text()))
+	}
+	if c.ImportForm.ImportButton.Clicked() {
+		path, passphrase := strings.TrimSpace(c.ImportForm.Path.Text()), c.ImportForm.Passphrase.Text()
+		c.ImportForm.Passphrase.SetText("")
+		c.Operation.Run(c.manager, func() (string, error) {
+			identity, err := importIdentity(c.App, path, passphrase)
+			if err != nil {
+				return "Failed importing identity: " + err.Error(), nil
+			}
+			c.Operation.Then(c.identitiesChanged)
+			return "Imported " + string(identity.Name.Blob) + ". Switch to it to post as it.", nil
+		})
+	}
+}
+
+// export writes the identity to a new file in the archives directory in the
+// background.
+func (c *IdentitiesView) export(identity core.LocalIdentity, current, passphrase string) {
+	c.Operation.Run(c.manager, func() (string, error) {
+		path, err := exportIdentity(c.App, identity, current, passphrase)
+		if err != nil {
+			return "Failed exporting identity: " + err.Error(), nil
+		}
+		if passphrase == "" {
+			return "Exported " + identity.DisplayName() + " to " + path + ". Anyone with this file can post as it, so keep it safe.", nil
+		}
+		return "Exported " + identity.DisplayName() + " to " + path + ".", nil
+	})
+}
+
+// create generates a new identity with the given name in the background. The
+// new identity becomes the active one.
+func (c *IdentitiesView) create(name string) {
+	if name == "" {
+		c.Operation.SetResult("Choose a name for the new identity.")
+		return
+	}
+	started := c.Operation.Run(c.manager, func() (string, error) {
+		err := c.Settings().CreateIdentity(name)
+		c.Operation.Then(c.identitiesChanged)
+		if err != nil {
+			return "Failed creating identity: " + err.Error(), nil
+		}
+		return "Now posting as " + name + ".", nil
+	})
+	if started {
+		c.NewName.SetText("")
+	}
+}
+
+func (c *IdentitiesView) Layout(gtx layout.Context) layout.Dimensions {
+	sTheme := c.Theme().Current()
+	theme := sTheme.Theme
+	working, result := c.Operation.State()
+
+	items := []layout.Widget{
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "Messages are posted as the active identity. The name published with an identity cannot be changed, but you can give it a label that is only shown on this device.").Layout)
+		},
+	}
+	if len(c.Identities) == 0 {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body1(theme, "There are no identities yet.").Layout)
+		})
+	}
+	for i := range c.Identities {
+		control := &c.Identities[i]
+		items = append(items, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(func(gtx C) D {
+							return itemInset.Layout(gtx, sprigTheme.AuthorName(sTheme, string(control.Name.Blob), control.ID(), control.Active).Profile(ds.IdentityProfile(control.Identity)).Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							if control.Label == "" {
+								return D{}
+							}
+							return itemInset.Layout(gtx, material.Body1(theme, "("+control.Label+")").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							if control.Active {
+								return itemInset.Layout(gtx, material.Body2(theme, "Active").Layout)
+							}
+							return itemInset.Layout(gtx, material.Button(theme, &control.SwitchButton, "Switch").Layout)
+						}),
+					)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							return itemInset.Layout(gtx, material.Editor(theme, &control.LabelEditor, "Label").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &control.RenameButton, "Rename").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &control.ExportButton, "Export").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							label := "Delete"
+							if control.confirming {
+								label = "Keep"
+							}
+							return itemInset.Layout(gtx, material.Button(theme, &control.DeleteButton, label).Layout)
+						}),
+					)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if !control.exporting {
+						return D{}
+					}
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							if !control.KeyProtected {
+								return D{}
+							}
+							return itemInset.Layout(gtx, material.Editor(theme, &control.CurrentPassphrase, "Current passphrase").Layout)
+						}),
+						layout.Flexed(1, func(gtx C) D {
+							return itemInset.Layout(gtx, material.Editor(theme, &control.ExportPassphrase, "Export passphrase (optional)").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &control.SaveExportButton, "Save").Layout)
+						}),
+					)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if !control.confirming {
+						return D{}
+					}
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							return itemInset.Layout(gtx, material.Body2(theme, "Deleting an identity destroys its private key. Nobody will be able to post as it again.").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &control.ConfirmButton, "Delete forever").Layout)
+						}),
+					)
+				}),
+			)
+		})
+	}
+	items = append(items, func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Flexed(1, func(gtx C) D {
+				return itemInset.Layout(gtx, material.Editor(theme, &c.NewName, "New identity name").Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				if working {
+					return itemInset.Layout(gtx, material.Loader(theme).Layout)
+				}
+				return itemInset.Layout(gtx, material.Button(theme, &c.CreateButton, "Create").Layout)
+			}),
+		)
+	})
+	items = append(items, func(gtx C) D {
+		return c.ImportForm.Layout(gtx, theme, working)
+	})
+	if result != "" {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body1(theme, result).Layout)
+		})
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *IdentitiesView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
+
+// exportIdentity writes the identity to a new file in the archives directory,
+// naming it after the identity. It returns the path of the file.
+func exportIdentity(app core.App, identity core.LocalIdentity, current, passphrase string) (string, error) {
+	dir := app.Settings().ArchivesDir()
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", fmt.Errorf("failed creating archives directory: %w", err)
+	}
+	name := safeFileName(string(identity.Name.Blob))
+	path := filepath.Join(dir, fmt.Sprintf("%s-identity-%s.json", name, time.Now().Format("20060102-150405")))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed creating identity export: %w", err)
+	}
+	err = app.Settings().ExportIdentity(identity.ID(), current, passphrase, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// importIdentity adds the identity exported to the file at path to the local
+// identities.
+func importIdentity(app core.App, path, passphrase string) (*forest.Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening identity export: %w", err)
+	}
+	defer file.Close()
+	return app.Settings().ImportIdentity(file, passphrase)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log"
+	"strings"
+
 	"gioui.org/layout"
 	"gioui.org/unit"
 	"gioui.org/widget"
@@ -14,8 +17,12 @@ type IdentityFormView struct {
 	manager ViewManager
 	sprigWidget.TextForm
 	CreateButton widget.Clickable
+	ImportForm   IdentityImportForm
 
 	core.App
+
+	// Import imports an identity in the background.
+	Import BackgroundOperation
 }
 
 var _ View = &IdentityFormView{}
@@ -25,6 +32,7 @@ func NewIdentityFormView(app core.App) View {
 		App: app,
 	}
 	c.TextForm.TextField.Editor.SingleLine = true
+	c.ImportForm.init()
 
 	return c
 }
@@ -46,14 +54,55 @@ func (c *IdentityFormView) HandleClipboard(contents string) {
 }
 
 func (c *IdentityFormView) Update(gtx layout.Context) {
+	c.Import.Update()
 	if c.CreateButton.Clicked() {
-		c.Settings().CreateIdentity(c.TextField.Text())
+		if err := c.Settings().CreateIdentity(c.TextField.Text()); err != nil {
+			log.Printf("failed creating identity: %v", err)
+		} else {
+			go c.Arbor().StartHeartbeat()
+		}
 		c.manager.RequestViewSwitch(SubscriptionSetupFormViewID)
 	}
+	if c.ImportForm.ImportButton.Clicked() {
+		c.importIdentity(strings.TrimSpace(c.ImportForm.Path.Text()), c.ImportForm.Passphrase.Text())
+	}
+}
+
+// importIdentity imports the identity exported to the file at path in the
+// background and makes it the active identity.
+func (c *IdentityFormView) importIdentity(path, passphrase string) {
+	c.Import.Run(c.manager, func() (string, error) {
+		err := func() error {
+			identity, err := importIdentity(c.App, path, passphrase)
+			if err != nil {
+				return err
+			}
+			if err := c.Settings().SetActiveIdentity(identity.ID()); err != nil {
+				return err
+			}
+			if c.Settings().KeyLocked() {
+				// The key was exported with this passphrase, which
+				// still protects it.
+				if err := c.Settings().UnlockKey(passphrase); err != nil {
+					return err
+				}
+			}
+			go c.Arbor().StartHeartbeat()
+			return nil
+		}()
+		if err != nil {
+			return "Failed importing identity: " + err.Error(), nil
+		}
+		c.Import.Then(func() {
+			c.manager.RequestViewSwitch(SubscriptionSetupFormViewID)
+		})
+		return "", nil
+	})
 }
 
 func (c *IdentityFormView) Layout(gtx layout.Context) layout.Dimensions {
 	theme := c.Theme().Current().Theme
+	working, result := c.Import.State()
 	return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -84,6 +133,26 @@ func (c *IdentityFormView) Layout(gtx layout.Context) layout.Dimensions {
 					)
 				})
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return layout.UniformInset(unit.Dp(4)).Layout(gtx,
+						material.Body1(theme, "Or import an identity exported from another device:").Layout,
+					)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return c.ImportForm.Layout(gtx, theme, working)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if result == "" {
+					return layout.Dimensions{}
+				}
+				return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return layout.UniformInset(unit.Dp(4)).Layout(gtx,
+						material.Body2(theme, result).Layout,
+					)
+				})
+			}),
 		)
 	})
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// IntegrityView checks the message store for damaged nodes and displays the
+// resulting report.
+type IntegrityView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	CheckButton, QuarantineButton widget.Clickable
+
+	// Check runs the integrity checker in the background.
+	Check  BackgroundOperation
+	report *core.IntegrityReport
+}
+
+var _ View = &IntegrityView{}
+
+func NewIntegrityView(app core.App) View {
+	c := &IntegrityView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *IntegrityView) HandleIntent(intent Intent) {}
+
+func (c *IntegrityView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Store Integrity", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *IntegrityView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *IntegrityView) BecomeVisible() {
+}
+
+func (c *IntegrityView) Update(gtx layout.Context) {
+	c.Check.Update()
+	if c.CheckButton.Clicked() {
+		c.check(false)
+	}
+	if c.QuarantineButton.Clicked() {
+		c.check(true)
+	}
+}
+
+// check runs the integrity checker in the background.
+func (c *IntegrityView) check(quarantine bool) {
+	c.Check.Run(c.manager, func() (string, error) {
+		report, err := c.Arbor().CheckIntegrity(context.Background(), quarantine)
+		c.Check.Then(func() {
+			c.report = report
+		})
+		if err != nil {
+			return "Check failed: " + err.Error(), nil
+		}
+		return "", nil
+	})
+}
+
+func (c *IntegrityView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	working, result := c.Check.State()
+	report := c.report
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	line(material.Body1(theme, "The integrity check reads every stored message, verifying its hash and its author's signature, and looks for messages whose parent, author, or community is missing."))
+	if working {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		})
+	} else {
+		button := func(clickable *widget.Clickable, label, context string) {
+			items = append(items, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, clickable, label).Layout)
+					}),
+					layout.Rigid(func(gtx C) D {
+						return itemInset.Layout(gtx, material.Body2(theme, context).Layout)
+					}),
+				)
+			})
+		}
+		button(&c.CheckButton, "Check", "Report problems without changing the store.")
+		button(&c.QuarantineButton, "Check and quarantine", "Move damaged message files to "+c.Settings().QuarantineDir()+".")
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	if report != nil {
+		line(material.H6(theme, report.Summary()))
+		if report.Note != "" {
+			line(material.Body2(theme, report.Note))
+		}
+		line(material.Body2(theme, "The full report was saved in "+c.Settings().QuarantineDir()+"."))
+		for _, issue := range report.Issues {
+			line(material.Body2(theme, issue.String()))
+		}
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *IntegrityView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// KeyPassphraseView unlocks the private key of the active identity, and sets,
+// changes, or removes the passphrase protecting it.
+type KeyPassphraseView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Current, Passphrase, Confirmation widget.Editor
+	Timeout                           widget.Editor
+	UnlockButton, LaterButton         widget.Clickable
+	SetButton, RemoveButton           widget.Clickable
+	TimeoutButton                     widget.Clickable
+
+	// Operation unlocks the key or changes its passphrase in the
+	// background.
+	Operation BackgroundOperation
+	// keyLocked and keyProtected cache the state of the key, which is read
+	// from disk.
+	keyLocked, keyProtected bool
+}
+
+var _ View = &KeyPassphraseView{}
+
+func NewKeyPassphraseView(app core.App) View {
+	c := &KeyPassphraseView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	for _, editor := range []*widget.Editor{&c.Current, &c.Passphrase, &c.Confirmation} {
+		editor.SingleLine = true
+		editor.Submit = true
+		editor.Mask = '•'
+	}
+	c.Timeout.SingleLine = true
+	return c
+}
+
+func (c *KeyPassphraseView) HandleIntent(intent Intent) {}
+
+func (c *KeyPassphraseView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Key Passphrase", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *KeyPassphraseView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *KeyPassphraseView) BecomeVisible() {
+	for _, editor := range []*widget.Editor{&c.Current, &c.Passphrase, &c.Confirmation} {
+		editor.SetText("")
+	}
+	minutes := int(c.Settings().KeyTimeout() / time.Minute)
+	if minutes > 0 {
+		c.Timeout.SetText(strconv.Itoa(minutes))
+	} else {
+		c.Timeout.SetText("")
+	}
+	c.Operation.SetResult("")
+	c.refreshState()
+	if c.Settings().KeyProtected() {
+		c.Current.Focus()
+	} else {
+		c.Passphrase.Focus()
+	}
+}
+
+// refreshState reads the state of the key again.
+func (c *KeyPassphraseView) refreshState() {
+	c.keyLocked, c.keyProtected = c.Settings().KeyLocked(), c.Settings().KeyProtected()
+}
+
+func (c *KeyPassphraseView) Update(gtx layout.Context) {
+	c.Operation.Update()
+	submitted := false
+	for _, editor := range []*widget.Editor{&c.Current, &c.Passphrase, &c.Confirmation} {
+		for _, event := range editor.Events() {
+			if _, ok := event.(widget.SubmitEvent); ok {
+				submitted = true
+			}
+		}
+	}
+	locked := c.keyLocked
+	if c.UnlockButton.Clicked() || (submitted && locked) {
+		passphrase := c.Current.Text()
+		c.run(func() (string, error) {
+			if err := c.Settings().UnlockKey(passphrase); err != nil {
+				return "", err
+			}
+			// The heartbeat could not sign while the key was locked.
+			c.Arbor().StartHeartbeat()
+			c.Operation.Then(func() {
+				c.manager.RequestViewSwitch(ReplyViewID)
+			})
+			return "Unlocked.", nil
+		})
+	}
+	if c.LaterButton.Clicked() {
+		c.manager.RequestViewSwitch(ReplyViewID)
+	}
+	if c.SetButton.Clicked() || (submitted && !locked) {
+		current, passphrase := c.Current.Text(), c.Passphrase.Text()
+		if passphrase == "" {
+			c.Operation.SetResult("Choose a passphrase.")
+		} else if passphrase != c.Confirmation.Text() {
+			c.Operation.SetResult("The passphrases do not match.")
+		} else {
+			c.run(func() (string, error) {
+				if err := c.Settings().SetKeyPassphrase(current, passphrase); err != nil {
+					return "", err
+				}
+				return "Your private key is protected by the new passphrase.", nil
+			})
+		}
+	}
+	if c.RemoveButton.Clicked() {
+		current := c.Current.Text()
+		c.run(func() (string, error) {
+			if err := c.Settings().SetKeyPassphrase(current, ""); err != nil {
+				return "", err
+			}
+			return "Your private key is no longer protected by a passphrase.", nil
+		})
+	}
+	if c.TimeoutButton.Clicked() {
+		text := strings.TrimSpace(c.Timeout.Text())
+		minutes, err := strconv.Atoi(text)
+		if text == "" {
+			minutes, err = 0, nil
+		}
+		if err != nil || minutes < 0 {
+			c.Operation.SetResult("The timeout must be a number of minutes.")
+		} else {
+			c.Settings().SetKeyTimeout(time.Duration(minutes) * time.Minute)
+			go c.Settings().Persist()
+			if minutes == 0 {
+				c.Operation.SetResult("Your private key stays unlocked until Sprig exits.")
+			} else {
+				c.Operation.SetResult("Your private key is locked after " + strconv.Itoa(minutes) + " minutes without use.")
+			}
+		}
+	}
+}
+
+// run performs the operation in the background, and then reads the state of
+// the key again.
+func (c *KeyPassphraseView) run(operation func() (string, error)) {
+	c.Operation.Run(c.manager, func() (string, error) {
+		defer c.Operation.Then(c.refreshState)
+		return operation()
+	})
+}
+
+func (c *KeyPassphraseView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	working, result := c.Operation.State()
+	locked, protected := c.keyLocked, c.keyProtected
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	editor := func(editor *widget.Editor, hint string) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Editor(theme, editor, hint).Layout)
+		})
+	}
+	button := func(clickable *widget.Clickable, label string) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Button(theme, clickable, label).Layout)
+		})
+	}
+	switch {
+	case c.Settings().ActiveArborIdentityID() == nil:
+		line(material.H6(theme, "There is no active identity."))
+	case locked:
+		line(material.H6(theme, "Your private key is locked."))
+		line(material.Body1(theme, "Enter the passphrase of your private key to post messages and show that you are active."))
+		editor(&c.Current, "Passphrase")
+		if !working {
+			button(&c.UnlockButton, "Unlock")
+			button(&c.LaterButton, "Later")
+		}
+	case protected:
+		line(material.H6(theme, "Your private key is protected by a passphrase."))
+		line(material.Body1(theme, "Enter the current passphrase to change or remove it."))
+		editor(&c.Current, "Current passphrase")
+		editor(&c.Passphrase, "New passphrase")
+		editor(&c.Confirmation, "Confirm new passphrase")
+		if !working {
+			button(&c.SetButton, "Change passphrase")
+			button(&c.RemoveButton, "Remove passphrase")
+		}
+	default:
+		line(material.H6(theme, "Your private key is not protected."))
+		line(material.Body1(theme, "Anyone with access to this device can post as you. Protecting your private key with a passphrase requires it before posting once each session. Your key cannot be used without it."))
+		editor(&c.Passphrase, "Passphrase")
+		editor(&c.Confirmation, "Confirm passphrase")
+		if !working {
+			button(&c.SetButton, "Protect key")
+		}
+	}
+	if protected && !locked {
+		line(material.Body1(theme, "Lock the key again after it goes unused for this many minutes. Leave it empty to keep it unlocked until Sprig exits."))
+		items = append(items, func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return itemInset.Layout(gtx, material.Editor(theme, &c.Timeout, "Minutes").Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, &c.TimeoutButton, "Save").Layout)
+				}),
+			)
+		})
+	}
+	if working {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		})
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *KeyPassphraseView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -26,9 +27,16 @@ type (
 
 func main() {
 	log.SetFlags(log.Flags() | log.Lshortfile)
+	opts := parseOptions()
+	if opts.checkStore != "" {
+		if err := runIntegrityCheck(opts.dataDir, opts.checkStore); err != nil {
+			log.Fatalf("checking store: %v", err)
+		}
+		return
+	}
 	go func() {
 		w := app.NewWindow(app.Title("Sprig"))
-		if err := eventLoop(w); err != nil {
+		if err := eventLoop(w, opts); err != nil {
 			log.Fatalf("exiting due to error: %v", err)
 		}
 		os.Exit(0)
@@ -36,28 +44,40 @@ func main() {
 	app.Main()
 }
 
-func eventLoop(w *app.Window) error {
-	var (
-		dataDir    string
-		invalidate bool
-		profileOpt string
-	)
+// options holds the command-line configuration.
+type options struct {
+	dataDir    string
+	invalidate bool
+	profileOpt string
+	checkStore string
+}
 
+// parseOptions reads the command-line flags.
+func parseOptions() options {
+	var opts options
 	dataDir, err := getDataDir("sprig")
 	if err != nil {
 		log.Printf("finding application data dir: %v", err)
 	}
 
-	flag.StringVar(&profileOpt, "profile", "none", "create the provided kind of profile. Use one of [none, cpu, mem, block, goroutine, mutex, trace, gio]")
-	flag.BoolVar(&invalidate, "invalidate", false, "invalidate every single frame, only useful for profiling")
-	flag.StringVar(&dataDir, "data-dir", dataDir, "application state directory")
+	flag.StringVar(&opts.profileOpt, "profile", "none", "create the provided kind of profile. Use one of [none, cpu, mem, block, goroutine, mutex, trace, gio]")
+	flag.BoolVar(&opts.invalidate, "invalidate", false, "invalidate every single frame, only useful for profiling")
+	flag.StringVar(&opts.dataDir, "data-dir", dataDir, "application state directory")
+	flag.StringVar(&opts.checkStore, "check-store", "", "check the message store for damaged nodes, print a report, and exit. Use one of [report, quarantine]")
 	flag.Parse()
+	return opts
+}
 
-	profiler := ProfileOpt(profileOpt).NewProfiler()
+func eventLoop(w *app.Window, opts options) error {
+	profiler := ProfileOpt(opts.profileOpt).NewProfiler()
 	profiler.Start()
 	defer profiler.Stop()
 
-	app, err := core.NewApp(w, dataDir)
+	app, err := core.NewApp(
+		core.WithInvalidator(w),
+		core.WithHaptic(newHapticService(w)),
+		core.WithStateDir(opts.dataDir),
+	)
 	if err != nil {
 		log.Fatalf("Failed initializing application: %v", err)
 	}
@@ -78,13 +98,31 @@ func eventLoop(w *app.Window) error {
 	vm.RegisterView(ConsentViewID, NewConsentView(app))
 	vm.RegisterView(SubscriptionSetupFormViewID, NewSubSetupFormView(app))
 	vm.RegisterView(DynamicChatViewID, NewDynamicChatView(app))
+	vm.RegisterView(RelayPinsViewID, NewRelayPinsView(app))
+	vm.RegisterView(StoreRecoveryViewID, NewStoreRecoveryView(app))
+	vm.RegisterView(ArchiveViewID, NewArchiveView(app))
+	vm.RegisterView(SearchViewID, NewSearchView(app))
+	vm.RegisterView(IntegrityViewID, NewIntegrityView(app))
+	vm.RegisterView(RetentionViewID, NewRetentionView(app))
+	vm.RegisterView(StoreEncryptionViewID, NewStoreEncryptionView(app))
+	vm.RegisterView(StatisticsViewID, NewStatisticsView(app))
+	vm.RegisterView(IdentitiesViewID, NewIdentitiesView(app))
+	vm.RegisterView(KeyPassphraseViewID, NewKeyPassphraseView(app))
+	vm.RegisterView(ProfileViewID, NewProfileView(app))
+	vm.RegisterIntentHandler(ReplyViewID, ViewReplyWithID)
 
 	if app.Settings().AcknowledgedNoticeVersion() < NoticeVersion {
 		vm.SetView(ConsentViewID)
-	} else if app.Settings().Address() == "" {
+	} else if app.Arbor().StoreLocked() {
+		vm.SetView(StoreEncryptionViewID)
+	} else if app.Arbor().StoreError() != nil {
+		vm.SetView(StoreRecoveryViewID)
+	} else if len(app.Settings().Addresses()) == 0 {
 		vm.SetView(ConnectFormID)
 	} else if app.Settings().ActiveArborIdentityID() == nil {
 		vm.SetView(IdentityFormID)
+	} else if app.Settings().KeyLocked() {
+		vm.SetView(KeyPassphraseViewID)
 	} else if len(app.Settings().Subscriptions()) < 1 {
 		vm.SetView(SubscriptionSetupFormViewID)
 	} else {
@@ -113,7 +151,7 @@ func eventLoop(w *app.Window) error {
 				if profiler.Recorder != nil {
 					profiler.Record(gtx)
 				}
-				if invalidate {
+				if opts.invalidate {
 					op.InvalidateOp{}.Add(gtx.Ops)
 				}
 				th := app.Theme().Current()
@@ -161,8 +199,37 @@ const (
 	SubscriptionViewID
 	SubscriptionSetupFormViewID
 	DynamicChatViewID
+	RelayPinsViewID
+	StoreRecoveryViewID
+	ArchiveViewID
+	SearchViewID
+	IntegrityViewID
+	RetentionViewID
+	StoreEncryptionViewID
+	StatisticsViewID
+	IdentitiesViewID
+	KeyPassphraseViewID
+	ProfileViewID
 )
 
+// runIntegrityCheck checks the store within dataDir and prints the report.
+// The mode selects whether corrupt node files are quarantined.
+func runIntegrityCheck(dataDir, mode string) error {
+	var quarantine bool
+	switch mode {
+	case "report":
+	case "quarantine":
+		quarantine = true
+	default:
+		return fmt.Errorf("unknown -check-store mode %q", mode)
+	}
+	report, err := core.CheckStoreIntegrity(dataDir, quarantine)
+	if report != nil {
+		fmt.Print(report)
+	}
+	return err
+}
+
 // getDataDir returns application specific file directory to use for storage.
 // Suffix is joined to the path for convenience.
 func getDataDir(suffix string) (string, error) {
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+	sprigTheme "git.sr.ht/~whereswaldon/sprig/widget/theme"
+)
+
+// ProfileView edits the profile published in the metadata of the active
+// identity.
+type ProfileView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Bio, Color, Contact widget.Editor
+	SaveButton          widget.Clickable
+
+	// Save publishes the profile in the background.
+	Save BackgroundOperation
+}
+
+var _ View = &ProfileView{}
+
+func NewProfileView(app core.App) View {
+	c := &ProfileView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	c.Color.SingleLine = true
+	c.Contact.SingleLine = true
+	return c
+}
+
+func (c *ProfileView) HandleIntent(intent Intent) {}
+
+func (c *ProfileView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Profile", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *ProfileView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *ProfileView) BecomeVisible() {
+	var profile ds.Profile
+	if identity, err := c.Settings().Identity(); err == nil {
+		profile = ds.IdentityProfile(identity)
+	}
+	c.Bio.SetText(profile.Bio)
+	c.Color.SetText(profile.Color)
+	c.Contact.SetText(profile.Contact)
+	c.Save.SetResult("")
+}
+
+// profile returns the profile entered in the editors.
+func (c *ProfileView) profile() ds.Profile {
+	return ds.Profile{
+		Bio:     strings.TrimSpace(c.Bio.Text()),
+		Color:   strings.ToLower(strings.TrimSpace(c.Color.Text())),
+		Contact: strings.TrimSpace(c.Contact.Text()),
+	}
+}
+
+func (c *ProfileView) Update(gtx layout.Context) {
+	c.Save.Update()
+	if c.SaveButton.Clicked() {
+		profile := c.profile()
+		if err := profile.Validate(); err != nil {
+			c.Save.SetResult("Invalid profile: " + err.Error())
+			return
+		}
+		c.save(profile)
+	}
+}
+
+// save publishes the profile in the background.
+func (c *ProfileView) save(profile ds.Profile) {
+	c.Save.Run(c.manager, func() (string, error) {
+		identity, err := c.Settings().SetProfile(profile)
+		if err == nil {
+			// The heartbeat signs as the identity that was replaced.
+			c.Arbor().StartHeartbeat()
+			if storeErr := c.Arbor().Store().Add(identity); storeErr != nil {
+				err = fmt.Errorf("failed storing identity: %w", storeErr)
+			}
+		}
+		switch {
+		case errors.Is(err, core.ErrKeyLocked):
+			c.Save.Then(func() {
+				c.manager.RequestViewSwitch(KeyPassphraseViewID)
+			})
+			return "", nil
+		case err != nil:
+			return "Failed saving profile: " + err.Error(), nil
+		default:
+			return fmt.Sprintf("Saved. Your new ID is %s. Messages you post from now on show this profile.", identity.ID()), nil
+		}
+	})
+}
+
+func (c *ProfileView) Layout(gtx layout.Context) layout.Dimensions {
+	sTheme := c.Theme().Current()
+	theme := sTheme.Theme
+	working, result := c.Save.State()
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	editor := func(editor *widget.Editor, hint string) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Editor(theme, editor, hint).Layout)
+		})
+	}
+	identity, err := c.Settings().Identity()
+	if err != nil {
+		line(material.H6(theme, "There is no active identity."))
+	} else {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, sprigTheme.AuthorName(sTheme, string(identity.Name.Blob), identity.ID(), false).Profile(c.profile()).Layout)
+		})
+		line(material.Body2(theme, "Your profile is published with your identity and shown to everyone who reads your messages."))
+		line(material.Body1(theme, "Saving changes your ID."))
+		line(material.Body2(theme, "Published identities cannot change, so saving creates a new identity with the same name and key but a different ID. Others see the messages you post from now on under the new ID. Messages you already posted keep the previous ID and profile, and Sprig still treats them as yours."))
+		editor(&c.Bio, "Bio")
+		editor(&c.Color, "Name color, such as #3a7bd5")
+		editor(&c.Contact, "Contact")
+		if working {
+			items = append(items, func(gtx C) D {
+				return itemInset.Layout(gtx, material.Loader(theme).Layout)
+			})
+		} else {
+			items = append(items, func(gtx C) D {
+				return itemInset.Layout(gtx, material.Button(theme, &c.SaveButton, "Save").Layout)
+			})
+		}
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *ProfileView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
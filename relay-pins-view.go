@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// RelayPinsView lists the certificates pinned for each TLS relay and allows
+// the user to accept changed certificates or revoke pins.
+type RelayPinsView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Pins []PinControl
+}
+
+// PinControl holds the UI state for managing a single certificate pin.
+type PinControl struct {
+	core.Pin
+	Accept, Revoke widget.Clickable
+}
+
+var _ View = &RelayPinsView{}
+
+func NewRelayPinsView(app core.App) View {
+	c := &RelayPinsView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *RelayPinsView) HandleIntent(intent Intent) {}
+
+func (c *RelayPinsView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Relay Certificates", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *RelayPinsView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *RelayPinsView) BecomeVisible() {
+	c.refreshPins()
+}
+
+// refreshPins rebuilds the pin controls from the pin store.
+func (c *RelayPinsView) refreshPins() {
+	pins := c.Sprout().Pins().Pins()
+	c.Pins = make([]PinControl, len(pins))
+	for i, pin := range pins {
+		c.Pins[i].Pin = pin
+	}
+}
+
+func (c *RelayPinsView) Update(gtx layout.Context) {
+	changed := false
+	for i := range c.Pins {
+		pin := &c.Pins[i]
+		if pin.Accept.Clicked() {
+			if err := c.Sprout().Pins().Accept(pin.Address); err != nil {
+				log.Printf("failed accepting certificate: %v", err)
+				continue
+			}
+			c.reconnectHost(pin.Address)
+			changed = true
+		}
+		if pin.Revoke.Clicked() {
+			if err := c.Sprout().Pins().Revoke(pin.Address); err != nil {
+				log.Printf("failed revoking pin: %v", err)
+				continue
+			}
+			changed = true
+		}
+	}
+	if changed {
+		c.refreshPins()
+	}
+}
+
+// reconnectHost restarts the connection to every configured TLS relay at the
+// given HOST:PORT so that a newly-accepted certificate takes effect.
+func (c *RelayPinsView) reconnectHost(host string) {
+	for _, addr := range c.Settings().Addresses() {
+		u, err := core.ParseAddress(addr)
+		if err != nil || u.Scheme != "tls" || u.Host != host {
+			continue
+		}
+		c.Sprout().Reconnect(addr)
+	}
+}
+
+func (c *RelayPinsView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	if len(c.Pins) == 0 {
+		return layout.UniformInset(unit.Dp(8)).Layout(gtx, material.Body1(theme, "No relay certificates have been pinned yet.").Layout)
+	}
+	return material.List(theme, &c.List).Layout(gtx, len(c.Pins), func(gtx C, index int) D {
+		pin := &c.Pins[index]
+		return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+			return component.Surface(theme).Layout(gtx, func(gtx C) D {
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return itemInset.Layout(gtx, func(gtx C) D {
+					return c.layoutPin(gtx, theme, pin)
+				})
+			})
+		})
+	})
+}
+
+// layoutPin displays the details of a single pin along with its controls.
+func (c *RelayPinsView) layoutPin(gtx C, theme *material.Theme, pin *PinControl) D {
+	items := []layout.FlexChild{
+		layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.H6(theme, pin.Address).Layout)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "Pinned: "+pin.Fingerprint).Layout)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "First seen: "+pin.FirstSeen.Local().Format("2006-01-02 15:04")).Layout)
+		}),
+	}
+	if pin.Pending != "" {
+		items = append(items, layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body1(theme, "Refused changed certificate: "+pin.Pending).Layout)
+		}))
+		trust := "The new certificate is not signed by a trusted certificate authority. Only accept it if the relay operator announced the change."
+		if pin.PendingTrusted {
+			trust = "The new certificate is signed by a trusted certificate authority. Accept it if you expected the relay to change certificates."
+		}
+		items = append(items, layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, trust).Layout)
+		}))
+	}
+	items = append(items, layout.Rigid(func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx C) D {
+				if pin.Pending == "" {
+					return D{}
+				}
+				return itemInset.Layout(gtx, material.Button(theme, &pin.Accept, "Accept new certificate").Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				return itemInset.Layout(gtx, material.Button(theme, &pin.Revoke, "Revoke").Layout)
+			}),
+		)
+	}))
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, items...)
+}
+
+func (c *RelayPinsView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"log"
@@ -9,11 +11,13 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"gioui.org/io/clipboard"
 	"gioui.org/io/key"
 	"gioui.org/layout"
+	"gioui.org/op"
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
@@ -148,6 +152,13 @@ type ReplyListView struct {
 	CreateConversationButton            widget.Clickable
 	JumpToBottomButton, JumpToTopButton widget.Clickable
 	HideDescendantsButton               widget.Clickable
+	DetailsButton, CloseDetailsButton   widget.Clickable
+	ExportButton                        widget.Clickable
+	ProfileButton, CloseProfileButton   widget.Clickable
+
+	// ShowDetails is whether the details panel for the focused message is
+	// visible.
+	ShowDetails bool
 
 	LoadMoreHistoryButton widget.Clickable
 	// how many nodes of history does the view want
@@ -165,8 +176,23 @@ type ReplyListView struct {
 	maxRepliesVisible int
 	// Loading if replies are loading.
 	loading bool
+
+	// changeLock guards the changes below, which the store subscribers
+	// record for Update to apply on the UI goroutine.
+	changeLock sync.Mutex
+	// listChanged is set when replies were inserted or removed.
+	listChanged bool
+	// removed holds the IDs of the replies removed from the list.
+	removed []*fields.QualifiedHash
+	// lastRefilter is when the replies that are no longer shown, such as
+	// expired ones, were last dropped from the list.
+	lastRefilter time.Time
 }
 
+// refilterInterval is how often replies that are no longer shown are
+// dropped from the reply list.
+const refilterInterval = time.Minute
+
 var _ View = &ReplyListView{}
 
 // NewReplyListView constructs a ReplyList that relies on the provided App.
@@ -190,6 +216,9 @@ func NewReplyListView(app core.App) View {
 	c.MessageList.HiddenChildren = func(r ds.ReplyData) int {
 		return c.HiddenTracker.NumDescendants(r.ID)
 	}
+	c.MessageList.DeliveryStatus = func(r ds.ReplyData) string {
+		return c.deliveryStatus(r)
+	}
 	c.loading = true
 	go func() {
 		defer func() { c.loading = false }()
@@ -205,18 +234,32 @@ func NewReplyListView(app core.App) View {
 		})
 		c.MessageList.Axis = layout.Vertical
 		// ensure that we are notified when we need to refresh the state of visible nodes
-		c.Arbor().Store().SubscribeToNewMessages(func(node forest.Node) {
+		insert := func(node forest.Node) {
 			go func() {
 				var rd ds.ReplyData
 				if !rd.Populate(node, c.Arbor().Store()) {
 					return
 				}
 				c.AlphaReplyList.Insert(rd)
-				c.FocusTracker.Invalidate()
+				c.recordListChange()
 				c.manager.RequestInvalidate()
 				c.HiddenTracker.Process(node)
 			}()
-		})
+		}
+		c.Arbor().Store().SubscribeToNewMessages(insert)
+		if notifier, ok := c.Arbor().Store().(ds.ReplayNotifier); ok {
+			notifier.SubscribeToReplays(insert)
+		}
+		// drop messages as soon as they are purged from the store
+		if notifier, ok := c.Arbor().Store().(ds.RemovalNotifier); ok {
+			notifier.SubscribeToRemovals(func(ids []*fields.QualifiedHash) {
+				go func() {
+					c.AlphaReplyList.Remove(ids...)
+					c.recordListChange(ids...)
+					c.manager.RequestInvalidate()
+				}()
+			})
+		}
 		c.MessageList.ScrollToEnd = true
 		c.MessageList.Position.BeforeEnd = false
 		c.loadMoreHistory()
@@ -224,6 +267,59 @@ func NewReplyListView(app core.App) View {
 	return c
 }
 
+// recordListChange records that the reply list changed, removing the
+// replies with the given IDs, so that the next Update refreshes the focus.
+// It is safe to call from any goroutine.
+func (c *ReplyListView) recordListChange(removed ...*fields.QualifiedHash) {
+	c.changeLock.Lock()
+	defer c.changeLock.Unlock()
+	c.listChanged = true
+	c.removed = append(c.removed, removed...)
+}
+
+// applyListChanges drops the replies that are no longer shown, such as
+// replies that expired while on screen, and refreshes the focus after the
+// reply list changed. The focus is cleared if the focused reply was removed.
+func (c *ReplyListView) applyListChanges(gtx layout.Context) {
+	if gtx.Now.Sub(c.lastRefilter) >= refilterInterval {
+		c.lastRefilter = gtx.Now
+		if removed := c.AlphaReplyList.Refilter(); len(removed) > 0 {
+			c.recordListChange(removed...)
+		}
+	}
+	op.InvalidateOp{At: c.lastRefilter.Add(refilterInterval)}.Add(gtx.Ops)
+	c.changeLock.Lock()
+	changed, removed := c.listChanged, c.removed
+	c.listChanged, c.removed = false, nil
+	c.changeLock.Unlock()
+	if !changed {
+		return
+	}
+	c.FocusTracker.Invalidate()
+	if focused := c.FocusTracker.Focused; focused != nil {
+		for _, id := range removed {
+			if id.Equals(focused.ID) {
+				c.FocusTracker.SetFocus(nil)
+				break
+			}
+		}
+	}
+}
+
+// deliveryStatus describes the delivery state of replies authored by the
+// local user. It returns the empty string for all other replies.
+func (c *ReplyListView) deliveryStatus(r ds.ReplyData) string {
+	self := c.Settings().ActiveArborIdentityID()
+	if self == nil || !self.Equals(r.AuthorID) {
+		return ""
+	}
+	state, ok := c.Outbox().State(r.ID)
+	if !ok {
+		return ""
+	}
+	return state.String()
+}
+
 // Filtered returns whether or not the ReplyList is currently filtering
 // its contents.
 func (c *ReplyListView) Filtered() bool {
@@ -231,7 +327,53 @@ func (c *ReplyListView) Filtered() bool {
 }
 
 // HandleIntent processes requests from other views in the application.
-func (c *ReplyListView) HandleIntent(intent Intent) {}
+func (c *ReplyListView) HandleIntent(intent Intent) {
+	if intent.ID != ViewReplyWithID {
+		return
+	}
+	details, ok := intent.Details.(ViewReplyWithIDDetails)
+	if !ok {
+		return
+	}
+	id := &fields.QualifiedHash{}
+	if err := id.UnmarshalText([]byte(details.NodeID)); err != nil {
+		log.Printf("failed parsing reply id %q: %v", details.NodeID, err)
+		return
+	}
+	c.jumpTo(id)
+}
+
+// jumpTo focuses the reply with the given ID, loading it from the store if
+// it is older than the history loaded so far.
+func (c *ReplyListView) jumpTo(id *fields.QualifiedHash) {
+	if !c.AlphaReplyList.Contains(id) {
+		node, has, err := c.Arbor().Store().Get(id)
+		if err != nil || !has {
+			log.Printf("failed loading reply %s: %v", id, err)
+			return
+		}
+		var rd ds.ReplyData
+		if !rd.Populate(node, c.Arbor().Store()) {
+			return
+		}
+		c.AlphaReplyList.Insert(rd)
+	}
+	if c.Filtered() {
+		c.FilterState = Off
+	}
+	index := c.AlphaReplyList.IndexForID(id)
+	if index < 0 {
+		return
+	}
+	c.AlphaReplyList.WithReplies(func(replies []ds.ReplyData) {
+		if index >= len(replies) {
+			return
+		}
+		c.FocusTracker.SetFocus(&replies[index])
+		c.ensureFocusedVisible(index)
+	})
+	c.requestKeyboardFocus()
+}
 
 // BecomeVisible handles setup for when this view becomes the visible
 // view in the application.
@@ -359,7 +501,20 @@ func (c *ReplyListView) getContextualActions() ([]materials.AppBarAction, []mate
 				return btn.Layout(gtx)
 			},
 		},
-	}, []materials.OverflowAction{}
+	}, []materials.OverflowAction{
+		{
+			Name: "Message details",
+			Tag:  &c.DetailsButton,
+		},
+		{
+			Name: "Author profile",
+			Tag:  &c.ProfileButton,
+		},
+		{
+			Name: "Export replies to archive",
+			Tag:  &c.ExportButton,
+		},
+	}
 }
 
 // triggerReplyContextMenu changes the app bar to contextual mode and
@@ -511,8 +666,13 @@ func (c *ReplyListView) sendReply() {
 	replyText = strings.TrimSpace(replyText)
 
 	nodeBuilder, err := c.Settings().Builder()
-	if err != nil {
+	if errors.Is(err, core.ErrKeyLocked) {
+		// Keep the draft while the user unlocks their key.
+		c.manager.RequestViewSwitch(KeyPassphraseViewID)
+		return
+	} else if err != nil {
 		log.Printf("failed acquiring node builder: %v", err)
+		return
 	}
 	author = nodeBuilder.User
 	if c.Composer.ComposingConversation() {
@@ -554,18 +714,13 @@ func (c *ReplyListView) sendReply() {
 	c.resetReplyState()
 }
 
-// postReplies actually adds the replies to the store of history (and
-// causes them to be sent because the sprout working is watching the
-// store for updates).
+// postReplies adds the replies to the store of history and queues them
+// in the outbox for delivery to the connected relays.
 func (c *ReplyListView) postReplies(author *forest.Identity, replies []*forest.Reply) {
 	go func() {
 		for _, reply := range replies {
-			if err := c.Arbor().Store().Add(author); err != nil {
-				log.Printf("failed adding replying identity to store: %v", err)
-				return
-			}
-			if err := c.Arbor().Store().Add(reply); err != nil {
-				log.Printf("failed adding reply to store: %v", err)
+			if err := c.Outbox().Send(author, reply); err != nil {
+				log.Printf("failed sending reply: %v", err)
 				return
 			}
 		}
@@ -644,6 +799,7 @@ func (c *ReplyListView) startConversation() {
 
 // Update updates the state of the view in response to user input events.
 func (c *ReplyListView) Update(gtx layout.Context) {
+	c.applyListChanges(gtx)
 	c.replyCount = func() (count int) {
 		c.AlphaReplyList.WithReplies(func(replies []ds.ReplyData) {
 			count = len(replies)
@@ -730,6 +886,24 @@ func (c *ReplyListView) Update(gtx layout.Context) {
 	if c.Focused != nil && (c.CreateReplyButton.Clicked() || overflowTag == &c.CreateReplyButton) {
 		c.startReply()
 	}
+	if c.Focused != nil && (c.DetailsButton.Clicked() || overflowTag == &c.DetailsButton) {
+		c.ShowDetails = !c.ShowDetails
+	}
+	if c.CloseDetailsButton.Clicked() {
+		c.ShowDetails = false
+	}
+	if c.Focused != nil && (c.ProfileButton.Clicked() || overflowTag == &c.ProfileButton) {
+		focused := *c.Focused
+		c.manager.RequestModal(gtx, func(gtx C) D {
+			return c.layoutProfile(gtx, focused)
+		})
+	}
+	if c.CloseProfileButton.Clicked() {
+		c.manager.DismissModal(gtx)
+	}
+	if c.Focused != nil && (c.ExportButton.Clicked() || overflowTag == &c.ExportButton) {
+		c.exportFocused()
+	}
 	if c.CreateConversationButton.Clicked() || overflowTag == &c.CreateConversationButton {
 		c.startConversation()
 	}
@@ -880,6 +1054,12 @@ func (c *ReplyListView) Layout(gtx layout.Context) layout.Dimensions {
 				layout.Flexed(1, func(gtx C) D {
 					return c.layoutReplyList(gtx)
 				}),
+				layout.Rigid(func(gtx C) D {
+					if !c.ShowDetails || c.Focused == nil {
+						return layout.Dimensions{}
+					}
+					return c.layoutDetails(gtx)
+				}),
 				layout.Rigid(func(gtx C) D {
 					if c.shouldDisplayEditor() {
 						return c.layoutEditor(gtx)
@@ -981,6 +1161,113 @@ func (c *ReplyListView) layoutEditor(gtx layout.Context) layout.Dimensions {
 	return dims
 }
 
+// exportFocused writes the focused reply and its descendants to an archive
+// in the background, announcing the outcome in a banner.
+func (c *ReplyListView) exportFocused() {
+	focused := *c.Focused
+	go func() {
+		banner := &core.MessageBanner{Priority: core.Info}
+		path, count, err := exportArchive(c.App, focused.ID, "replies-"+focused.AuthorName)
+		if err != nil {
+			banner.Priority = core.Warn
+			banner.Text = fmt.Sprintf("Export failed: %v", err)
+		} else {
+			banner.Text = fmt.Sprintf("Exported %d nodes to %s", count, path)
+		}
+		c.Banner().Add(banner)
+		time.AfterFunc(10*time.Second, func() {
+			banner.Cancel()
+			c.manager.RequestInvalidate()
+		})
+	}()
+}
+
+// layoutDetails renders the details of the focused message, including the
+// relays known to have it.
+func (c *ReplyListView) layoutDetails(gtx layout.Context) layout.Dimensions {
+	var (
+		th      = c.Theme().Current().Theme
+		focused = c.Focused
+	)
+	line := func(text string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(th, text).Layout)
+		})
+	}
+	items := []layout.FlexChild{
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return itemInset.Layout(gtx, material.H6(th, "Message details").Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(th, &c.CloseDetailsButton, "Close").Layout)
+				}),
+			)
+		}),
+		line("ID: " + focused.ID.String()),
+		line("Author: " + focused.AuthorName + " (" + focused.AuthorID.String() + ")"),
+		line("Community: " + focused.CommunityName),
+		line("Created: " + focused.CreatedAt.Local().Format("2006-01-02 15:04:05")),
+	}
+	if status := c.deliveryStatus(*focused); status != "" {
+		items = append(items, line("Delivery: "+status))
+	}
+	propagation := c.Arbor().Propagation().For(focused.ID)
+	if len(propagation) == 0 {
+		items = append(items, line("Not seen on any relay this session"))
+	}
+	for _, p := range propagation {
+		items = append(items, line(strings.Title(p.Kind.String())+" "+p.Relay+" at "+p.At.Local().Format("15:04:05")))
+	}
+	return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx C) D {
+		return materials.Surface(th).Layout(gtx, func(gtx C) D {
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return itemInset.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, items...)
+			})
+		})
+	})
+}
+
+// layoutProfile renders the profile published by the author of a message.
+func (c *ReplyListView) layoutProfile(gtx layout.Context, reply ds.ReplyData) layout.Dimensions {
+	var (
+		sTheme  = c.Theme().Current()
+		th      = sTheme.Theme
+		profile = reply.AuthorProfile
+	)
+	items := []layout.FlexChild{
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return itemInset.Layout(gtx, sprigTheme.AuthorName(sTheme, reply.AuthorName, reply.AuthorID, c.Status().IsActive(reply.AuthorID)).Profile(profile).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(th, &c.CloseProfileButton, "Close").Layout)
+				}),
+			)
+		}),
+	}
+	line := func(style material.LabelStyle) {
+		items = append(items, layout.Rigid(func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		}))
+	}
+	if profile.IsZero() {
+		line(material.Body2(th, "This author has not published a profile."))
+	}
+	if profile.Bio != "" {
+		line(material.Body1(th, profile.Bio))
+	}
+	if profile.Contact != "" {
+		line(material.Body2(th, "Contact: "+profile.Contact))
+	}
+	return itemInset.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, items...)
+	})
+}
+
 // SetManager configures the view manager for this view.
 func (c *ReplyListView) SetManager(mgr ViewManager) {
 	c.manager = mgr
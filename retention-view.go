@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// RetentionView configures how many messages of each community are kept.
+type RetentionView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Policies    []RetentionControl
+	PruneButton widget.Clickable
+
+	// Pruning applies the retention policies in the background.
+	Pruning BackgroundOperation
+}
+
+// RetentionControl holds the UI state for the retention policy of a single
+// community.
+type RetentionControl struct {
+	*forest.Community
+	Rule   widget.Enum
+	Amount widget.Editor
+}
+
+var _ View = &RetentionView{}
+
+func NewRetentionView(app core.App) View {
+	c := &RetentionView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *RetentionView) HandleIntent(intent Intent) {}
+
+func (c *RetentionView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Message Retention", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *RetentionView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *RetentionView) BecomeVisible() {
+	c.Arbor().Communities().WithCommunities(func(communities []*forest.Community) {
+		c.Policies = make([]RetentionControl, len(communities))
+		for i, community := range communities {
+			control := &c.Policies[i]
+			control.Community = community
+			control.Amount.SingleLine = true
+			policy := c.Settings().RetentionPolicy(community.ID().String())
+			control.Rule.Value = string(policy.Rule)
+			if policy.Active() {
+				control.Amount.SetText(strconv.Itoa(policy.Amount))
+			}
+		}
+	})
+}
+
+func (c *RetentionView) Update(gtx layout.Context) {
+	changed := false
+	for i := range c.Policies {
+		control := &c.Policies[i]
+		amountChanged := false
+		for _, event := range control.Amount.Events() {
+			if _, ok := event.(widget.ChangeEvent); ok {
+				amountChanged = true
+			}
+		}
+		if !control.Rule.Changed() && !amountChanged {
+			continue
+		}
+		policy := core.RetentionPolicy{Rule: core.RetentionRule(control.Rule.Value)}
+		policy.Amount, _ = strconv.Atoi(strings.TrimSpace(control.Amount.Text()))
+		c.Settings().SetRetentionPolicy(control.ID().String(), policy)
+		changed = true
+	}
+	if changed {
+		go c.Settings().Persist()
+	}
+	if c.PruneButton.Clicked() {
+		c.prune()
+	}
+}
+
+// prune applies the retention policies in the background.
+func (c *RetentionView) prune() {
+	c.Pruning.Run(c.manager, func() (string, error) {
+		result := c.Arbor().Prune(context.Background())
+		return "Pruning " + result.String() + ".", nil
+	})
+}
+
+func (c *RetentionView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	pruning, status := c.Pruning.State()
+
+	items := []layout.Widget{
+		func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body2(theme, "Old messages are removed hourly according to each community's policy. Your own messages, and the messages they reply to, are always kept.").Layout)
+		},
+	}
+	for i := range c.Policies {
+		control := &c.Policies[i]
+		items = append(items, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.H6(theme, string(control.Name.Blob)).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(material.RadioButton(theme, &control.Rule, string(core.KeepEverything), "Keep everything").Layout),
+						layout.Rigid(material.RadioButton(theme, &control.Rule, string(core.KeepDays), "Keep days").Layout),
+						layout.Rigid(material.RadioButton(theme, &control.Rule, string(core.KeepLatest), "Keep latest per conversation").Layout),
+					)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if core.RetentionRule(control.Rule.Value) == core.KeepEverything {
+						return D{}
+					}
+					return itemInset.Layout(gtx, material.Editor(theme, &control.Amount, "Number to keep").Layout)
+				}),
+			)
+		})
+	}
+	items = append(items, func(gtx C) D {
+		if pruning {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		}
+		return itemInset.Layout(gtx, material.Button(theme, &c.PruneButton, "Prune now").Layout)
+	})
+	if status != "" {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Body1(theme, status).Layout)
+		})
+	}
+	return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+		return items[index](gtx)
+	})
+}
+
+func (c *RetentionView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
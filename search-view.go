@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+	"git.sr.ht/~whereswaldon/sprig/ds"
+	"git.sr.ht/~whereswaldon/sprig/icons"
+)
+
+// searchResultLimit is the number of matching replies displayed.
+const searchResultLimit = 200
+
+// searchSnippetLength is the number of characters of each matching reply
+// displayed.
+const searchSnippetLength = 280
+
+// SearchView finds replies by their content.
+type SearchView struct {
+	manager ViewManager
+
+	core.App
+
+	Query widget.Editor
+	widget.List
+	Results []SearchResult
+}
+
+// SearchResult holds the UI state for a single matching reply.
+type SearchResult struct {
+	ds.ReplyData
+	widget.Clickable
+}
+
+var _ View = &SearchView{}
+
+func NewSearchView(app core.App) View {
+	c := &SearchView{
+		App: app,
+	}
+	c.Query.SingleLine = true
+	c.Query.Submit = true
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *SearchView) HandleIntent(intent Intent) {}
+
+func (c *SearchView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Search", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *SearchView) NavItem() *materials.NavItem {
+	return &materials.NavItem{
+		Tag:  c,
+		Name: "Search",
+		Icon: icons.SearchIcon,
+	}
+}
+
+func (c *SearchView) BecomeVisible() {
+	c.Query.Focus()
+	c.runSearch()
+}
+
+// runSearch replaces the results with the replies matching the query.
+func (c *SearchView) runSearch() {
+	matches := c.Search().Search(c.Query.Text(), searchResultLimit)
+	c.Results = make([]SearchResult, len(matches))
+	for i, match := range matches {
+		c.Results[i].ReplyData = match
+	}
+}
+
+func (c *SearchView) Update(gtx layout.Context) {
+	for _, event := range c.Query.Events() {
+		switch event.(type) {
+		case widget.ChangeEvent, widget.SubmitEvent:
+			c.runSearch()
+		}
+	}
+	for i := range c.Results {
+		result := &c.Results[i]
+		if result.Clicked() {
+			c.manager.ExecuteIntent(Intent{
+				ID: ViewReplyWithID,
+				Details: ViewReplyWithIDDetails{
+					NodeID: result.ID.String(),
+				},
+			})
+		}
+	}
+}
+
+func (c *SearchView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+				return materials.Surface(theme).Layout(gtx, func(gtx C) D {
+					gtx.Constraints.Min.X = gtx.Constraints.Max.X
+					return itemInset.Layout(gtx, material.Editor(theme, &c.Query, "Search messages").Layout)
+				})
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			var status string
+			switch {
+			case !c.Search().Ready():
+				status = "Indexing stored messages..."
+			case strings.TrimSpace(c.Query.Text()) == "":
+				return D{}
+			case len(c.Results) == 0:
+				status = "No messages found."
+			default:
+				return D{}
+			}
+			return itemInset.Layout(gtx, material.Body2(theme, status).Layout)
+		}),
+		layout.Flexed(1, func(gtx C) D {
+			return material.List(theme, &c.List).Layout(gtx, len(c.Results), func(gtx C, index int) D {
+				return layout.Inset{Left: unit.Dp(8), Right: unit.Dp(8), Bottom: unit.Dp(8)}.Layout(gtx, func(gtx C) D {
+					return c.layoutResult(gtx, theme, &c.Results[index])
+				})
+			})
+		}),
+	)
+}
+
+// layoutResult displays a matching reply with its author, community, and
+// date.
+func (c *SearchView) layoutResult(gtx C, theme *material.Theme, result *SearchResult) D {
+	content := result.Content
+	if runes := []rune(content); len(runes) > searchSnippetLength {
+		content = string(runes[:searchSnippetLength]) + "…"
+	}
+	context := result.AuthorName + " in " + result.CommunityName + " · " + result.CreatedAt.Local().Format("2006-01-02 15:04")
+	return materials.Surface(theme).Layout(gtx, func(gtx C) D {
+		return material.Clickable(gtx, &result.Clickable, func(gtx C) D {
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return itemInset.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						return itemInset.Layout(gtx, material.Caption(theme, context).Layout)
+					}),
+					layout.Rigid(func(gtx C) D {
+						return itemInset.Layout(gtx, material.Body1(theme, content).Layout)
+					}),
+				)
+			})
+		})
+	})
+}
+
+func (c *SearchView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
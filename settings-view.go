@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"gioui.org/layout"
 	"gioui.org/unit"
@@ -10,6 +13,7 @@ import (
 	"gioui.org/x/component"
 	materials "gioui.org/x/component"
 	"git.sr.ht/~whereswaldon/sprig/core"
+	"git.sr.ht/~whereswaldon/sprig/ds"
 	"git.sr.ht/~whereswaldon/sprig/icons"
 	sprigWidget "git.sr.ht/~whereswaldon/sprig/widget"
 	sprigTheme "git.sr.ht/~whereswaldon/sprig/widget/theme"
@@ -22,7 +26,19 @@ type SettingsView struct {
 
 	widget.List
 	ConnectionForm          sprigWidget.TextForm
+	Relays                  []RelayControl
+	PinsButton              widget.Clickable
+	StoreRecoveryButton     widget.Clickable
+	ArchivesButton          widget.Clickable
+	IntegrityButton         widget.Clickable
+	RetentionButton         widget.Clickable
+	EncryptionButton        widget.Clickable
+	StatisticsButton        widget.Clickable
+	ProxyForm               sprigWidget.TextForm
 	IdentityButton          widget.Clickable
+	IdentitiesButton        widget.Clickable
+	KeyPassphraseButton     widget.Clickable
+	ProfileButton           widget.Clickable
 	CommunityList           layout.List
 	CommunityBoxes          []widget.Bool
 	ProfilingSwitch         widget.Bool
@@ -36,6 +52,15 @@ type SettingsView struct {
 	UseOrchardStoreSwitch   widget.Bool
 }
 
+// RelayControl holds the UI state for managing the connection to a single
+// relay.
+type RelayControl struct {
+	Address           string
+	Reconnect, Remove widget.Clickable
+	// ProxyForm sets the proxy used for this relay only.
+	ProxyForm sprigWidget.TextForm
+}
+
 type Section struct {
 	*material.Theme
 	Heading string
@@ -91,9 +116,10 @@ func NewCommunityMenuView(app core.App) View {
 		App: app,
 	}
 	c.List.Axis = layout.Vertical
-	c.ConnectionForm.TextField.SetText(c.Settings().Address())
 	c.ConnectionForm.TextField.SingleLine = true
 	c.ConnectionForm.TextField.Submit = true
+	c.ProxyForm.TextField.SingleLine = true
+	c.ProxyForm.TextField.Submit = true
 	return c
 }
 
@@ -121,16 +147,87 @@ func (c *SettingsView) Update(gtx layout.Context) {
 	if c.IdentityButton.Clicked() {
 		c.manager.RequestViewSwitch(IdentityFormID)
 	}
+	if c.IdentitiesButton.Clicked() {
+		c.manager.RequestViewSwitch(IdentitiesViewID)
+	}
+	if c.KeyPassphraseButton.Clicked() {
+		c.manager.RequestViewSwitch(KeyPassphraseViewID)
+	}
+	if c.ProfileButton.Clicked() {
+		c.manager.RequestViewSwitch(ProfileViewID)
+	}
 	if c.ProfilingSwitch.Changed() {
 		c.manager.SetProfiling(c.ProfilingSwitch.Value)
 	}
 	if c.ThemeingSwitch.Changed() {
 		c.manager.SetThemeing(c.ThemeingSwitch.Value)
 	}
+	if c.PinsButton.Clicked() {
+		c.manager.RequestViewSwitch(RelayPinsViewID)
+	}
+	if c.StoreRecoveryButton.Clicked() {
+		c.manager.RequestViewSwitch(StoreRecoveryViewID)
+	}
+	if c.ArchivesButton.Clicked() {
+		c.manager.RequestViewSwitch(ArchiveViewID)
+	}
+	if c.IntegrityButton.Clicked() {
+		c.manager.RequestViewSwitch(IntegrityViewID)
+	}
+	if c.RetentionButton.Clicked() {
+		c.manager.RequestViewSwitch(RetentionViewID)
+	}
+	if c.EncryptionButton.Clicked() {
+		c.manager.RequestViewSwitch(StoreEncryptionViewID)
+	}
+	if c.StatisticsButton.Clicked() {
+		c.manager.RequestViewSwitch(StatisticsViewID)
+	}
 	if c.ConnectionForm.Submitted() {
-		c.Settings().SetAddress(c.ConnectionForm.TextField.Text())
+		addr := c.ConnectionForm.TextField.Text()
+		c.Settings().AddAddress(addr)
+		c.Sprout().ConnectTo(addr)
+		c.ConnectionForm.TextField.SetText("")
+		c.refreshRelays()
+		settingsChanged = true
+	}
+	if c.ProxyForm.Submitted() {
+		proxy := c.ProxyForm.TextField.Text()
+		if _, err := core.NewProxyDialer(proxy); err != nil {
+			log.Printf("rejecting proxy: %v", err)
+		} else {
+			c.Settings().SetProxy(proxy)
+			for _, addr := range c.Settings().Addresses() {
+				c.Sprout().Reconnect(addr)
+			}
+			settingsChanged = true
+		}
+	}
+	relaysChanged := false
+	for i := range c.Relays {
+		relay := &c.Relays[i]
+		if relay.Reconnect.Clicked() {
+			c.Sprout().Reconnect(relay.Address)
+		}
+		if relay.ProxyForm.Submitted() {
+			proxy := relay.ProxyForm.TextField.Text()
+			if _, err := core.NewProxyDialer(proxy); err != nil {
+				log.Printf("rejecting proxy for %s: %v", relay.Address, err)
+			} else {
+				c.Settings().SetRelayProxy(relay.Address, proxy)
+				c.Sprout().Reconnect(relay.Address)
+				settingsChanged = true
+			}
+		}
+		if relay.Remove.Clicked() {
+			c.Sprout().Disconnect(relay.Address)
+			c.Settings().RemoveAddress(relay.Address)
+			relaysChanged = true
+		}
+	}
+	if relaysChanged {
+		c.refreshRelays()
 		settingsChanged = true
-		c.Sprout().ConnectTo(c.Settings().Address())
 	}
 	if c.NotificationsSwitch.Changed() {
 		c.Settings().SetNotificationsGloballyAllowed(c.NotificationsSwitch.Value)
@@ -166,13 +263,27 @@ func (c *SettingsView) Update(gtx layout.Context) {
 	}
 }
 
+// refreshRelays rebuilds the relay controls from the configured addresses.
+func (c *SettingsView) refreshRelays() {
+	addresses := c.Settings().Addresses()
+	c.Relays = make([]RelayControl, len(addresses))
+	for i, addr := range addresses {
+		relay := &c.Relays[i]
+		relay.Address = addr
+		relay.ProxyForm.TextField.SingleLine = true
+		relay.ProxyForm.TextField.Submit = true
+		relay.ProxyForm.TextField.SetText(c.Settings().RelayProxy(addr))
+	}
+}
+
 func (c *SettingsView) BecomeVisible() {
-	c.ConnectionForm.TextField.SetText(c.Settings().Address())
+	c.refreshRelays()
 	c.NotificationsSwitch.Value = c.Settings().NotificationsGloballyAllowed()
 	c.BottomBarSwitch.Value = c.Settings().BottomAppBar()
 	c.DockNavSwitch.Value = c.Settings().DockNavDrawer()
 	c.DarkModeSwitch.Value = c.Settings().DarkMode()
 	c.UseOrchardStoreSwitch.Value = c.Settings().UseOrchardStore()
+	c.ProxyForm.TextField.SetText(c.Settings().Proxy())
 }
 
 func (c *SettingsView) Layout(gtx layout.Context) layout.Dimensions {
@@ -183,29 +294,72 @@ func (c *SettingsView) Layout(gtx layout.Context) layout.Dimensions {
 			Heading: "Identity",
 			Items: []layout.Widget{
 				func(gtx C) D {
-					if c.Settings().ActiveArborIdentityID() != nil {
-						id, _ := c.Settings().Identity()
-						return itemInset.Layout(gtx, sprigTheme.AuthorName(sTheme, string(id.Name.Blob), id.ID(), true).Layout)
+					if id, err := c.Settings().Identity(); err == nil {
+						return itemInset.Layout(gtx, sprigTheme.AuthorName(sTheme, string(id.Name.Blob), id.ID(), true).Profile(ds.IdentityProfile(id)).Layout)
 					}
 					return itemInset.Layout(gtx, material.Button(theme, &c.IdentityButton, "Create new Identity").Layout)
 				},
-			},
-		},
-		{
-			Heading: "Connection",
-			Items: []layout.Widget{
 				SimpleSectionItem{
 					Theme: theme,
 					Control: func(gtx C) D {
-						return itemInset.Layout(gtx, func(gtx C) D {
-							form := sprigTheme.TextForm(sTheme, &c.ConnectionForm, "Connect", "HOST:PORT")
-							return form.Layout(gtx)
-						})
+						return itemInset.Layout(gtx, material.Button(theme, &c.IdentitiesButton, "Manage identities").Layout)
 					},
-					Context: "You can restart your connection to a relay by hitting the Connect button above without changing the address.",
+					Context: "Switch between identities, such as separate work and personal ones.",
 				}.Layout,
+				func(gtx C) D {
+					if c.Settings().ActiveArborIdentityID() == nil {
+						return D{}
+					}
+					return SimpleSectionItem{
+						Theme: theme,
+						Control: func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &c.KeyPassphraseButton, "Key passphrase").Layout)
+						},
+						Context: "Require a passphrase before posting as the active identity.",
+					}.Layout(gtx)
+				},
+				func(gtx C) D {
+					if c.Settings().ActiveArborIdentityID() == nil {
+						return D{}
+					}
+					return SimpleSectionItem{
+						Theme: theme,
+						Control: func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &c.ProfileButton, "Edit profile").Layout)
+						},
+						Context: "Publish a bio, a name color, and contact details with the active identity.",
+					}.Layout(gtx)
+				},
 			},
 		},
+		{
+			Heading: "Connection",
+			Items: append(c.relayItems(sTheme), SimpleSectionItem{
+				Theme: theme,
+				Control: func(gtx C) D {
+					return itemInset.Layout(gtx, func(gtx C) D {
+						form := sprigTheme.TextForm(sTheme, &c.ConnectionForm, "Add", "HOST:PORT")
+						return form.Layout(gtx)
+					})
+				},
+				Context: "Sprig connects to every relay in this list at once. Addresses may start with tls://, tcp://, or unix:// and default to TLS.",
+			}.Layout, SimpleSectionItem{
+				Theme: theme,
+				Control: func(gtx C) D {
+					return itemInset.Layout(gtx, func(gtx C) D {
+						form := sprigTheme.TextForm(sTheme, &c.ProxyForm, "Set", "socks5://HOST:PORT")
+						return form.Layout(gtx)
+					})
+				},
+				Context: "Connect to relays through a SOCKS5 (socks5://) or HTTP CONNECT (http://) proxy. Use socks5h:// to have the proxy resolve relay hostnames, as Tor requires. Leave empty to connect directly. Relays with a proxy of their own ignore this one.",
+			}.Layout, SimpleSectionItem{
+				Theme: theme,
+				Control: func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, &c.PinsButton, "Review certificates").Layout)
+				},
+				Context: "Sprig remembers the certificate of each TLS relay the first time it connects and refuses certificates that change unexpectedly.",
+			}.Layout),
+		},
 		{
 			Heading: "Notifications",
 			Items: []layout.Widget{
@@ -246,8 +400,55 @@ func (c *SettingsView) Layout(gtx layout.Context) layout.Dimensions {
 							}),
 						)
 					},
-					Context: "Orchard is a single-file read-oriented database for storing nodes.",
+					Context: "Orchard is a single-file read-oriented database for storing nodes. Existing messages are migrated to the selected store after restarting Sprig.",
 				}.Layout,
+				SimpleSectionItem{
+					Theme: theme,
+					Control: func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, &c.EncryptionButton, "Store encryption").Layout)
+					},
+					Context: "Protect stored messages with a passphrase that is required every time Sprig starts.",
+				}.Layout,
+				SimpleSectionItem{
+					Theme: theme,
+					Control: func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, &c.ArchivesButton, "Import & export archives").Layout)
+					},
+					Context: "Archives hold the history of a community so that it can be backed up or moved to another device without a relay.",
+				}.Layout,
+				SimpleSectionItem{
+					Theme: theme,
+					Control: func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, &c.IntegrityButton, "Check store integrity").Layout)
+					},
+					Context: "Find damaged or incomplete messages and move damaged files into quarantine.",
+				}.Layout,
+				SimpleSectionItem{
+					Theme: theme,
+					Control: func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, &c.RetentionButton, "Message retention").Layout)
+					},
+					Context: "Limit how long the messages of each community are stored on this device.",
+				}.Layout,
+				SimpleSectionItem{
+					Theme: theme,
+					Control: func(gtx C) D {
+						return itemInset.Layout(gtx, material.Button(theme, &c.StatisticsButton, "Storage statistics").Layout)
+					},
+					Context: "See how many messages are stored for each community and how much space they use.",
+				}.Layout,
+				func(gtx C) D {
+					if c.Arbor().StoreError() == nil {
+						return D{}
+					}
+					return SimpleSectionItem{
+						Theme: theme,
+						Control: func(gtx C) D {
+							return itemInset.Layout(gtx, material.Button(theme, &c.StoreRecoveryButton, "Recover store").Layout)
+						},
+						Context: "The message store could not be opened normally.",
+					}.Layout(gtx)
+				},
 			},
 		},
 		{
@@ -344,6 +545,61 @@ func (c *SettingsView) Layout(gtx layout.Context) layout.Dimensions {
 	})
 }
 
+// relayStatusText describes a relay connection status for display.
+// Failures caused by the proxy are distinguished from those of the relay.
+func relayStatusText(status core.ConnectionStatus) string {
+	var proxyErr *core.ProxyError
+	switch status.State {
+	case core.BackingOff:
+		wait := time.Until(status.RetryAt).Round(time.Second)
+		if wait < 0 {
+			wait = 0
+		}
+		if errors.As(status.Err, &proxyErr) {
+			return fmt.Sprintf("%s (proxy error, retry in %v)", status.State, wait)
+		}
+		return fmt.Sprintf("%s (retry in %v)", status.State, wait)
+	case core.Failed:
+		if status.Err != nil {
+			return fmt.Sprintf("%s: %v", status.State, status.Err)
+		}
+	}
+	return status.State.String()
+}
+
+// relayItems returns a row of controls for each configured relay, followed
+// by its proxy.
+func (c *SettingsView) relayItems(sTheme *sprigTheme.Theme) []layout.Widget {
+	theme := sTheme.Theme
+	items := make([]layout.Widget, 0, 2*len(c.Relays))
+	for i := range c.Relays {
+		relay := &c.Relays[i]
+		items = append(items, func(gtx C) D {
+			status := relayStatusText(c.Sprout().Status(relay.Address))
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return itemInset.Layout(gtx, material.Body1(theme, relay.Address).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Body2(theme, status).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, &relay.Reconnect, "Reconnect").Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, &relay.Remove, "Remove").Layout)
+				}),
+			)
+		}, func(gtx C) D {
+			return itemInset.Layout(gtx, func(gtx C) D {
+				form := sprigTheme.TextForm(sTheme, &relay.ProxyForm, "Set", "Relay proxy, \"direct\", or empty for the global proxy")
+				return form.Layout(gtx)
+			})
+		})
+	}
+	return items
+}
+
 func (c *SettingsView) SetManager(mgr ViewManager) {
 	c.manager = mgr
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// StatisticsView summarizes the contents of the message store.
+type StatisticsView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	RefreshButton widget.Clickable
+
+	// Refresh computes the statistics in the background.
+	Refresh BackgroundOperation
+	stats   *core.StoreStatistics
+}
+
+var _ View = &StatisticsView{}
+
+func NewStatisticsView(app core.App) View {
+	c := &StatisticsView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *StatisticsView) HandleIntent(intent Intent) {}
+
+func (c *StatisticsView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Storage Statistics", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *StatisticsView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *StatisticsView) BecomeVisible() {
+	c.refresh()
+}
+
+func (c *StatisticsView) Update(gtx layout.Context) {
+	c.Refresh.Update()
+	if c.RefreshButton.Clicked() {
+		c.refresh()
+	}
+}
+
+// refresh computes the statistics in the background.
+func (c *StatisticsView) refresh() {
+	c.Refresh.Run(c.manager, func() (string, error) {
+		stats, err := c.Arbor().Statistics(context.Background())
+		c.Refresh.Then(func() {
+			c.stats = stats
+		})
+		if err != nil {
+			return "Failed computing statistics: " + err.Error(), nil
+		}
+		return "", nil
+	})
+}
+
+// formatTime describes a time for display, or "never" if it is unset.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func (c *StatisticsView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	working, result := c.Refresh.State()
+	stats := c.stats
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	if working {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		})
+	} else {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Button(theme, &c.RefreshButton, "Refresh").Layout)
+		})
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	if stats != nil {
+		line(material.H6(theme, "Store"))
+		line(material.Body1(theme, fmt.Sprintf("%s, %s on disk", stats.Backend, core.FormatBytes(stats.DiskSize))))
+		if c.Arbor().StoreError() != nil || c.Arbor().StoreLocked() {
+			line(material.Body2(theme, "The store is unavailable, so the counts only include messages received since launch."))
+		}
+		line(material.Body1(theme, fmt.Sprintf("%d replies, %d communities, %d identities", stats.Replies, stats.Communities, stats.Identities)))
+		if stats.Truncated {
+			line(material.Body2(theme, "The store is too large to count completely, so these numbers are lower bounds."))
+		}
+		line(material.Body1(theme, fmt.Sprintf("%d distinct authors", stats.Authors)))
+		line(material.Body1(theme, "Oldest message: "+formatTime(stats.Oldest)))
+		line(material.Body1(theme, "Newest message: "+formatTime(stats.Newest)))
+
+		line(material.H6(theme, "Communities"))
+		for _, community := range stats.PerCommunity {
+			line(material.Body1(theme, fmt.Sprintf("%s: %d replies by %d authors, newest %s",
+				string(community.Name.Blob), community.Replies, community.Authors, formatTime(community.Newest))))
+		}
+
+		line(material.H6(theme, "Removals since launch"))
+		activity := stats.Activity
+		line(material.Body1(theme, fmt.Sprintf("Expiration removed %d expired messages with their replies (last removal: %s)", activity.Expired, formatTime(activity.LastPurge))))
+		line(material.Body1(theme, "Retention policies "+activity.Pruned.String()+", last applied "+formatTime(activity.LastPrune)))
+		line(material.Body2(theme, "Computed "+formatTime(stats.Computed)+"."))
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *StatisticsView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
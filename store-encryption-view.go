@@ -0,0 +1,173 @@
+package main
+
+import (
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// StoreEncryptionView unlocks the encrypted message store, and turns store
+// encryption on or off.
+type StoreEncryptionView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	Passphrase, Confirmation  widget.Editor
+	UnlockButton, LaterButton widget.Clickable
+	EncryptButton             widget.Clickable
+	DecryptButton             widget.Clickable
+
+	// Operation unlocks the store or starts migrating it in the background.
+	Operation BackgroundOperation
+}
+
+var _ View = &StoreEncryptionView{}
+
+func NewStoreEncryptionView(app core.App) View {
+	c := &StoreEncryptionView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	for _, editor := range []*widget.Editor{&c.Passphrase, &c.Confirmation} {
+		editor.SingleLine = true
+		editor.Submit = true
+		editor.Mask = '•'
+	}
+	return c
+}
+
+func (c *StoreEncryptionView) HandleIntent(intent Intent) {}
+
+func (c *StoreEncryptionView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Store Encryption", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *StoreEncryptionView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *StoreEncryptionView) BecomeVisible() {
+	c.Passphrase.SetText("")
+	c.Confirmation.SetText("")
+	c.Operation.SetResult("")
+	c.Passphrase.Focus()
+}
+
+func (c *StoreEncryptionView) Update(gtx layout.Context) {
+	c.Operation.Update()
+	submitted := false
+	for _, editor := range []*widget.Editor{&c.Passphrase, &c.Confirmation} {
+		for _, event := range editor.Events() {
+			if _, ok := event.(widget.SubmitEvent); ok {
+				submitted = true
+			}
+		}
+	}
+	locked := c.Arbor().StoreLocked()
+	if c.UnlockButton.Clicked() || (submitted && locked) {
+		passphrase := c.Passphrase.Text()
+		c.Operation.Run(c.manager, func() (string, error) {
+			if err := c.Arbor().UnlockStore(passphrase); err != nil {
+				return "", err
+			}
+			c.Operation.Then(func() {
+				c.manager.RequestViewSwitch(ReplyViewID)
+			})
+			return "Unlocked.", nil
+		})
+	}
+	if c.LaterButton.Clicked() {
+		c.manager.RequestViewSwitch(ReplyViewID)
+	}
+	if c.EncryptButton.Clicked() || (submitted && !locked && !c.Settings().EncryptStore()) {
+		passphrase := c.Passphrase.Text()
+		if passphrase != c.Confirmation.Text() {
+			c.Operation.SetResult("The passphrases do not match.")
+		} else {
+			c.Operation.Run(c.manager, func() (string, error) {
+				if err := c.Arbor().EnableEncryption(passphrase); err != nil {
+					return "", err
+				}
+				return "Encrypting the message store. Sprig switches to the encrypted store once the migration finishes.", nil
+			})
+		}
+	}
+	if c.DecryptButton.Clicked() {
+		c.Operation.Run(c.manager, func() (string, error) {
+			if err := c.Arbor().DisableEncryption(); err != nil {
+				return "", err
+			}
+			return "Store encryption turned off. Sprig switches to the unencrypted store once any migration finishes.", nil
+		})
+	}
+}
+
+func (c *StoreEncryptionView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	working, result := c.Operation.State()
+	locked := c.Arbor().StoreLocked()
+	encrypted := c.Settings().EncryptStore()
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	editor := func(editor *widget.Editor, hint string) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Editor(theme, editor, hint).Layout)
+		})
+	}
+	button := func(clickable *widget.Clickable, label string) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Button(theme, clickable, label).Layout)
+		})
+	}
+	switch {
+	case locked:
+		line(material.H6(theme, "The message store is locked."))
+		line(material.Body1(theme, "Enter the store passphrase to use the encrypted message store. Until then, new messages are kept in memory and saved once the store is unlocked."))
+		editor(&c.Passphrase, "Passphrase")
+		if !working {
+			button(&c.UnlockButton, "Unlock")
+			button(&c.LaterButton, "Later")
+		}
+	case encrypted:
+		line(material.H6(theme, "The message store is encrypted."))
+		line(material.Body1(theme, "Messages are stored encrypted with a key protected by your passphrase. Turning encryption off migrates them back into a plaintext store."))
+		if !working {
+			button(&c.DecryptButton, "Turn off encryption")
+		}
+	default:
+		line(material.H6(theme, "The message store is not encrypted."))
+		line(material.Body1(theme, "Encrypting the store protects stored messages from anyone with access to this device. You will need the passphrase every time Sprig starts, and stored messages cannot be recovered without it."))
+		editor(&c.Passphrase, "Passphrase")
+		editor(&c.Confirmation, "Confirm passphrase")
+		if !working {
+			button(&c.EncryptButton, "Encrypt store")
+		}
+	}
+	if working {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		})
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *StoreEncryptionView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
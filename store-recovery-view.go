@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	materials "gioui.org/x/component"
+	"git.sr.ht/~whereswaldon/sprig/core"
+)
+
+// StoreRecoveryView explains why the message store failed to open and
+// offers ways to recover from it.
+type StoreRecoveryView struct {
+	manager ViewManager
+
+	core.App
+
+	widget.List
+	RetryButton, ReadOnlyButton, FreshButton widget.Clickable
+	ContinueButton                           widget.Clickable
+
+	// Recovery attempts the recovery in the background.
+	Recovery BackgroundOperation
+}
+
+var _ View = &StoreRecoveryView{}
+
+func NewStoreRecoveryView(app core.App) View {
+	c := &StoreRecoveryView{
+		App: app,
+	}
+	c.List.Axis = layout.Vertical
+	return c
+}
+
+func (c *StoreRecoveryView) HandleIntent(intent Intent) {}
+
+func (c *StoreRecoveryView) AppBarData() (bool, string, []materials.AppBarAction, []materials.OverflowAction) {
+	return true, "Store Recovery", []materials.AppBarAction{}, []materials.OverflowAction{}
+}
+
+func (c *StoreRecoveryView) NavItem() *materials.NavItem {
+	return nil
+}
+
+func (c *StoreRecoveryView) BecomeVisible() {
+}
+
+func (c *StoreRecoveryView) Update(gtx layout.Context) {
+	if c.RetryButton.Clicked() {
+		c.recover(core.RecoverByRetrying)
+	}
+	if c.ReadOnlyButton.Clicked() {
+		c.recover(core.RecoverReadOnly)
+	}
+	if c.FreshButton.Clicked() {
+		c.recover(core.RecoverWithFreshStore)
+	}
+	if c.ContinueButton.Clicked() {
+		c.manager.RequestViewSwitch(ReplyViewID)
+	}
+}
+
+// recover attempts to recover the store in the background.
+func (c *StoreRecoveryView) recover(strategy core.StoreRecovery) {
+	c.Recovery.Run(c.manager, func() (string, error) {
+		if err := c.Arbor().RecoverStore(strategy); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Recovery succeeded (%s).", strategy), nil
+	})
+}
+
+func (c *StoreRecoveryView) Layout(gtx layout.Context) layout.Dimensions {
+	theme := c.Theme().Current().Theme
+	storeErr := c.Arbor().StoreError()
+	readOnly := c.Arbor().StoreReadOnly()
+	working, result := c.Recovery.State()
+
+	var items []layout.Widget
+	line := func(style material.LabelStyle) {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, style.Layout)
+		})
+	}
+	switch {
+	case storeErr == nil:
+		line(material.H6(theme, "The message store is working normally."))
+	case readOnly:
+		line(material.H6(theme, "The message store is open read-only."))
+		line(material.Body1(theme, "Existing messages are available, but new messages will not be saved."))
+	default:
+		line(material.H6(theme, "The message store could not be opened."))
+		line(material.Body1(theme, "Messages received or written now are kept in memory and will be lost when Sprig exits."))
+	}
+	if storeErr != nil {
+		line(material.Body2(theme, "Store: "+c.Settings().StoreBackend().String()))
+		line(material.Body2(theme, "Location: "+c.Settings().DataPath()))
+		line(material.Body2(theme, "Error: "+storeErr.Error()))
+	}
+	if result != "" {
+		line(material.Body1(theme, result))
+	}
+	if working {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Loader(theme).Layout)
+		})
+	}
+	button := func(clickable *widget.Clickable, label, context string) {
+		items = append(items, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Button(theme, clickable, label).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return itemInset.Layout(gtx, material.Body2(theme, context).Layout)
+				}),
+			)
+		})
+	}
+	if storeErr != nil && !working {
+		button(&c.RetryButton, "Retry", "Try opening the store again, for instance after freeing disk space or closing another copy of Sprig.")
+		if !readOnly {
+			button(&c.ReadOnlyButton, "Open read-only", "Read the existing messages without changing the store.")
+		}
+		button(&c.FreshButton, "Move aside and start fresh", "Keep the damaged store next to the new one and begin with an empty store.")
+	}
+	if storeErr == nil || readOnly {
+		items = append(items, func(gtx C) D {
+			return itemInset.Layout(gtx, material.Button(theme, &c.ContinueButton, "Continue").Layout)
+		})
+	}
+	return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx C) D {
+		return material.List(theme, &c.List).Layout(gtx, len(items), func(gtx C, index int) D {
+			return items[index](gtx)
+		})
+	})
+}
+
+func (c *StoreRecoveryView) SetManager(mgr ViewManager) {
+	c.manager = mgr
+}
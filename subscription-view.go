@@ -218,8 +218,12 @@ func (c *SubStateManager) Refresh() {
 func (c *SubStateManager) reconcileSubscriptions(changes []Sub) []Sub {
 	for _, sub := range changes {
 		for _, addr := range sub.ActiveHostingRelays {
-			timeout := time.NewTicker(time.Second * 5)
 			worker := c.Sprout().WorkerFor(addr)
+			if worker == nil {
+				log.Printf("Not changing sub for %s on relay %s: not connected", sub.ID(), addr)
+				continue
+			}
+			timeout := time.NewTicker(time.Second * 5)
 			var subFunc func(*forest.Community, <-chan time.Time) error
 			var sessionFunc func(*fields.QualifiedHash)
 			if !sub.Subbed.Value {
@@ -252,6 +256,9 @@ func (c *SubStateManager) refreshSubs() []Sub {
 	for _, conn := range c.Sprout().Connections() {
 		func() {
 			worker := c.Sprout().WorkerFor(conn)
+			if worker == nil {
+				return
+			}
 			worker.Session.RLock()
 			defer worker.Session.RUnlock()
 			response, err := worker.SendList(fields.NodeTypeCommunity, 1024, time.NewTicker(time.Second*5).C)
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"image"
+	"image/color"
 	"runtime"
 	"time"
 
@@ -44,6 +45,10 @@ type ViewManager interface {
 	DismissContextualBar(gtx layout.Context)
 	// request that an app bar overflow menu disappear
 	DismissOverflow(gtx layout.Context)
+	// show a widget in a popup above the current view until it is dismissed
+	RequestModal(gtx layout.Context, widget layout.Widget)
+	// request that any popup disappear
+	DismissModal(gtx layout.Context)
 	// get the tag of a selected overflow message
 	SelectedOverflowTag() interface{}
 	// render the interface
@@ -198,6 +203,27 @@ func (vm *viewManager) DismissOverflow(gtx layout.Context) {
 	vm.AppBar.CloseOverflowMenu(gtx.Now)
 }
 
+func (vm *viewManager) RequestModal(gtx layout.Context, widget layout.Widget) {
+	vm.ModalLayer.Widget = func(gtx C, th *material.Theme, anim *materials.VisibilityAnimation) D {
+		return layout.Center.Layout(gtx, func(gtx C) D {
+			return layout.UniformInset(unit.Dp(16)).Layout(gtx, func(gtx C) D {
+				if max := gtx.Px(unit.Dp(400)); gtx.Constraints.Max.X > max {
+					gtx.Constraints.Max.X = max
+				}
+				return materials.Surface(th).Layout(gtx, func(gtx C) D {
+					gtx.Constraints.Min.X = gtx.Constraints.Max.X
+					return widget(gtx)
+				})
+			})
+		})
+	}
+	vm.ModalLayer.Appear(gtx.Now)
+}
+
+func (vm *viewManager) DismissModal(gtx layout.Context) {
+	vm.ModalLayer.Disappear(gtx.Now)
+}
+
 func (vm *viewManager) SelectedOverflowTag() interface{} {
 	return vm.selectedOverflowTag
 }
@@ -263,6 +289,40 @@ func (vm *viewManager) Layout(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+// errorBannerColor is the background of banners with Error priority.
+var errorBannerColor = color.NRGBA{R: 0xb0, G: 0x00, B: 0x20, A: 0xff}
+
+// bannerTheme returns a copy of the material theme styled for a banner of
+// the given priority.
+func bannerTheme(th *sprigTheme.Theme, priority core.Priority) material.Theme {
+	pair := th.Secondary.Default
+	if priority >= core.Error {
+		pair = sprigTheme.PairFor(errorBannerColor)
+	}
+	out := *(th.Theme)
+	out.ContrastFg = out.Fg
+	out.ContrastBg = out.Bg
+	out.Palette = sprigTheme.ApplyAsNormal(out.Palette, pair)
+	return out
+}
+
+// layoutBanner fills the background of a banner and lays out its contents
+// across the available width.
+func layoutBanner(gtx C, th *material.Theme, children ...layout.FlexChild) D {
+	return layout.Stack{}.Layout(gtx,
+		layout.Expanded(func(gtx C) D {
+			paint.FillShape(gtx.Ops, th.Bg, clip.Rect(image.Rectangle{Max: gtx.Constraints.Min}).Op())
+			return D{Size: gtx.Constraints.Min}
+		}),
+		layout.Stacked(func(gtx C) D {
+			return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx C) D {
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return layout.Flex{Spacing: layout.SpaceAround}.Layout(gtx, children...)
+			})
+		}),
+	)
+}
+
 func (vm *viewManager) layoutCurrentView(gtx layout.Context) layout.Dimensions {
 	view := vm.views[vm.current]
 	view.Update(gtx)
@@ -271,25 +331,15 @@ func (vm *viewManager) layoutCurrentView(gtx layout.Context) layout.Dimensions {
 	banner := func(gtx C) D {
 		switch bannerConfig := vm.App.Banner().Top().(type) {
 		case *core.LoadingBanner:
-			secondary := th.Secondary.Default
-			th := *(th.Theme)
-			th.ContrastFg = th.Fg
-			th.ContrastBg = th.Bg
-			th.Palette = sprigTheme.ApplyAsNormal(th.Palette, secondary)
-			return layout.Stack{}.Layout(gtx,
-				layout.Expanded(func(gtx C) D {
-					paint.FillShape(gtx.Ops, th.Bg, clip.Rect(image.Rectangle{Max: gtx.Constraints.Min}).Op())
-					return D{Size: gtx.Constraints.Min}
-				}),
-				layout.Stacked(func(gtx C) D {
-					return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx C) D {
-						gtx.Constraints.Min.X = gtx.Constraints.Max.X
-						return layout.Flex{Spacing: layout.SpaceAround}.Layout(gtx,
-							layout.Rigid(material.Body1(&th, bannerConfig.Text).Layout),
-							layout.Rigid(material.Loader(&th).Layout),
-						)
-					})
-				}),
+			th := bannerTheme(th, bannerConfig.Priority)
+			return layoutBanner(gtx, &th,
+				layout.Rigid(material.Body1(&th, bannerConfig.Text).Layout),
+				layout.Rigid(material.Loader(&th).Layout),
+			)
+		case *core.MessageBanner:
+			th := bannerTheme(th, bannerConfig.Priority)
+			return layoutBanner(gtx, &th,
+				layout.Rigid(material.Body1(&th, bannerConfig.Text).Layout),
 			)
 		default:
 			return D{}
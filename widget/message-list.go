@@ -37,6 +37,9 @@ type MessageList struct {
 	StatusOf       func(reply ds.ReplyData) ReplyStatus
 	HiddenChildren func(reply ds.ReplyData) int
 	UserIsActive   func(identity *fields.QualifiedHash) bool
+	// DeliveryStatus optionally describes whether a reply has been
+	// delivered to a relay. Empty strings are not displayed.
+	DeliveryStatus func(reply ds.ReplyData) string
 	Animation
 	events []MessageListEvent
 }
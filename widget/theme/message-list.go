@@ -160,6 +160,9 @@ func (m MessageListStyle) Layout(gtx C) D {
 								if anim.Begin&sprigWidget.Anchor > 0 {
 									rs = rs.Anchoring(th.Theme, m.State.HiddenChildren(reply))
 								}
+								if m.State.DeliveryStatus != nil {
+									rs = rs.Delivery(th.Theme, m.State.DeliveryStatus(reply))
+								}
 
 								return rs.Layout(gtx)
 
@@ -160,6 +160,8 @@ type ReplyStyle struct {
 	AuthorNameStyle
 	CommunityNameStyle ForestRefStyle
 	DateStyle          material.LabelStyle
+	// DeliveryStyle presents the delivery state of the reply, if known.
+	DeliveryStyle material.LabelStyle
 
 	// Padding configures the padding surrounding the entire interior content of the
 	// rendered message.
@@ -177,7 +179,7 @@ func Reply(th *Theme, status *sprigWidget.ReplyAnimationState, nodes ds.ReplyDat
 		ShowActive:          showActive,
 		Content:             text,
 		BadgeColor:          th.Primary.Dark.Bg,
-		AuthorNameStyle:     AuthorName(th, nodes.AuthorName, nodes.AuthorID, showActive),
+		AuthorNameStyle:     AuthorName(th, nodes.AuthorName, nodes.AuthorID, showActive).Profile(nodes.AuthorProfile),
 		CommunityNameStyle:  CommunityName(th.Theme, nodes.CommunityName, nodes.CommunityID),
 		Padding:             layout.UniformInset(unit.Dp(8)),
 		MetadataPadding:     layout.Inset{Bottom: unit.Dp(4)},
@@ -213,6 +215,20 @@ func (r ReplyStyle) Anchoring(th *material.Theme, numNodes int) ReplyStyle {
 	return r
 }
 
+// Delivery modifies the ReplyStyle to display the provided delivery state
+// alongside the date. Empty states are not displayed.
+func (r ReplyStyle) Delivery(th *material.Theme, state string) ReplyStyle {
+	if state == "" {
+		r.DeliveryStyle = material.LabelStyle{}
+		return r
+	}
+	r.DeliveryStyle = material.Body2(th, state)
+	r.DeliveryStyle.MaxLines = 1
+	r.DeliveryStyle.Color.A = 200
+	r.DeliveryStyle.TextSize = unit.Dp(12)
+	return r
+}
+
 // Layout renders the ReplyStyle.
 func (r ReplyStyle) Layout(gtx layout.Context) layout.Dimensions {
 	var progress float32
@@ -328,7 +344,7 @@ func (r ReplyStyle) layoutMetadata(gtx layout.Context) layout.Dimensions {
 	communityWidget := communityMacro.Stop()
 
 	dateMacro := op.Record(gtx.Ops)
-	dateDim := r.DateStyle.Layout(gtx)
+	dateDim := r.layoutDate(gtx)
 	dateWidget := dateMacro.Stop()
 
 	gtx.Constraints.Min.Y = max(nameDim.Size.Y, communityDim.Size.Y, dateDim.Size.Y)
@@ -369,6 +385,23 @@ func (r ReplyStyle) layoutMetadata(gtx layout.Context) layout.Dimensions {
 	return layout.Flex{Spacing: layout.SpaceBetween}.Layout(gtx, flexChildren...)
 }
 
+// layoutDate renders the date of the reply followed by its delivery state,
+// if any.
+func (r ReplyStyle) layoutDate(gtx layout.Context) layout.Dimensions {
+	if r.DeliveryStyle == (material.LabelStyle{}) {
+		return r.DateStyle.Layout(gtx)
+	}
+	delivery := r.DeliveryStyle
+	delivery.Color = r.finalConfig.TextColor
+	delivery.Color.A = r.DeliveryStyle.Color.A
+	return layout.Flex{Alignment: layout.Baseline}.Layout(gtx,
+		layout.Rigid(r.DateStyle.Layout),
+		layout.Rigid(func(gtx C) D {
+			return layout.Inset{Left: unit.Dp(4)}.Layout(gtx, delivery.Layout)
+		}),
+	)
+}
+
 func (r ReplyStyle) layoutContents(gtx layout.Context) layout.Dimensions {
 	if !r.CollapseMetadata {
 		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
@@ -384,7 +417,7 @@ func (r ReplyStyle) layoutContents(gtx layout.Context) layout.Dimensions {
 		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 			return r.layoutContent(gtx)
 		}),
-		layout.Rigid(r.DateStyle.Layout),
+		layout.Rigid(r.layoutDate),
 	)
 }
 
@@ -452,6 +485,15 @@ func AuthorName(theme *Theme, authorName string, authorID *fields.QualifiedHash,
 	return a
 }
 
+// Profile modifies the AuthorNameStyle to render the name in the author's
+// preferred color, if their profile has one.
+func (a AuthorNameStyle) Profile(profile ds.Profile) AuthorNameStyle {
+	if c, ok := ds.ParseColor(profile.Color); ok {
+		a.NameStyle.Color = c
+	}
+	return a
+}
+
 // Layout renders the AuthorNameStyle.
 func (a AuthorNameStyle) Layout(gtx layout.Context) layout.Dimensions {
 	return layout.Flex{}.Layout(gtx,